@@ -105,21 +105,22 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 	return shader, nil
 }
 
-// makeVao initializes and returns a vertex array from the points provided.
-func makeVao(points []float32) uint32 {
-	var vbo uint32
+// makeVao initializes and returns a vertex array from the points provided,
+// and the buffer holding them so both can be deleted.
+func makeVao(points []float32) (vao, vbo uint32) {
 	gl.GenBuffers(1, &vbo)
 	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
 	gl.BufferData(gl.ARRAY_BUFFER, 4*len(points), gl.Ptr(points), gl.STATIC_DRAW)
 
-	var vao uint32
 	gl.GenVertexArrays(1, &vao)
 	gl.BindVertexArray(vao)
 	gl.EnableVertexAttribArray(0)
 	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
 	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 0, nil)
+	gl.BindVertexArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
 
-	return vao
+	return vao, vbo
 }
 
 // generate a unit circle on XY-plane
@@ -136,15 +137,31 @@ func getCircleVertices(x float32, y float32, z float32, r float32, vertices int)
 	return unitCircleVertices
 }
 
-// draw all primitives
-func drawCylinder(h float32, r float32, slices int, vertices int) {
+// cylinder holds the vertex arrays of the primitives of a cylinder, they are
+// made once and drawn every frame
+type cylinder struct {
+	vaos   []uint32
+	vbos   []uint32
+	modes  []uint32
+	counts []int32
+}
+
+func (c *cylinder) add(points []float32, mode uint32) {
+	vao, vbo := makeVao(points)
+	c.vaos = append(c.vaos, vao)
+	c.vbos = append(c.vbos, vbo)
+	c.modes = append(c.modes, mode)
+	c.counts = append(c.counts, int32(len(points)/3))
+}
+
+// makeCylinder generates all primitives
+func makeCylinder(h float32, r float32, slices int, vertices int) *cylinder {
+	var c cylinder
 	var top = getCircleVertices(0, 0, h/2, 1, vertices)
 
 	var bottom = getCircleVertices(0, 0, -h/2, 1, vertices)
-	makeVao(top)
-	gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(top)/3))
-	makeVao(bottom)
-	gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(bottom)/3))
+	c.add(top, gl.TRIANGLE_FAN)
+	c.add(bottom, gl.TRIANGLE_FAN)
 	for slice := 0; slice < slices; slice++ {
 		var stripe []float32
 		for i := 3; i < len(bottom); i += 3 {
@@ -155,9 +172,23 @@ func drawCylinder(h float32, r float32, slices int, vertices int) {
 			stripe = append(stripe, bottom[i+1]+float32(slice+1)*(h/float32(slices))) // y
 			stripe = append(stripe, bottom[i+2])                                      // y
 		}
-		makeVao(stripe)
-		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(stripe)/3))
+		c.add(stripe, gl.TRIANGLE_STRIP)
 	}
+	return &c
+}
+
+// draw all primitives
+func (c *cylinder) draw() {
+	for i, vao := range c.vaos {
+		gl.BindVertexArray(vao)
+		gl.DrawArrays(c.modes[i], 0, c.counts[i])
+	}
+	gl.BindVertexArray(0)
+}
+
+func (c *cylinder) delete() {
+	gl.DeleteVertexArrays(int32(len(c.vaos)), &c.vaos[0])
+	gl.DeleteBuffers(int32(len(c.vbos)), &c.vbos[0])
 }
 
 // main function
@@ -187,6 +218,8 @@ func main() {
 	gl.PolygonMode(gl.FRONT_AND_BACK, gl.LINE)
 	angle := 0.0
 	previousTime := glfw.GetTime()
+	shape := makeCylinder(4, 1, 4, 8)
+	defer shape.delete()
 
 	for !window.ShouldClose() {
 		// clear bg
@@ -198,7 +231,7 @@ func main() {
 		angle += elapsed
 		model = mgl32.HomogRotate3D(float32(angle), mgl32.Vec3{0, 1, 0})
 		gl.UniformMatrix4fv(modelUniform, 1, false, &model[0])
-		drawCylinder(4, 1, 4, 8)
+		shape.draw()
 
 		// update events
 		glfw.PollEvents()
@@ -0,0 +1,51 @@
+// iblbake precomputes the image based lighting maps of a Radiance .hdr
+// panorama and caches them in a file that gfx.LoadIBLData reads, so the
+// scenes do not spend seconds on it at startup
+//
+//	go run ./cmd/iblbake -in images/sky.hdr -out images/sky.ibl
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/StevenTarazona/glcore/gfx"
+)
+
+func main() {
+	defaults := gfx.DefaultIBLOptions()
+	in := flag.String("in", "", "equirectangular .hdr image")
+	out := flag.String("out", "", "cache file to write")
+	envSize := flag.Int("env", defaults.EnvironmentSize, "size of the environment cubemap faces")
+	irradianceSize := flag.Int("irradiance", defaults.IrradianceSize, "size of the irradiance cubemap faces")
+	levels := flag.Int("levels", defaults.PrefilterLevels, "roughness levels of the prefiltered cubemap")
+	samples := flag.Int("samples", defaults.PrefilterSamples, "samples per texel of the prefiltered cubemap")
+	brdfSize := flag.Int("brdf", defaults.BRDFSize, "size of the brdf lookup table")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		flag.Usage()
+		log.Fatal("-in and -out are required")
+	}
+
+	opts := gfx.IBLOptions{
+		EnvironmentSize:  *envSize,
+		IrradianceSize:   *irradianceSize,
+		PrefilterLevels:  *levels,
+		PrefilterSamples: *samples,
+		BRDFSize:         *brdfSize,
+		BRDFSamples:      defaults.BRDFSamples,
+	}
+
+	start := time.Now()
+	img, err := gfx.LoadHDR(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data := gfx.ComputeIBL(img, opts)
+	if err := data.Save(*out); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("baked %s into %s in %v", *in, *out, time.Since(start).Round(time.Millisecond))
+}
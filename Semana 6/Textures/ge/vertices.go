@@ -1,11 +1,14 @@
 package ge
 
 import (
+	"github.com/StevenTarazona/glcore/gfx"
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/mathgl/mgl32"
 )
 
 //CreateVAO ...
+//
+//Deprecated: the buffers can never be deleted, use gfx.NewMeshVertexArray
 func CreateVAO(vertices []mgl32.Vec3, textureCoord []mgl32.Vec2) uint32 {
 
 	var VAO uint32
@@ -27,7 +30,7 @@ func CreateVAO(vertices []mgl32.Vec3, textureCoord []mgl32.Vec2) uint32 {
 		var TBO uint32
 		gl.GenBuffers(1, &TBO)
 		gl.BindBuffer(gl.ARRAY_BUFFER, TBO)
-		gl.BufferData(gl.ARRAY_BUFFER, len(textureCoord)*4*3, gl.Ptr(textureCoord), gl.STATIC_DRAW)
+		gl.BufferData(gl.ARRAY_BUFFER, len(textureCoord)*4*2, gl.Ptr(textureCoord), gl.STATIC_DRAW)
 		gl.VertexAttribPointer(1, 2, gl.FLOAT, false, 2*4, gl.PtrOffset(0))
 		gl.EnableVertexAttribArray(1)
 		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
@@ -37,6 +40,22 @@ func CreateVAO(vertices []mgl32.Vec3, textureCoord []mgl32.Vec2) uint32 {
 	return VAO
 }
 
+//DrawArraysInstanced draws count vertices of vao instances times, the vao
+//needs per instance attributes with a divisor to tell the copies apart.
+//The vao is bound through the gfx state cache and left bound, code binding
+//vertex arrays with gl directly must call gfx.InvalidateState afterwards
+func DrawArraysInstanced(vao uint32, mode uint32, count int32, instances int32) {
+	gfx.BindVertexArray(vao)
+	gl.DrawArraysInstanced(mode, 0, count, instances)
+}
+
+//DrawElementsInstanced draws count uint32 indices of vao instances times,
+//the vao is bound as in DrawArraysInstanced
+func DrawElementsInstanced(vao uint32, mode uint32, count int32, instances int32) {
+	gfx.BindVertexArray(vao)
+	gl.DrawElementsInstanced(mode, count, gl.UNSIGNED_INT, nil, instances)
+}
+
 //Mul defines multiplication of 2 vert3
 func Mul(v1 mgl32.Vec3, v2 mgl32.Vec3) mgl32.Vec3 {
 	return mgl32.Vec3{v1.X() * v2.X(), v1.Y() * v2.Y(), v1.Z() * v2.Z()}
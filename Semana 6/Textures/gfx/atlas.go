@@ -0,0 +1,324 @@
+package gfx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Sprite is a named sub rectangle of an atlas, UVs follow the uploaded image
+// so v = 0 is the top row of the atlas image
+type Sprite struct {
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	W    int    `json:"w"`
+	H    int    `json:"h"`
+
+	// U0, V0 is the top left corner and U1, V1 the bottom right one
+	U0 float32 `json:"u0"`
+	V0 float32 `json:"v0"`
+	U1 float32 `json:"u1"`
+	V1 float32 `json:"v1"`
+}
+
+// UVRect returns the sprite UVs as u0, v0, u1, v1, ready for a vec4 uniform
+func (s Sprite) UVRect() [4]float32 {
+	return [4]float32{s.U0, s.V0, s.U1, s.V1}
+}
+
+// Atlas is a single image holding many sprites
+type Atlas struct {
+	Width   int               `json:"width"`
+	Height  int               `json:"height"`
+	Padding int               `json:"padding"`
+	Sprites map[string]Sprite `json:"sprites"`
+
+	Image *image.RGBA `json:"-"`
+}
+
+var errAtlasTooSmall = errors.New("atlas images do not fit in the max atlas size")
+
+func (a *Atlas) Sprite(name string) (Sprite, bool) {
+	sprite, ok := a.Sprites[name]
+	return sprite, ok
+}
+
+// Names returns the sprite names sorted, useful to index sprites from shaders
+func (a *Atlas) Names() []string {
+	names := make([]string, 0, len(a.Sprites))
+	for name := range a.Sprites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTexture uploads the atlas image, mipmaps are off by default in the
+// options the caller passes since they bleed neighbour sprites without padding
+func (a *Atlas) NewTexture(opts TextureOptions) (*Texture, error) {
+	return NewTextureWithOptions(a.Image, opts)
+}
+
+// Save writes the atlas image as png and the sprite metadata as json
+func (a *Atlas) Save(imageFile, metaFile string) error {
+	out, err := os.Create(imageFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := png.Encode(out, a.Image); err != nil {
+		return err
+	}
+
+	meta, err := json.MarshalIndent(a, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaFile, meta, 0644)
+}
+
+// LoadAtlas reads an atlas written by Save or by hand for an existing sprite sheet
+func LoadAtlas(imageFile, metaFile string) (*Atlas, error) {
+	meta, err := ioutil.ReadFile(metaFile)
+	if err != nil {
+		return nil, err
+	}
+	var atlas Atlas
+	if err := json.Unmarshal(meta, &atlas); err != nil {
+		return nil, fmt.Errorf("%s: %v", metaFile, err)
+	}
+
+	img, err := loadImageFile(imageFile)
+	if err != nil {
+		return nil, err
+	}
+	if atlas.Image, err = toRGBA(img); err != nil {
+		return nil, err
+	}
+	if atlas.Width == 0 || atlas.Height == 0 {
+		atlas.Width, atlas.Height = atlas.Image.Rect.Dx(), atlas.Image.Rect.Dy()
+	}
+
+	// metadata written by hand may only have pixel rectangles
+	for name, sprite := range atlas.Sprites {
+		sprite.Name = name
+		if sprite.U1 == 0 && sprite.V1 == 0 {
+			sprite = newSprite(name, sprite.X, sprite.Y, sprite.W, sprite.H, atlas.Width, atlas.Height)
+		}
+		atlas.Sprites[name] = sprite
+	}
+	return &atlas, nil
+}
+
+// NewGridAtlas describes an existing sprite sheet made of equal cells,
+// names are given row by row from the top left cell
+func NewGridAtlas(img image.Image, columns, rows int, names ...string) (*Atlas, error) {
+	if len(names) > columns*rows {
+		return nil, fmt.Errorf("%d names for a %dx%d grid", len(names), columns, rows)
+	}
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return nil, err
+	}
+
+	atlas := Atlas{
+		Width:   rgba.Rect.Dx(),
+		Height:  rgba.Rect.Dy(),
+		Sprites: make(map[string]Sprite, len(names)),
+		Image:   rgba,
+	}
+	cellW, cellH := atlas.Width/columns, atlas.Height/rows
+	for i, name := range names {
+		x, y := (i%columns)*cellW, (i/columns)*cellH
+		atlas.Sprites[name] = newSprite(name, x, y, cellW, cellH, atlas.Width, atlas.Height)
+	}
+	return &atlas, nil
+}
+
+func newSprite(name string, x, y, w, h, atlasW, atlasH int) Sprite {
+	return Sprite{
+		Name: name,
+		X:    x,
+		Y:    y,
+		W:    w,
+		H:    h,
+		U0:   float32(x) / float32(atlasW),
+		V0:   float32(y) / float32(atlasH),
+		U1:   float32(x+w) / float32(atlasW),
+		V1:   float32(y+h) / float32(atlasH),
+	}
+}
+
+type atlasEntry struct {
+	name string
+	img  image.Image
+}
+
+// AtlasBuilder packs many images into a single atlas
+type AtlasBuilder struct {
+	padding int
+	maxSize int
+	entries []atlasEntry
+}
+
+// NewAtlasBuilder creates a builder that leaves padding pixels around each
+// sprite and never grows the atlas past maxSize on either side
+func NewAtlasBuilder(padding, maxSize int) *AtlasBuilder {
+	return &AtlasBuilder{
+		padding: padding,
+		maxSize: maxSize,
+	}
+}
+
+func (b *AtlasBuilder) Add(name string, img image.Image) {
+	b.entries = append(b.entries, atlasEntry{name: name, img: img})
+}
+
+func (b *AtlasBuilder) AddFile(name, file string) error {
+	img, err := loadImageFile(file)
+	if err != nil {
+		return err
+	}
+	b.Add(name, img)
+	return nil
+}
+
+// Build packs the added images with a skyline bottom left heuristic, starting
+// at the smallest power of two square that can hold them and doubling as needed
+func (b *AtlasBuilder) Build() (*Atlas, error) {
+	names := make(map[string]bool, len(b.entries))
+	area := 0
+	for _, entry := range b.entries {
+		if names[entry.name] {
+			return nil, fmt.Errorf("duplicated sprite name %q", entry.name)
+		}
+		names[entry.name] = true
+		w, h := entry.img.Bounds().Dx()+2*b.padding, entry.img.Bounds().Dy()+2*b.padding
+		area += w * h
+	}
+
+	// biggest first packs tighter
+	entries := make([]atlasEntry, len(b.entries))
+	copy(entries, b.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].img.Bounds().Dy() > entries[j].img.Bounds().Dy()
+	})
+
+	size := 1
+	for size*size < area {
+		size *= 2
+	}
+	for width, height := size, size; width <= b.maxSize && height <= b.maxSize; {
+		if positions, ok := b.pack(entries, width, height); ok {
+			return b.compose(entries, positions, width, height), nil
+		}
+		if width <= height {
+			width *= 2
+		} else {
+			height *= 2
+		}
+	}
+	return nil, errAtlasTooSmall
+}
+
+// skyline segment, the packed area is everything below y from x to x+w
+type skylineNode struct {
+	x, y, w int
+}
+
+func (b *AtlasBuilder) pack(entries []atlasEntry, width, height int) ([]image.Point, bool) {
+	skyline := []skylineNode{{x: 0, y: 0, w: width}}
+	positions := make([]image.Point, len(entries))
+
+	for i, entry := range entries {
+		w, h := entry.img.Bounds().Dx()+2*b.padding, entry.img.Bounds().Dy()+2*b.padding
+
+		best, bestX, bestY := -1, 0, height
+		for j := range skyline {
+			y, ok := skylineFit(skyline, j, w, h, width, height)
+			if ok && y < bestY {
+				best, bestX, bestY = j, skyline[j].x, y
+			}
+		}
+		if best < 0 {
+			return nil, false
+		}
+		positions[i] = image.Pt(bestX+b.padding, bestY+b.padding)
+		skyline = skylineInsert(skyline, best, bestX, bestY+h, w)
+	}
+	return positions, true
+}
+
+// skylineFit returns the y a w*h rectangle rests on when placed at node i
+func skylineFit(skyline []skylineNode, i, w, h, width, height int) (int, bool) {
+	x := skyline[i].x
+	if x+w > width {
+		return 0, false
+	}
+	y := 0
+	for remaining := w; remaining > 0; i++ {
+		if i >= len(skyline) {
+			return 0, false
+		}
+		if skyline[i].y > y {
+			y = skyline[i].y
+		}
+		remaining -= skyline[i].w
+	}
+	return y, y+h <= height
+}
+
+// skylineInsert raises the skyline to top over x to x+w and merges the levels
+func skylineInsert(skyline []skylineNode, i, x, top, w int) []skylineNode {
+	node := skylineNode{x: x, y: top, w: w}
+	skyline = append(skyline[:i], append([]skylineNode{node}, skyline[i:]...)...)
+
+	// shrink or remove the nodes now covered by the new one
+	for j := i + 1; j < len(skyline); {
+		prevEnd := skyline[j-1].x + skyline[j-1].w
+		if skyline[j].x >= prevEnd {
+			break
+		}
+		shrink := prevEnd - skyline[j].x
+		skyline[j].x += shrink
+		skyline[j].w -= shrink
+		if skyline[j].w > 0 {
+			break
+		}
+		skyline = append(skyline[:j], skyline[j+1:]...)
+	}
+
+	for j := 0; j < len(skyline)-1; {
+		if skyline[j].y == skyline[j+1].y {
+			skyline[j].w += skyline[j+1].w
+			skyline = append(skyline[:j+1], skyline[j+2:]...)
+		} else {
+			j++
+		}
+	}
+	return skyline
+}
+
+func (b *AtlasBuilder) compose(entries []atlasEntry, positions []image.Point, width, height int) *Atlas {
+	atlas := Atlas{
+		Width:   width,
+		Height:  height,
+		Padding: b.padding,
+		Sprites: make(map[string]Sprite, len(entries)),
+		Image:   image.NewRGBA(image.Rect(0, 0, width, height)),
+	}
+	for i, entry := range entries {
+		bounds := entry.img.Bounds()
+		rect := image.Rectangle{Min: positions[i], Max: positions[i].Add(bounds.Size())}
+		draw.Draw(atlas.Image, rect, entry.img, bounds.Min, draw.Src)
+		atlas.Sprites[entry.name] = newSprite(entry.name, rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(), width, height)
+	}
+	return &atlas
+}
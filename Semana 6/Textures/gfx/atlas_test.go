@@ -0,0 +1,203 @@
+package gfx
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSkylineFit(t *testing.T) {
+	skyline := []skylineNode{{x: 0, y: 4, w: 8}, {x: 8, y: 2, w: 8}, {x: 16, y: 6, w: 16}}
+	tests := []struct {
+		i, w, h int
+		y       int
+		ok      bool
+	}{
+		{i: 0, w: 8, h: 4, y: 4, ok: true},
+		{i: 1, w: 8, h: 4, y: 2, ok: true},
+		// wider than the node rests on the highest node it covers
+		{i: 0, w: 12, h: 4, y: 4, ok: true},
+		{i: 1, w: 12, h: 4, y: 6, ok: true},
+		{i: 1, w: 30, h: 4, ok: false},
+		{i: 2, w: 16, h: 26, y: 6, ok: true},
+		{i: 2, w: 16, h: 27, ok: false},
+	}
+	for _, test := range tests {
+		y, ok := skylineFit(skyline, test.i, test.w, test.h, 32, 32)
+		if ok != test.ok || (ok && y != test.y) {
+			t.Errorf("skylineFit(%d, %dx%d) = %d, %v, want %d, %v", test.i, test.w, test.h, y, ok, test.y, test.ok)
+		}
+	}
+}
+
+func TestSkylineInsert(t *testing.T) {
+	tests := []struct {
+		name      string
+		skyline   []skylineNode
+		i, x, top int
+		w         int
+		want      []skylineNode
+	}{
+		{
+			name:    "splits the node below",
+			skyline: []skylineNode{{x: 0, y: 0, w: 32}},
+			i:       0, x: 0, top: 4, w: 8,
+			want: []skylineNode{{x: 0, y: 4, w: 8}, {x: 8, y: 0, w: 24}},
+		},
+		{
+			name:    "removes the covered nodes",
+			skyline: []skylineNode{{x: 0, y: 4, w: 8}, {x: 8, y: 2, w: 8}, {x: 16, y: 6, w: 16}},
+			i:       0, x: 0, top: 8, w: 20,
+			want: []skylineNode{{x: 0, y: 8, w: 20}, {x: 20, y: 6, w: 12}},
+		},
+		{
+			name:    "merges the nodes of the same height",
+			skyline: []skylineNode{{x: 0, y: 4, w: 8}, {x: 8, y: 0, w: 24}},
+			i:       1, x: 8, top: 4, w: 8,
+			want: []skylineNode{{x: 0, y: 4, w: 16}, {x: 16, y: 0, w: 16}},
+		},
+	}
+	for _, test := range tests {
+		got := skylineInsert(test.skyline, test.i, test.x, test.top, test.w)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func filledImage(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestAtlasBuilderBuild(t *testing.T) {
+	const padding = 2
+	sizes := map[string]image.Point{
+		"a": {16, 16}, "b": {8, 24}, "c": {20, 6}, "d": {5, 5}, "e": {12, 12}, "f": {3, 30},
+	}
+	builder := NewAtlasBuilder(padding, 256)
+	shade := uint8(10)
+	colors := make(map[string]color.RGBA)
+	for name, size := range sizes {
+		colors[name] = color.RGBA{R: shade, G: 255 - shade, B: 128, A: 255}
+		builder.Add(name, filledImage(size.X, size.Y, colors[name]))
+		shade += 20
+	}
+
+	atlas, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atlas.Sprites) != len(sizes) {
+		t.Fatalf("got %d sprites, want %d", len(atlas.Sprites), len(sizes))
+	}
+
+	bounds := image.Rect(0, 0, atlas.Width, atlas.Height)
+	padded := make(map[string]image.Rectangle)
+	for name, sprite := range atlas.Sprites {
+		rect := image.Rect(sprite.X, sprite.Y, sprite.X+sprite.W, sprite.Y+sprite.H)
+		if rect.Size() != sizes[name] {
+			t.Errorf("sprite %q is %v, want %v", name, rect.Size(), sizes[name])
+		}
+		padded[name] = rect.Inset(-padding)
+		if !padded[name].In(bounds) {
+			t.Errorf("sprite %q with its padding %v is outside the %v atlas", name, padded[name], bounds)
+		}
+		want := [4]float32{
+			float32(rect.Min.X) / float32(atlas.Width), float32(rect.Min.Y) / float32(atlas.Height),
+			float32(rect.Max.X) / float32(atlas.Width), float32(rect.Max.Y) / float32(atlas.Height),
+		}
+		if sprite.UVRect() != want {
+			t.Errorf("sprite %q uvs %v, want %v", name, sprite.UVRect(), want)
+		}
+		if got := atlas.Image.RGBAAt(rect.Min.X, rect.Min.Y); got != colors[name] {
+			t.Errorf("sprite %q pixel %v, want %v", name, got, colors[name])
+		}
+	}
+	for a, ra := range padded {
+		for b, rb := range padded {
+			if a < b && ra.Overlaps(rb) {
+				t.Errorf("sprites %q %v and %q %v overlap", a, ra, b, rb)
+			}
+		}
+	}
+}
+
+func TestAtlasBuilderErrors(t *testing.T) {
+	builder := NewAtlasBuilder(0, 16)
+	builder.Add("a", filledImage(4, 4, color.RGBA{A: 255}))
+	builder.Add("a", filledImage(4, 4, color.RGBA{A: 255}))
+	if _, err := builder.Build(); err == nil {
+		t.Error("duplicated sprite names built an atlas")
+	}
+
+	builder = NewAtlasBuilder(0, 16)
+	builder.Add("big", filledImage(32, 4, color.RGBA{A: 255}))
+	if _, err := builder.Build(); err != errAtlasTooSmall {
+		t.Errorf("got %v, want %v", err, errAtlasTooSmall)
+	}
+}
+
+func TestAtlasSaveLoad(t *testing.T) {
+	builder := NewAtlasBuilder(1, 64)
+	builder.Add("red", filledImage(6, 4, color.RGBA{R: 255, A: 255}))
+	builder.Add("blue", filledImage(3, 9, color.RGBA{B: 255, A: 255}))
+	atlas, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	imageFile, metaFile := filepath.Join(dir, "atlas.png"), filepath.Join(dir, "atlas.json")
+	if err := atlas.Save(imageFile, metaFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAtlas(imageFile, metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Sprites, atlas.Sprites) {
+		t.Errorf("loaded sprites %v, want %v", loaded.Sprites, atlas.Sprites)
+	}
+	if loaded.Width != atlas.Width || loaded.Height != atlas.Height || loaded.Padding != atlas.Padding {
+		t.Errorf("loaded %dx%d padding %d, want %dx%d padding %d", loaded.Width, loaded.Height, loaded.Padding,
+			atlas.Width, atlas.Height, atlas.Padding)
+	}
+	if !reflect.DeepEqual(loaded.Image.Pix, atlas.Image.Pix) {
+		t.Error("loaded image differs from the saved one")
+	}
+}
+
+func TestNewGridAtlas(t *testing.T) {
+	atlas, err := NewGridAtlas(filledImage(64, 32, color.RGBA{A: 255}), 2, 2, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]image.Rectangle{
+		"a": image.Rect(0, 0, 32, 16),
+		"b": image.Rect(32, 0, 64, 16),
+		"c": image.Rect(0, 16, 32, 32),
+	}
+	for name, rect := range want {
+		sprite, ok := atlas.Sprite(name)
+		if !ok {
+			t.Errorf("no sprite %q", name)
+			continue
+		}
+		if got := image.Rect(sprite.X, sprite.Y, sprite.X+sprite.W, sprite.Y+sprite.H); got != rect {
+			t.Errorf("sprite %q is %v, want %v", name, got, rect)
+		}
+	}
+	if !reflect.DeepEqual(atlas.Names(), []string{"a", "b", "c"}) {
+		t.Errorf("names %v", atlas.Names())
+	}
+
+	if _, err := NewGridAtlas(filledImage(4, 4, color.RGBA{}), 1, 1, "a", "b"); err == nil {
+		t.Error("more names than cells built an atlas")
+	}
+}
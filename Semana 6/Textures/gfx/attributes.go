@@ -0,0 +1,124 @@
+package gfx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// ProgramAttribute is an active vertex input of a linked program
+type ProgramAttribute struct {
+	Name     string
+	Location uint32
+	Type     uint32 // ex: gl.FLOAT_VEC3, gl.FLOAT_MAT4
+}
+
+// shaderType is how a vertex input type reads its locations: columns
+// locations of components values each
+func shaderType(glType uint32) (components, columns int32, integer bool) {
+	switch glType {
+	case gl.FLOAT, gl.DOUBLE:
+		return 1, 1, false
+	case gl.FLOAT_VEC2, gl.DOUBLE_VEC2:
+		return 2, 1, false
+	case gl.FLOAT_VEC3, gl.DOUBLE_VEC3:
+		return 3, 1, false
+	case gl.FLOAT_VEC4, gl.DOUBLE_VEC4:
+		return 4, 1, false
+	case gl.FLOAT_MAT2:
+		return 2, 2, false
+	case gl.FLOAT_MAT3:
+		return 3, 3, false
+	case gl.FLOAT_MAT4:
+		return 4, 4, false
+	case gl.INT, gl.UNSIGNED_INT:
+		return 1, 1, true
+	case gl.INT_VEC2, gl.UNSIGNED_INT_VEC2:
+		return 2, 1, true
+	case gl.INT_VEC3, gl.UNSIGNED_INT_VEC3:
+		return 3, 1, true
+	case gl.INT_VEC4, gl.UNSIGNED_INT_VEC4:
+		return 4, 1, true
+	default:
+		return 4, 1, false
+	}
+}
+
+// Attributes returns the vertex inputs the linked program reads, the built in
+// ones like gl_VertexID left out
+func (prog *Program) Attributes() []ProgramAttribute {
+	var count, maxLength int32
+	gl.GetProgramiv(prog.handle, gl.ACTIVE_ATTRIBUTES, &count)
+	gl.GetProgramiv(prog.handle, gl.ACTIVE_ATTRIBUTE_MAX_LENGTH, &maxLength)
+
+	attributes := make([]ProgramAttribute, 0, count)
+	name := make([]uint8, maxLength+1)
+	for i := uint32(0); i < uint32(count); i++ {
+		var length, size int32
+		var glType uint32
+		gl.GetActiveAttrib(prog.handle, i, int32(len(name)), &length, &size, &glType, &name[0])
+		location := gl.GetAttribLocation(prog.handle, &name[0])
+		if location < 0 {
+			continue
+		}
+		attributes = append(attributes, ProgramAttribute{
+			Name:     string(name[:length]),
+			Location: uint32(location),
+			Type:     glType,
+		})
+	}
+	return attributes
+}
+
+// CheckLayout reports the vertex inputs of the program that layout does not
+// feed, or feeds with the wrong type. unused names the inputs the caller knows
+// are not read by its draws, ex: the texture coordinates of untextured meshes
+func (prog *Program) CheckLayout(layout VertexLayout, unused ...string) error {
+	return checkLayout(prog.Attributes(), layout, unused...)
+}
+
+// checkLayout is CheckLayout with the vertex inputs of the program
+func checkLayout(inputs []ProgramAttribute, layout VertexLayout, unused ...string) error {
+	byLocation := make(map[uint32]VertexAttribute)
+	for _, attribute := range layout.Attributes {
+		byLocation[attribute.Location] = attribute
+	}
+	skip := make(map[string]bool)
+	for _, name := range unused {
+		skip[name] = true
+	}
+
+	var problems []string
+	for _, input := range inputs {
+		if skip[input.Name] {
+			continue
+		}
+		components, columns, integer := shaderType(input.Type)
+		for column := int32(0); column < columns; column++ {
+			location := input.Location + uint32(column)
+			attribute, ok := byLocation[location]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%q at location %d has no attribute", input.Name, location))
+			case integer:
+				problems = append(problems, fmt.Sprintf("%q at location %d is an integer input, attribute %q is read as floats",
+					input.Name, location, attribute.Name))
+			case attribute.Components != components && !(components == 4 && attribute.Components < 4):
+				// a vec4 missing components is filled as (0, 0, 0, 1), so
+				// positions of 3 floats can be read as vec4
+				problems = append(problems, fmt.Sprintf("%q at location %d reads %d components, attribute %q has %d",
+					input.Name, location, components, attribute.Name, attribute.Components))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("vertex layout does not match the program: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// CheckVertexArray is CheckLayout with the attributes added to va
+func (prog *Program) CheckVertexArray(va *VertexArray, unused ...string) error {
+	return prog.CheckLayout(va.Layout(), unused...)
+}
@@ -0,0 +1,84 @@
+package gfx
+
+import (
+	"testing"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+func TestCheckLayout(t *testing.T) {
+	instanced := VertexLayout{Attributes: []VertexAttribute{
+		{Name: "position", Location: 0, Components: 3, Type: gl.FLOAT},
+		{Name: "model0", Location: 2, Components: 4, Type: gl.FLOAT},
+		{Name: "model1", Location: 3, Components: 4, Type: gl.FLOAT},
+		{Name: "model2", Location: 4, Components: 4, Type: gl.FLOAT},
+		{Name: "model3", Location: 5, Components: 4, Type: gl.FLOAT},
+	}}
+	tests := []struct {
+		name   string
+		inputs []ProgramAttribute
+		layout VertexLayout
+		unused []string
+		ok     bool
+	}{
+		{
+			name:   "matching",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"texCoord", 1, gl.FLOAT_VEC2}},
+			layout: PositionTexLayout,
+			ok:     true,
+		},
+		{
+			name:   "vec4 fed with 3 components",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC4}},
+			layout: PositionLayout,
+			ok:     true,
+		},
+		{
+			name:   "mat4 over 4 locations",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"model", 2, gl.FLOAT_MAT4}},
+			layout: instanced,
+			ok:     true,
+		},
+		{
+			name:   "mat4 missing a column",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"model", 3, gl.FLOAT_MAT4}},
+			layout: instanced,
+		},
+		{
+			name:   "missing attribute",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"texCoord", 1, gl.FLOAT_VEC2}},
+			layout: PositionLayout,
+		},
+		{
+			name:   "missing attribute marked unused",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"texCoord", 1, gl.FLOAT_VEC2}},
+			layout: PositionLayout,
+			unused: []string{"texCoord"},
+			ok:     true,
+		},
+		{
+			name:   "fewer components",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"texCoord", 1, gl.FLOAT_VEC3}},
+			layout: PositionTexLayout,
+		},
+		{
+			name:   "integer input",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}, {"texCoord", 1, gl.INT_VEC2}},
+			layout: PositionTexLayout,
+		},
+		{
+			name:   "attributes the program does not read",
+			inputs: []ProgramAttribute{{"position", 0, gl.FLOAT_VEC3}},
+			layout: PositionTexLayout,
+			ok:     true,
+		},
+	}
+	for _, test := range tests {
+		err := checkLayout(test.inputs, test.layout, test.unused...)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: layout accepted", test.name)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package gfx
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+var errBufferRange = errors.New("sub data out of the buffer range")
+
+// GPUMemory counts the GL objects created through Buffer and VertexArray that
+// are still alive, a number that keeps growing points to a missing Delete
+type GPUMemory struct {
+	Buffers      int
+	VertexArrays int
+	// Bytes is the storage of the live buffers
+	Bytes int
+}
+
+var liveMemory GPUMemory
+
+// LiveMemory returns what the buffers and vertex arrays hold right now
+func LiveMemory() GPUMemory {
+	return liveMemory
+}
+
+// Buffer owns a GL buffer object and its storage
+type Buffer struct {
+	handle uint32
+	target uint32 // ex: gl.ARRAY_BUFFER
+	usage  uint32 // ex: gl.STATIC_DRAW
+	size   int
+}
+
+// NewBuffer creates a buffer of size bytes, filled from data when it is not nil
+func NewBuffer(target uint32, size int, data unsafe.Pointer, usage uint32) *Buffer {
+	buffer := Buffer{target: target, usage: usage, size: size}
+	gl.GenBuffers(1, &buffer.handle)
+	buffer.Bind()
+	gl.BufferData(target, size, data, usage)
+	buffer.UnBind()
+	checkError("creating a buffer")
+
+	liveMemory.Buffers++
+	liveMemory.Bytes += size
+	return &buffer
+}
+
+// NewFloatBuffer creates a static vertex buffer with data
+func NewFloatBuffer(data []float32) *Buffer {
+	return NewBuffer(gl.ARRAY_BUFFER, len(data)*4, slicePtr(data, len(data)), gl.STATIC_DRAW)
+}
+
+// NewIndexBuffer creates a static element buffer with indices
+func NewIndexBuffer(indices []uint32) *Buffer {
+	return NewBuffer(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, slicePtr(indices, len(indices)), gl.STATIC_DRAW)
+}
+
+// slicePtr is gl.Ptr for slices that may be empty
+func slicePtr(slice interface{}, length int) unsafe.Pointer {
+	if length == 0 {
+		return nil
+	}
+	return gl.Ptr(slice)
+}
+
+func (b *Buffer) Handle() uint32 {
+	return b.handle
+}
+
+// Size is the storage of the buffer in bytes
+func (b *Buffer) Size() int {
+	return b.size
+}
+
+func (b *Buffer) Bind() {
+	if b.target == gl.ELEMENT_ARRAY_BUFFER {
+		// the element binding belongs to the bound vertex array, which would
+		// lose its own indices on UnBind
+		BindVertexArray(0)
+	}
+	gl.BindBuffer(b.target, b.handle)
+}
+
+func (b *Buffer) UnBind() {
+	gl.BindBuffer(b.target, 0)
+}
+
+// SetSubData replaces size bytes from offset with data
+func (b *Buffer) SetSubData(offset, size int, data unsafe.Pointer) error {
+	if offset < 0 || size < 0 || offset+size > b.size {
+		return errBufferRange
+	}
+	b.Bind()
+	gl.BufferSubData(b.target, offset, size, data)
+	b.UnBind()
+	checkError("writing a buffer")
+	return nil
+}
+
+// Orphan gives the buffer new storage of the same size with undefined
+// contents, so writing it does not wait for the draws still reading the old one
+func (b *Buffer) Orphan() {
+	b.Allocate(b.size)
+}
+
+// Allocate gives the buffer size bytes of new storage with undefined contents,
+// Resize is the one that keeps them. The handle stays the same so the vertex
+// arrays still see it
+func (b *Buffer) Allocate(size int) {
+	b.Bind()
+	gl.BufferData(b.target, size, nil, b.usage)
+	b.UnBind()
+	checkError("allocating a buffer")
+
+	liveMemory.Bytes += size - b.size
+	b.size = size
+}
+
+// Resize gives the buffer size bytes of new storage, keeping the contents that
+// fit in it. The handle stays the same so the vertex arrays still see it
+func (b *Buffer) Resize(size int) {
+	if size == b.size {
+		return
+	}
+	keep := b.size
+	if size < keep {
+		keep = size
+	}
+
+	// the contents go through a temporary copy, a buffer can not be
+	// reallocated and read at the same time
+	var temp uint32
+	if keep > 0 {
+		gl.GenBuffers(1, &temp)
+		gl.BindBuffer(gl.COPY_WRITE_BUFFER, temp)
+		gl.BufferData(gl.COPY_WRITE_BUFFER, keep, nil, gl.STREAM_COPY)
+		gl.BindBuffer(gl.COPY_READ_BUFFER, b.handle)
+		gl.CopyBufferSubData(gl.COPY_READ_BUFFER, gl.COPY_WRITE_BUFFER, 0, 0, keep)
+	}
+
+	gl.BindBuffer(gl.COPY_WRITE_BUFFER, b.handle)
+	gl.BufferData(gl.COPY_WRITE_BUFFER, size, nil, b.usage)
+
+	if keep > 0 {
+		gl.BindBuffer(gl.COPY_READ_BUFFER, temp)
+		gl.CopyBufferSubData(gl.COPY_READ_BUFFER, gl.COPY_WRITE_BUFFER, 0, 0, keep)
+		gl.BindBuffer(gl.COPY_READ_BUFFER, 0)
+		gl.DeleteBuffers(1, &temp)
+	}
+	gl.BindBuffer(gl.COPY_WRITE_BUFFER, 0)
+	checkError("resizing a buffer")
+
+	liveMemory.Bytes += size - b.size
+	b.size = size
+}
+
+// Delete frees the buffer, it is safe to call more than once
+func (b *Buffer) Delete() {
+	if b.handle == 0 {
+		return
+	}
+	gl.DeleteBuffers(1, &b.handle)
+	b.handle = 0
+	liveMemory.Buffers--
+	liveMemory.Bytes -= b.size
+}
+
+// VertexArray owns a GL vertex array object and the buffers added to it
+type VertexArray struct {
+	handle     uint32
+	buffers    []*Buffer
+	indices    *Buffer
+	vertices   int
+	attributes []VertexAttribute
+}
+
+func NewVertexArray() *VertexArray {
+	var va VertexArray
+	gl.GenVertexArrays(1, &va.handle)
+	liveMemory.VertexArrays++
+	return &va
+}
+
+// NewMeshVertexArray creates a vertex array with the positions at location 0
+// and, when there are any, the texture coordinates at location 1. It panics
+// when there are texture coordinates but not one per vertex
+func NewMeshVertexArray(vertices []mgl32.Vec3, textureCoord []mgl32.Vec2) *VertexArray {
+	var va *VertexArray
+	var err error
+	if len(textureCoord) > 0 {
+		va, err = NewVertexArrayWithLayout(PositionTexLayout, nil, vertices, textureCoord)
+	} else {
+		va, err = NewVertexArrayWithLayout(PositionLayout, nil, vertices)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return va
+}
+
+func (va *VertexArray) Handle() uint32 {
+	return va.handle
+}
+
+// AddBuffer reads the float attribute at location from buffer, size floats
+// every stride bytes from offset. The vertex array owns buffer from now on
+func (va *VertexArray) AddBuffer(buffer *Buffer, location uint32, size int32, stride int32, offset int) {
+	va.addAttribute(buffer, VertexAttribute{Location: location, Components: size, Type: gl.FLOAT}, stride, offset)
+}
+
+// SetIndexBuffer makes the vertex array draw with the indices of buffer, the
+// vertex array owns it from now on
+func (va *VertexArray) SetIndexBuffer(buffer *Buffer) {
+	BindVertexArray(va.handle)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, buffer.handle)
+	BindVertexArray(0)
+	// the element binding is part of the vertex array, it is not unbound
+	// while the array is bound
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, 0)
+	va.indices = buffer
+	va.own(buffer)
+}
+
+func (va *VertexArray) own(buffer *Buffer) {
+	for _, owned := range va.buffers {
+		if owned == buffer {
+			return
+		}
+	}
+	va.buffers = append(va.buffers, buffer)
+}
+
+// VertexCount is the number of vertices given to NewVertexArrayWithLayout
+func (va *VertexArray) VertexCount() int {
+	return va.vertices
+}
+
+// IndexCount is the number of indices of the index buffer, 0 without one
+func (va *VertexArray) IndexCount() int {
+	if va.indices == nil {
+		return 0
+	}
+	return va.indices.Size() / 4
+}
+
+// Layout returns the attributes added to the vertex array, each with the
+// buffer it reads from of its own
+func (va *VertexArray) Layout() VertexLayout {
+	return VertexLayout{Attributes: va.attributes}
+}
+
+// Buffers returns the buffers owned by the vertex array, to update their data
+func (va *VertexArray) Buffers() []*Buffer {
+	return va.buffers
+}
+
+// IndexBuffer returns the buffer given to SetIndexBuffer, or nil
+func (va *VertexArray) IndexBuffer() *Buffer {
+	return va.indices
+}
+
+func (va *VertexArray) Bind() {
+	BindVertexArray(va.handle)
+}
+
+func (va *VertexArray) UnBind() {
+	BindVertexArray(0)
+}
+
+// Delete frees the vertex array and its buffers, it is safe to call more than once
+func (va *VertexArray) Delete() {
+	if va.handle == 0 {
+		return
+	}
+	for _, buffer := range va.buffers {
+		buffer.Delete()
+	}
+	va.buffers = nil
+	va.indices = nil
+	va.attributes = nil
+	gl.DeleteVertexArrays(1, &va.handle)
+	state.forgetVertexArray(va.handle)
+	va.handle = 0
+	liveMemory.VertexArrays--
+}
@@ -0,0 +1,34 @@
+package gfx
+
+import "testing"
+
+func TestSlicePtr(t *testing.T) {
+	if ptr := slicePtr([]float32{}, 0); ptr != nil {
+		t.Errorf("empty slice pointer %v, want nil", ptr)
+	}
+	if ptr := slicePtr([]uint32{1, 2, 3}, 3); ptr == nil {
+		t.Error("slice pointer is nil")
+	}
+}
+
+func TestVertexArrayCounts(t *testing.T) {
+	va := &VertexArray{vertices: 8}
+	if va.VertexCount() != 8 || va.IndexCount() != 0 {
+		t.Errorf("got %d vertices and %d indices, want 8 and 0", va.VertexCount(), va.IndexCount())
+	}
+	va.indices = &Buffer{size: 6 * 4}
+	if va.IndexCount() != 6 {
+		t.Errorf("got %d indices, want 6", va.IndexCount())
+	}
+}
+
+func TestDeleteWithoutHandle(t *testing.T) {
+	// deleting twice, or objects whose creation failed, does not reach GL
+	// nor the live memory counters
+	before := liveMemory
+	(&Buffer{size: 64}).Delete()
+	(&VertexArray{}).Delete()
+	if liveMemory != before {
+		t.Errorf("live memory %+v, want %+v", liveMemory, before)
+	}
+}
@@ -0,0 +1,204 @@
+package gfx
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// CubemapLayout describes how the six faces are arranged in a single image
+type CubemapLayout int
+
+const (
+	CubemapEquirectangular CubemapLayout = iota // 2:1 longitude/latitude panorama
+	CubemapHorizontalCross CubemapLayout = iota // 4:3 cross, +Y on top and -Y below +Z
+	CubemapVerticalCross   CubemapLayout = iota // 3:4 cross, -Z at the bottom upside down
+)
+
+var errCubemapFaceSize = errors.New("cubemap faces must be square and of the same size")
+
+// CubemapOptions returns options suited for skyboxes and environment maps,
+// clamped on all axes so no seams show between faces
+func CubemapOptions() TextureOptions {
+	opts := DefaultTextureOptions()
+	opts.WrapS = gl.CLAMP_TO_EDGE
+	opts.WrapT = gl.CLAMP_TO_EDGE
+	opts.WrapR = gl.CLAMP_TO_EDGE
+	return opts
+}
+
+// NewCubemapFromFiles loads the faces in the GL order +X, -X, +Y, -Y, +Z, -Z
+func NewCubemapFromFiles(files [6]string, opts TextureOptions) (*Texture, error) {
+	var faces [6]image.Image
+	for i, file := range files {
+		img, err := loadImageFile(file)
+		if err != nil {
+			return nil, err
+		}
+		faces[i] = img
+	}
+	return NewCubemap(faces, opts)
+}
+
+// NewCubemapFromFile loads a cubemap stored in a single image with the given layout,
+// faceSize is only used for equirectangular images, 0 picks half the image height
+func NewCubemapFromFile(file string, layout CubemapLayout, faceSize int, opts TextureOptions) (*Texture, error) {
+	img, err := loadImageFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewCubemapFromImage(img, layout, faceSize, opts)
+}
+
+func NewCubemapFromImage(img image.Image, layout CubemapLayout, faceSize int, opts TextureOptions) (*Texture, error) {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return nil, err
+	}
+
+	var faces [6]image.Image
+	switch layout {
+	case CubemapEquirectangular:
+		if faceSize <= 0 {
+			faceSize = rgba.Rect.Dy() / 2
+		}
+		for i := range faces {
+			faces[i] = equirectangularFace(rgba, i, faceSize)
+		}
+	case CubemapHorizontalCross, CubemapVerticalCross:
+		faces, err = crossFaces(rgba, layout)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown cubemap layout %d", layout)
+	}
+	return NewCubemap(faces, opts)
+}
+
+// NewCubemap uploads the faces in the GL order +X, -X, +Y, -Y, +Z, -Z
+func NewCubemap(faces [6]image.Image, opts TextureOptions) (*Texture, error) {
+	var handle uint32
+	gl.GenTextures(1, &handle)
+
+	texture := Texture{
+		handle: handle,
+		target: gl.TEXTURE_CUBE_MAP,
+	}
+
+	texture.Bind(gl.TEXTURE0)
+	defer texture.UnBind()
+
+	texture.setParameters(opts)
+
+	internalFmt := int32(gl.SRGB_ALPHA)
+	if opts.Linear {
+		internalFmt = gl.RGBA8
+	}
+
+	size := -1
+	for i, face := range faces {
+		rgba, err := toRGBA(face)
+		if err != nil {
+			texture.Delete()
+			return nil, err
+		}
+		width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+		if size < 0 {
+			size = width
+			texture.width, texture.height = int32(width), int32(height)
+		}
+		if width != height || width != size {
+			texture.Delete()
+			return nil, errCubemapFaceSize
+		}
+		gl.TexImage2D(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), 0, internalFmt, int32(width), int32(height), 0,
+			gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba.Pix))
+	}
+
+	if opts.Mipmaps {
+		gl.GenerateMipmap(texture.target)
+	}
+	checkError("creating a cubemap")
+
+	return &texture, nil
+}
+
+// cubemapDirection returns the direction sampled by the texel at u, v in [-1, 1]
+// of the given face, with v growing downwards as in the uploaded image rows
+func cubemapDirection(face int, u, v float64) (x, y, z float64) {
+	switch face {
+	case 0: // +X
+		return 1, -v, -u
+	case 1: // -X
+		return -1, -v, u
+	case 2: // +Y
+		return u, 1, v
+	case 3: // -Y
+		return u, -1, -v
+	case 4: // +Z
+		return u, -v, 1
+	default: // -Z
+		return -u, -v, -1
+	}
+}
+
+func equirectangularFace(src *image.RGBA, face, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	for j := 0; j < size; j++ {
+		for i := 0; i < size; i++ {
+			u := 2*(float64(i)+0.5)/float64(size) - 1
+			v := 2*(float64(j)+0.5)/float64(size) - 1
+			x, y, z := cubemapDirection(face, u, v)
+
+			lon := math.Atan2(z, x)
+			lat := math.Asin(y / math.Sqrt(x*x+y*y+z*z))
+			sx := int((lon/(2*math.Pi) + 0.5) * float64(width))
+			sy := int((0.5 - lat/math.Pi) * float64(height))
+			sx = ((sx % width) + width) % width
+			if sy >= height {
+				sy = height - 1
+			}
+
+			copy(dst.Pix[dst.PixOffset(i, j):dst.PixOffset(i, j)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+func crossFaces(src *image.RGBA, layout CubemapLayout) (faces [6]image.Image, err error) {
+	// cells of each face as column, row in the cross
+	cells := [6][2]int{{2, 1}, {0, 1}, {1, 0}, {1, 2}, {1, 1}, {3, 1}}
+	columns, rows := 4, 3
+	if layout == CubemapVerticalCross {
+		cells[5] = [2]int{1, 3}
+		columns, rows = 3, 4
+	}
+
+	size := src.Rect.Dx() / columns
+	if size == 0 || src.Rect.Dx() != size*columns || src.Rect.Dy() != size*rows {
+		return faces, fmt.Errorf("cross cubemap must be %dx%d faces, got %dx%d pixels",
+			columns, rows, src.Rect.Dx(), src.Rect.Dy())
+	}
+
+	for i, cell := range cells {
+		face := image.NewRGBA(image.Rect(0, 0, size, size))
+		for j := 0; j < size; j++ {
+			for k := 0; k < size; k++ {
+				sx, sy := cell[0]*size+k, cell[1]*size+j
+				if layout == CubemapVerticalCross && i == 5 {
+					// the back face is stored rotated half a turn
+					sx, sy = cell[0]*size+size-1-k, cell[1]*size+size-1-j
+				}
+				copy(face.Pix[face.PixOffset(k, j):face.PixOffset(k, j)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+			}
+		}
+		faces[i] = face
+	}
+	return faces, nil
+}
@@ -0,0 +1,151 @@
+package gfx
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// DebugOptions configure the debug layer of InitGlDebug
+type DebugOptions struct {
+	// Logger gets the messages, nil uses the standard logger
+	Logger *log.Logger
+	// MinSeverity drops the less severe messages, ex: gl.DEBUG_SEVERITY_MEDIUM.
+	// Zero keeps them all, notifications included
+	MinSeverity uint32
+}
+
+var debugLogger = log.New(log.Writer(), "", log.LstdFlags)
+
+// InitGlDebug is InitGl with the debug output of the driver sent to the logger
+// of options. It needs GL 4.3, KHR_debug or ARB_debug_output and, on most
+// drivers, a context created with win.InitGlfwDebug. Without them only the
+// GetError checks of the gldebug build tag are left
+func InitGlDebug(options DebugOptions) {
+	InitGl()
+	if options.Logger != nil {
+		debugLogger = options.Logger
+	}
+	minRank := severityRank(options.MinSeverity)
+
+	callback := func(source, glType, id, severity uint32, length int32, message string, userParam unsafe.Pointer) {
+		if severityRank(severity) < minRank {
+			return
+		}
+		debugLogger.Printf("GL %s %s: %s", severityName(severity), debugTypeName(glType), message)
+	}
+
+	var major, minor int32
+	gl.GetIntegerv(gl.MAJOR_VERSION, &major)
+	gl.GetIntegerv(gl.MINOR_VERSION, &minor)
+	switch {
+	case major > 4 || major == 4 && minor >= 3, hasExtension("GL_KHR_debug"):
+		gl.DebugMessageCallback(callback, nil)
+	case hasExtension("GL_ARB_debug_output"):
+		gl.DebugMessageCallbackARB(callback, nil)
+	default:
+		debugLogger.Println("GL debug output is not available")
+		return
+	}
+	gl.Enable(gl.DEBUG_OUTPUT)
+	// the messages arrive in the call that caused them, so the stack is right
+	gl.Enable(gl.DEBUG_OUTPUT_SYNCHRONOUS)
+}
+
+func hasExtension(name string) bool {
+	var count int32
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &count)
+	for i := uint32(0); i < uint32(count); i++ {
+		if gl.GoStr(gl.GetStringi(gl.EXTENSIONS, i)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// severityRank orders the severities, the constants themselves are not
+func severityRank(severity uint32) int {
+	switch severity {
+	case gl.DEBUG_SEVERITY_LOW:
+		return 1
+	case gl.DEBUG_SEVERITY_MEDIUM:
+		return 2
+	case gl.DEBUG_SEVERITY_HIGH:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func severityName(severity uint32) string {
+	switch severity {
+	case gl.DEBUG_SEVERITY_LOW:
+		return "low"
+	case gl.DEBUG_SEVERITY_MEDIUM:
+		return "medium"
+	case gl.DEBUG_SEVERITY_HIGH:
+		return "high"
+	default:
+		return "notification"
+	}
+}
+
+func debugTypeName(glType uint32) string {
+	switch glType {
+	case gl.DEBUG_TYPE_ERROR:
+		return "error"
+	case gl.DEBUG_TYPE_DEPRECATED_BEHAVIOR:
+		return "deprecated"
+	case gl.DEBUG_TYPE_UNDEFINED_BEHAVIOR:
+		return "undefined behavior"
+	case gl.DEBUG_TYPE_PORTABILITY:
+		return "portability"
+	case gl.DEBUG_TYPE_PERFORMANCE:
+		return "performance"
+	default:
+		return "other"
+	}
+}
+
+func errorName(code uint32) string {
+	switch code {
+	case gl.INVALID_ENUM:
+		return "INVALID_ENUM"
+	case gl.INVALID_VALUE:
+		return "INVALID_VALUE"
+	case gl.INVALID_OPERATION:
+		return "INVALID_OPERATION"
+	case gl.INVALID_FRAMEBUFFER_OPERATION:
+		return "INVALID_FRAMEBUFFER_OPERATION"
+	case gl.OUT_OF_MEMORY:
+		return "OUT_OF_MEMORY"
+	default:
+		return fmt.Sprintf("0x%X", code)
+	}
+}
+
+// CheckError logs the pending GL errors with the line that called it, op
+// tells what was done before. It does nothing without the gldebug build tag
+func CheckError(op string) {
+	if checkErrors {
+		reportErrors(op, 2)
+	}
+}
+
+// checkError is CheckError for the functions of this package, the line logged
+// is the one that called them
+func checkError(op string) {
+	if checkErrors {
+		reportErrors(op, 3)
+	}
+}
+
+func reportErrors(op string, skip int) {
+	_, file, line, _ := runtime.Caller(skip)
+	for code := gl.GetError(); code != gl.NO_ERROR; code = gl.GetError() {
+		debugLogger.Printf("GL %s after %s, called from %s:%d", errorName(code), op, file, line)
+	}
+}
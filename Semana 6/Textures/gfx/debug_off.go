@@ -0,0 +1,7 @@
+//go:build !gldebug
+// +build !gldebug
+
+package gfx
+
+// checkErrors is off out of the gldebug builds, glGetError stalls the pipeline
+const checkErrors = false
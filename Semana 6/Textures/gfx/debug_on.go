@@ -0,0 +1,7 @@
+//go:build gldebug
+// +build gldebug
+
+package gfx
+
+// checkErrors makes the calls of this package check glGetError after them
+const checkErrors = true
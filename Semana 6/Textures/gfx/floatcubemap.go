@@ -0,0 +1,170 @@
+package gfx
+
+import (
+	"math"
+	"sync"
+)
+
+// FloatCubemap is a cubemap of linear RGB floats kept in memory, faces in the
+// GL order +X, -X, +Y, -Y, +Z, -Z with rows from top to bottom
+type FloatCubemap struct {
+	Size  int
+	Faces [6][]float32
+}
+
+func NewFloatCubemap(size int) *FloatCubemap {
+	c := FloatCubemap{Size: size}
+	for i := range c.Faces {
+		c.Faces[i] = make([]float32, size*size*3)
+	}
+	return &c
+}
+
+// cubemapFaceUV is the inverse of cubemapDirection, u and v in [-1, 1]
+func cubemapFaceUV(x, y, z float64) (face int, u, v float64) {
+	ax, ay, az := math.Abs(x), math.Abs(y), math.Abs(z)
+	switch {
+	case ax >= ay && ax >= az:
+		if x > 0 {
+			return 0, -z / ax, -y / ax
+		}
+		return 1, z / ax, -y / ax
+	case ay >= az:
+		if y > 0 {
+			return 2, x / ay, z / ay
+		}
+		return 3, x / ay, -z / ay
+	default:
+		if z > 0 {
+			return 4, x / az, -y / az
+		}
+		return 5, -x / az, -y / az
+	}
+}
+
+// Sample returns the bilinear filtered color seen in direction x, y, z
+func (c *FloatCubemap) Sample(x, y, z float64) [3]float32 {
+	face, u, v := cubemapFaceUV(x, y, z)
+	fx := (u+1)/2*float64(c.Size) - 0.5
+	fy := (v+1)/2*float64(c.Size) - 0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := float32(fx-float64(x0)), float32(fy-float64(y0))
+
+	// texels past the edge are clamped, seamless filtering is not worth it for
+	// the blurry maps this is used for
+	texel := func(i, j int) []float32 {
+		i = clampInt(i, 0, c.Size-1)
+		j = clampInt(j, 0, c.Size-1)
+		k := (j*c.Size + i) * 3
+		return c.Faces[face][k : k+3]
+	}
+	a, b := texel(x0, y0), texel(x0+1, y0)
+	d, e := texel(x0, y0+1), texel(x0+1, y0+1)
+
+	var color [3]float32
+	for i := range color {
+		top := a[i] + (b[i]-a[i])*tx
+		bottom := d[i] + (e[i]-d[i])*tx
+		color[i] = top + (bottom-top)*ty
+	}
+	return color
+}
+
+// Downsample returns a cubemap of half the size, each texel is the average of four
+func (c *FloatCubemap) Downsample() *FloatCubemap {
+	size := c.Size / 2
+	if size < 1 {
+		size = 1
+	}
+	dst := NewFloatCubemap(size)
+	for face := range c.Faces {
+		src := c.Faces[face]
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				k := (j*size + i) * 3
+				for y := 0; y < 2; y++ {
+					for x := 0; x < 2; x++ {
+						sx := clampInt(i*2+x, 0, c.Size-1)
+						sy := clampInt(j*2+y, 0, c.Size-1)
+						sk := (sy*c.Size + sx) * 3
+						dst.Faces[face][k] += src[sk] / 4
+						dst.Faces[face][k+1] += src[sk+1] / 4
+						dst.Faces[face][k+2] += src[sk+2] / 4
+					}
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// forEachTexel calls fn for every texel of c with its direction, a goroutine per face
+func (c *FloatCubemap) forEachTexel(fn func(face, i, j int, x, y, z float64)) {
+	var wg sync.WaitGroup
+	for face := range c.Faces {
+		wg.Add(1)
+		go func(face int) {
+			defer wg.Done()
+			for j := 0; j < c.Size; j++ {
+				for i := 0; i < c.Size; i++ {
+					u := 2*(float64(i)+0.5)/float64(c.Size) - 1
+					v := 2*(float64(j)+0.5)/float64(c.Size) - 1
+					x, y, z := cubemapDirection(face, u, v)
+					length := math.Sqrt(x*x + y*y + z*z)
+					fn(face, i, j, x/length, y/length, z/length)
+				}
+			}
+		}(face)
+	}
+	wg.Wait()
+}
+
+func (c *FloatCubemap) set(face, i, j int, color [3]float32) {
+	k := (j*c.Size + i) * 3
+	copy(c.Faces[face][k:k+3], color[:])
+}
+
+// FloatCubemapFromEquirectangular resamples a longitude/latitude panorama into faces of size x size
+func FloatCubemapFromEquirectangular(img *FloatImage, size int) *FloatCubemap {
+	c := NewFloatCubemap(size)
+	c.forEachTexel(func(face, i, j int, x, y, z float64) {
+		c.set(face, i, j, sampleEquirectangular(img, x, y, z))
+	})
+	return c
+}
+
+// sampleEquirectangular uses the same mapping as equirectangularFace
+func sampleEquirectangular(img *FloatImage, x, y, z float64) [3]float32 {
+	lon := math.Atan2(z, x)
+	lat := math.Asin(y)
+	fx := (lon/(2*math.Pi)+0.5)*float64(img.Width) - 0.5
+	fy := (0.5-lat/math.Pi)*float64(img.Height) - 0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := float32(fx-float64(x0)), float32(fy-float64(y0))
+
+	texel := func(i, j int) []float32 {
+		i = ((i % img.Width) + img.Width) % img.Width // longitude wraps around
+		j = clampInt(j, 0, img.Height-1)
+		return img.At(i, j)
+	}
+	a, b := texel(x0, y0), texel(x0+1, y0)
+	d, e := texel(x0, y0+1), texel(x0+1, y0+1)
+
+	var color [3]float32
+	for i := range color {
+		top := a[i] + (b[i]-a[i])*tx
+		bottom := d[i] + (e[i]-d[i])*tx
+		color[i] = top + (bottom-top)*ty
+	}
+	return color
+}
+
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
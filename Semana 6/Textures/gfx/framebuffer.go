@@ -0,0 +1,333 @@
+package gfx
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// ColorFormat is the storage of a color attachment
+type ColorFormat struct {
+	InternalFormat int32  // ex: gl.RGBA16F
+	Format         uint32 // ex: gl.RGBA
+	Type           uint32 // ex: gl.FLOAT
+}
+
+var (
+	ColorRGBA8   = ColorFormat{gl.RGBA8, gl.RGBA, gl.UNSIGNED_BYTE}
+	ColorSRGBA8  = ColorFormat{gl.SRGB8_ALPHA8, gl.RGBA, gl.UNSIGNED_BYTE}
+	ColorRGBA16F = ColorFormat{gl.RGBA16F, gl.RGBA, gl.FLOAT}
+	ColorRGBA32F = ColorFormat{gl.RGBA32F, gl.RGBA, gl.FLOAT}
+	ColorRG16F   = ColorFormat{gl.RG16F, gl.RG, gl.FLOAT}
+	ColorR8      = ColorFormat{gl.R8, gl.RED, gl.UNSIGNED_BYTE}
+	ColorR16F    = ColorFormat{gl.R16F, gl.RED, gl.FLOAT}
+)
+
+// DepthFormat is the depth/stencil attachment of a framebuffer
+type DepthFormat int
+
+const (
+	DepthNone                DepthFormat = iota
+	DepthRenderbuffer        DepthFormat = iota // 24 bit depth, can not be sampled
+	DepthStencilRenderbuffer DepthFormat = iota // 24 bit depth and 8 bit stencil, can not be sampled
+	DepthTexture             DepthFormat = iota // 24 bit depth texture, ex: shadow maps
+	DepthStencilTexture      DepthFormat = iota // 24 bit depth and 8 bit stencil texture
+)
+
+// FramebufferOptions describes the attachments of a framebuffer
+type FramebufferOptions struct {
+	Width  int
+	Height int
+
+	// Colors are bound to COLOR_ATTACHMENT0 and up in order
+	Colors []ColorFormat
+	Depth  DepthFormat
+
+	// Samples > 1 renders into multisampled renderbuffers that are resolved
+	// into textures by Resolve
+	Samples int
+
+	// Filter is the min/mag filter of the color textures, 0 means linear
+	Filter int32
+}
+
+type Framebuffer struct {
+	handle uint32
+	opts   FramebufferOptions
+
+	colors        []*Texture
+	depth         *Texture
+	renderbuffers []uint32
+
+	// single sample framebuffer holding the textures when multisampled
+	resolve *Framebuffer
+}
+
+var errFramebufferSize = errors.New("framebuffer width and height must be positive")
+
+func NewFramebuffer(opts FramebufferOptions) (*Framebuffer, error) {
+	fb := Framebuffer{opts: opts}
+	if err := fb.create(); err != nil {
+		fb.Delete()
+		return nil, err
+	}
+	return &fb, nil
+}
+
+func (fb *Framebuffer) create() error {
+	opts := fb.opts
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return errFramebufferSize
+	}
+	width, height := int32(opts.Width), int32(opts.Height)
+
+	gl.GenFramebuffers(1, &fb.handle)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, fb.handle)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+
+	multisampled := opts.Samples > 1
+	drawBuffers := make([]uint32, len(opts.Colors))
+	for i, format := range opts.Colors {
+		attachment := uint32(gl.COLOR_ATTACHMENT0 + i)
+		drawBuffers[i] = attachment
+		if multisampled {
+			rbo := newRenderbuffer(opts.Samples, uint32(format.InternalFormat), width, height)
+			gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, attachment, gl.RENDERBUFFER, rbo)
+			fb.renderbuffers = append(fb.renderbuffers, rbo)
+			continue
+		}
+		tex := newAttachmentTexture(format.InternalFormat, format.Format, format.Type, width, height, opts.Filter)
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, attachment, gl.TEXTURE_2D, tex.handle, 0)
+		fb.colors = append(fb.colors, tex)
+	}
+	if len(drawBuffers) > 0 {
+		gl.DrawBuffers(int32(len(drawBuffers)), &drawBuffers[0])
+	} else {
+		// depth only, ex: shadow maps
+		gl.DrawBuffer(gl.NONE)
+		gl.ReadBuffer(gl.NONE)
+	}
+
+	depthAttachment := uint32(gl.DEPTH_ATTACHMENT)
+	depthInternalFmt, depthFmt, depthType := int32(gl.DEPTH_COMPONENT24), uint32(gl.DEPTH_COMPONENT), uint32(gl.UNSIGNED_INT)
+	if opts.Depth == DepthStencilRenderbuffer || opts.Depth == DepthStencilTexture {
+		depthAttachment = gl.DEPTH_STENCIL_ATTACHMENT
+		depthInternalFmt, depthFmt, depthType = gl.DEPTH24_STENCIL8, gl.DEPTH_STENCIL, gl.UNSIGNED_INT_24_8
+	}
+	switch {
+	case opts.Depth == DepthNone:
+	case opts.Depth == DepthRenderbuffer || opts.Depth == DepthStencilRenderbuffer || multisampled:
+		rbo := newRenderbuffer(opts.Samples, uint32(depthInternalFmt), width, height)
+		gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, depthAttachment, gl.RENDERBUFFER, rbo)
+		fb.renderbuffers = append(fb.renderbuffers, rbo)
+	default:
+		fb.depth = newAttachmentTexture(depthInternalFmt, depthFmt, depthType, width, height, gl.NEAREST)
+		gl.FramebufferTexture2D(gl.FRAMEBUFFER, depthAttachment, gl.TEXTURE_2D, fb.depth.handle, 0)
+	}
+
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		return fmt.Errorf("FRAMEBUFFER::INCOMPLETE: status 0x%x", status)
+	}
+
+	if multisampled {
+		// sampleable textures live in a single sample copy of this framebuffer
+		resolveOpts := opts
+		resolveOpts.Samples = 0
+		if opts.Depth == DepthRenderbuffer || opts.Depth == DepthStencilRenderbuffer {
+			resolveOpts.Depth = DepthNone
+		}
+		resolve, err := NewFramebuffer(resolveOpts)
+		if err != nil {
+			return err
+		}
+		fb.resolve = resolve
+	}
+	return nil
+}
+
+func newRenderbuffer(samples int, internalFmt uint32, width, height int32) uint32 {
+	var rbo uint32
+	gl.GenRenderbuffers(1, &rbo)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, rbo)
+	if samples > 1 {
+		gl.RenderbufferStorageMultisample(gl.RENDERBUFFER, int32(samples), internalFmt, width, height)
+	} else {
+		gl.RenderbufferStorage(gl.RENDERBUFFER, internalFmt, width, height)
+	}
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+	return rbo
+}
+
+func newAttachmentTexture(internalFmt int32, format, pixType uint32, width, height, filter int32) *Texture {
+	if filter == 0 {
+		filter = gl.LINEAR
+	}
+	texture := Texture{
+		target: gl.TEXTURE_2D,
+		width:  width,
+		height: height,
+	}
+	gl.GenTextures(1, &texture.handle)
+	BindTexture(gl.TEXTURE0, texture.target, texture.handle)
+	gl.TexImage2D(texture.target, 0, internalFmt, width, height, 0, format, pixType, nil)
+	gl.TexParameteri(texture.target, gl.TEXTURE_MIN_FILTER, filter)
+	gl.TexParameteri(texture.target, gl.TEXTURE_MAG_FILTER, filter)
+	gl.TexParameteri(texture.target, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(texture.target, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	BindTexture(gl.TEXTURE0, texture.target, 0)
+	return &texture
+}
+
+// Bind makes the framebuffer the render target and sets the viewport to its size
+func (fb *Framebuffer) Bind() {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, fb.handle)
+	gl.Viewport(0, 0, int32(fb.opts.Width), int32(fb.opts.Height))
+}
+
+// UnBind goes back to the default framebuffer, the caller restores its viewport
+func (fb *Framebuffer) UnBind() {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+}
+
+func (fb *Framebuffer) Size() (width, height int) {
+	return fb.opts.Width, fb.opts.Height
+}
+
+// ColorTexture returns the i-th color attachment, the resolved one when multisampled
+func (fb *Framebuffer) ColorTexture(i int) *Texture {
+	if fb.resolve != nil {
+		return fb.resolve.ColorTexture(i)
+	}
+	if i < 0 || i >= len(fb.colors) {
+		return nil
+	}
+	return fb.colors[i]
+}
+
+// DepthTexture returns the depth attachment if it was created as a texture
+func (fb *Framebuffer) DepthTexture() *Texture {
+	if fb.resolve != nil {
+		return fb.resolve.DepthTexture()
+	}
+	return fb.depth
+}
+
+// Resolve copies the multisampled attachments into the sampleable textures,
+// it does nothing for single sample framebuffers
+func (fb *Framebuffer) Resolve() {
+	if fb.resolve == nil {
+		return
+	}
+	for i := range fb.opts.Colors {
+		fb.blitAttachment(fb.resolve, i, gl.COLOR_BUFFER_BIT, gl.NEAREST,
+			int32(fb.opts.Width), int32(fb.opts.Height))
+	}
+	if fb.resolve.depth != nil {
+		fb.blitAttachment(fb.resolve, -1, gl.DEPTH_BUFFER_BIT, gl.NEAREST,
+			int32(fb.opts.Width), int32(fb.opts.Height))
+	}
+}
+
+// Blit copies the given buffers (ex: gl.COLOR_BUFFER_BIT) into dst, scaling
+// with filter when sizes differ, a nil dst is the default framebuffer of the given size.
+// Multisampled framebuffers can only be blitted to one of the same size
+func (fb *Framebuffer) Blit(dst *Framebuffer, mask uint32, filter uint32, dstWidth, dstHeight int) {
+	if dst != nil {
+		dstWidth, dstHeight = dst.opts.Width, dst.opts.Height
+	}
+	fb.blitAttachment(dst, 0, mask, filter, int32(dstWidth), int32(dstHeight))
+}
+
+// blitAttachment blits the color attachment i (or only depth when i < 0) into
+// the same attachment of dst, nil for the default framebuffer. The draw
+// buffers of dst are restored afterwards
+func (fb *Framebuffer) blitAttachment(dst *Framebuffer, i int, mask, filter uint32, dstWidth, dstHeight int32) {
+	var dstHandle uint32
+	if dst != nil {
+		dstHandle = dst.handle
+	}
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, fb.handle)
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, dstHandle)
+	if i >= 0 && len(fb.opts.Colors) > 0 {
+		gl.ReadBuffer(uint32(gl.COLOR_ATTACHMENT0 + i))
+		if dst != nil {
+			drawBuffer := uint32(gl.COLOR_ATTACHMENT0 + i)
+			gl.DrawBuffers(1, &drawBuffer)
+		}
+	}
+	gl.BlitFramebuffer(0, 0, int32(fb.opts.Width), int32(fb.opts.Height), 0, 0, dstWidth, dstHeight, mask, filter)
+
+	// restore every draw buffer of the destination, its own count may differ from ours
+	if dst != nil && i >= 0 && len(fb.opts.Colors) > 0 && len(dst.opts.Colors) > 0 {
+		drawBuffers := make([]uint32, len(dst.opts.Colors))
+		for j := range drawBuffers {
+			drawBuffers[j] = uint32(gl.COLOR_ATTACHMENT0 + j)
+		}
+		gl.DrawBuffers(int32(len(drawBuffers)), &drawBuffers[0])
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+}
+
+// Resize recreates every attachment with the new size, previous contents are lost
+func (fb *Framebuffer) Resize(width, height int) error {
+	if width == fb.opts.Width && height == fb.opts.Height {
+		return nil
+	}
+	fb.Delete()
+	fb.opts.Width, fb.opts.Height = width, height
+	if err := fb.create(); err != nil {
+		fb.Delete()
+		return err
+	}
+	return nil
+}
+
+// ReadPixels reads back the i-th color attachment as 8 bit RGBA, flipped so the
+// first row is the top of the image, ex: for offscreen screenshots
+func (fb *Framebuffer) ReadPixels(i int) *image.RGBA {
+	fb.Resolve()
+	src := fb
+	if fb.resolve != nil {
+		src = fb.resolve
+	}
+	width, height := src.opts.Width, src.opts.Height
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, src.handle)
+	gl.ReadBuffer(uint32(gl.COLOR_ATTACHMENT0 + i))
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+
+	// GL rows start at the bottom
+	row := make([]byte, img.Stride)
+	for y := 0; y < height/2; y++ {
+		top := img.Pix[y*img.Stride : (y+1)*img.Stride]
+		bottom := img.Pix[(height-1-y)*img.Stride : (height-y)*img.Stride]
+		copy(row, top)
+		copy(top, bottom)
+		copy(bottom, row)
+	}
+	return img
+}
+
+func (fb *Framebuffer) Delete() {
+	for _, tex := range fb.colors {
+		tex.Delete()
+	}
+	if fb.depth != nil {
+		fb.depth.Delete()
+	}
+	if len(fb.renderbuffers) > 0 {
+		gl.DeleteRenderbuffers(int32(len(fb.renderbuffers)), &fb.renderbuffers[0])
+	}
+	if fb.resolve != nil {
+		fb.resolve.Delete()
+	}
+	if fb.handle != 0 {
+		gl.DeleteFramebuffers(1, &fb.handle)
+	}
+	fb.handle, fb.colors, fb.depth, fb.renderbuffers, fb.resolve = 0, nil, nil, nil, nil
+}
@@ -0,0 +1,169 @@
+package gfx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// FloatImage is an image of linear float channels, rows from top to bottom
+type FloatImage struct {
+	Width    int
+	Height   int
+	Channels int
+	Pix      []float32
+}
+
+func NewFloatImage(width, height, channels int) *FloatImage {
+	return &FloatImage{
+		Width:    width,
+		Height:   height,
+		Channels: channels,
+		Pix:      make([]float32, width*height*channels),
+	}
+}
+
+// At returns the channels of the pixel at x, y
+func (img *FloatImage) At(x, y int) []float32 {
+	i := (y*img.Width + x) * img.Channels
+	return img.Pix[i : i+img.Channels]
+}
+
+var (
+	errHDRFormat     = errors.New("not a radiance hdr image")
+	errHDRResolution = errors.New("only -Y height +X width hdr images are supported")
+	errHDRScanline   = errors.New("corrupt hdr scanline")
+)
+
+// LoadHDR reads a Radiance RGBE (.hdr) image as RGB floats
+func LoadHDR(file string) (*FloatImage, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := DecodeHDR(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return img, nil
+}
+
+func DecodeHDR(r *bufio.Reader) (*FloatImage, error) {
+	magic, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(magic, "#?RADIANCE") && !strings.HasPrefix(magic, "#?RGBE") {
+		return nil, errHDRFormat
+	}
+
+	// header variables until an empty line
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported hdr %s", line)
+		}
+	}
+
+	var width, height int
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil {
+		return nil, errHDRResolution
+	}
+
+	img := NewFloatImage(width, height, 3)
+	scanline := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(r, scanline); err != nil {
+			return nil, err
+		}
+		for x := 0; x < width; x++ {
+			rgbeToFloat(scanline[x*4:x*4+4], img.At(x, y))
+		}
+	}
+	return img, nil
+}
+
+// readHDRScanline reads a flat or run length encoded scanline into rgbe pixels
+func readHDRScanline(r *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	// new rle scanlines start with 2, 2 and the width, anything else is flat
+	if width < 8 || width > 0x7fff || header[0] != 2 || header[1] != 2 || header[2]&0x80 != 0 {
+		copy(scanline, header)
+		_, err := io.ReadFull(r, scanline[4:])
+		return err
+	}
+	if int(header[2])<<8|int(header[3]) != width {
+		return errHDRScanline
+	}
+
+	// each channel is stored apart as runs of a repeated byte or of literal bytes
+	for channel := 0; channel < 4; channel++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count) - 128
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+n > width {
+					return errHDRScanline
+				}
+				for ; n > 0; n-- {
+					scanline[x*4+channel] = value
+					x++
+				}
+				continue
+			}
+			n := int(count)
+			if n == 0 || x+n > width {
+				return errHDRScanline
+			}
+			for ; n > 0; n-- {
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				scanline[x*4+channel] = value
+				x++
+			}
+		}
+	}
+	return nil
+}
+
+func rgbeToFloat(rgbe []byte, rgb []float32) {
+	if rgbe[3] == 0 {
+		rgb[0], rgb[1], rgb[2] = 0, 0, 0
+		return
+	}
+	scale := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+	rgb[0] = float32(rgbe[0]) * scale
+	rgb[1] = float32(rgbe[1]) * scale
+	rgb[2] = float32(rgbe[2]) * scale
+}
@@ -0,0 +1,104 @@
+package gfx
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func decodeHDRBytes(data []byte) (*FloatImage, error) {
+	return DecodeHDR(bufio.NewReader(bytes.NewReader(data)))
+}
+
+func hdrFile(header string, pixels ...byte) []byte {
+	return append([]byte(header), pixels...)
+}
+
+func TestRGBEToFloat(t *testing.T) {
+	tests := []struct {
+		rgbe [4]byte
+		want [3]float32
+	}{
+		{rgbe: [4]byte{128, 64, 0, 129}, want: [3]float32{1, 0.5, 0}},
+		{rgbe: [4]byte{128, 128, 128, 136}, want: [3]float32{128, 128, 128}},
+		{rgbe: [4]byte{255, 255, 255, 0}, want: [3]float32{0, 0, 0}},
+	}
+	for _, test := range tests {
+		rgb := make([]float32, 3)
+		rgbeToFloat(test.rgbe[:], rgb)
+		if rgb[0] != test.want[0] || rgb[1] != test.want[1] || rgb[2] != test.want[2] {
+			t.Errorf("rgbeToFloat(%v) = %v, want %v", test.rgbe, rgb, test.want)
+		}
+	}
+}
+
+func TestDecodeHDRFlat(t *testing.T) {
+	img, err := decodeHDRBytes(hdrFile("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1\n\n-Y 2 +X 2\n",
+		128, 0, 0, 129, 0, 128, 0, 129,
+		0, 0, 128, 129, 128, 128, 128, 130,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 2 || img.Height != 2 || img.Channels != 3 {
+		t.Fatalf("got %dx%d with %d channels", img.Width, img.Height, img.Channels)
+	}
+	want := []float32{1, 0, 0, 0, 1, 0, 0, 0, 1, 2, 2, 2}
+	for i := range want {
+		if img.Pix[i] != want[i] {
+			t.Fatalf("pixels %v, want %v", img.Pix, want)
+		}
+	}
+}
+
+func TestDecodeHDRRLE(t *testing.T) {
+	// a run of 8 for red, 8 literals for green, two runs for blue and a
+	// run of 8 for the exponent
+	img, err := decodeHDRBytes(hdrFile("#?RGBE\n\n-Y 1 +X 8\n",
+		2, 2, 0, 8,
+		128+8, 128,
+		8, 0, 16, 32, 48, 64, 80, 96, 112,
+		128+3, 64, 128+5, 0,
+		128+8, 129,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 8; x++ {
+		blue := float32(0)
+		if x < 3 {
+			blue = 0.5
+		}
+		want := []float32{1, float32(x*16) / 128, blue}
+		got := img.At(x, 0)
+		if got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Errorf("pixel %d is %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestDecodeHDRErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "magic", data: hdrFile("P6\n\n-Y 1 +X 1\n", 0, 0, 0, 0), err: errHDRFormat},
+		{name: "resolution", data: hdrFile("#?RADIANCE\n\n+Y 1 +X 1\n", 0, 0, 0, 0), err: errHDRResolution},
+		{name: "rle width", data: hdrFile("#?RADIANCE\n\n-Y 1 +X 8\n", 2, 2, 0, 9), err: errHDRScanline},
+		{name: "run past the width", data: hdrFile("#?RADIANCE\n\n-Y 1 +X 8\n", 2, 2, 0, 8, 128+9, 0), err: errHDRScanline},
+		{name: "empty literal", data: hdrFile("#?RADIANCE\n\n-Y 1 +X 8\n", 2, 2, 0, 8, 0), err: errHDRScanline},
+	}
+	for _, test := range tests {
+		if _, err := decodeHDRBytes(test.data); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	if _, err := decodeHDRBytes(hdrFile("#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n", 0, 0, 0, 0)); err == nil {
+		t.Error("xyze format decoded")
+	}
+	if _, err := decodeHDRBytes(hdrFile("#?RADIANCE\n\n-Y 2 +X 1\n", 0, 0, 0, 0)); err == nil {
+		t.Error("truncated image decoded")
+	}
+}
@@ -0,0 +1,435 @@
+package gfx
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// IBLOptions are the sizes and quality of the precomputed lighting maps
+type IBLOptions struct {
+	EnvironmentSize  int // faces of the environment and of the sharpest prefiltered level
+	IrradianceSize   int
+	PrefilterLevels  int // roughness 0 to 1 in as many mip levels
+	PrefilterSamples int
+	BRDFSize         int
+	BRDFSamples      int
+}
+
+func DefaultIBLOptions() IBLOptions {
+	return IBLOptions{
+		EnvironmentSize:  256,
+		IrradianceSize:   32,
+		PrefilterLevels:  5,
+		PrefilterSamples: 256,
+		BRDFSize:         128,
+		BRDFSamples:      512,
+	}
+}
+
+// IBLData holds the maps of image based lighting computed on the cpu, it can be
+// baked offline with Save and loaded later without a GL context
+type IBLData struct {
+	Options IBLOptions
+	// Irradiance is the cosine weighted average radiance around each normal,
+	// the diffuse light is irradiance * albedo
+	Irradiance *FloatCubemap
+	// Prefiltered holds the environment blurred by the GGX lobe of roughness
+	// level / (levels - 1) at each level, each level half the size of the previous
+	Prefiltered []*FloatCubemap
+	// BRDF is the scale (red) and bias (green) of F0 of the split sum, by
+	// NdotV on x and roughness on y
+	BRDF *FloatImage
+}
+
+// ComputeIBL precomputes every map from an equirectangular HDR image
+func ComputeIBL(img *FloatImage, opts IBLOptions) *IBLData {
+	env := FloatCubemapFromEquirectangular(img, opts.EnvironmentSize)
+	return &IBLData{
+		Options:     opts,
+		Irradiance:  ComputeIrradiance(env, opts.IrradianceSize),
+		Prefiltered: ComputePrefiltered(env, opts.PrefilterLevels, opts.PrefilterSamples),
+		BRDF:        ComputeBRDF(opts.BRDFSize, opts.BRDFSamples),
+	}
+}
+
+// ComputeIrradiance convolves env with a cosine lobe around every texel direction
+func ComputeIrradiance(env *FloatCubemap, size int) *FloatCubemap {
+	// the result is very blurry, a small source gives the same and is much faster
+	for env.Size > 32 {
+		env = env.Downsample()
+	}
+
+	// direction and solid angle of every source texel
+	type sample struct {
+		x, y, z, solidAngle float64
+		color               [3]float32
+	}
+	samples := make([]sample, 0, 6*env.Size*env.Size)
+	texelArea := math.Pow(2/float64(env.Size), 2)
+	for face := range env.Faces {
+		for j := 0; j < env.Size; j++ {
+			for i := 0; i < env.Size; i++ {
+				u := 2*(float64(i)+0.5)/float64(env.Size) - 1
+				v := 2*(float64(j)+0.5)/float64(env.Size) - 1
+				x, y, z := cubemapDirection(face, u, v)
+				length := math.Sqrt(x*x + y*y + z*z)
+				k := (j*env.Size + i) * 3
+				samples = append(samples, sample{
+					x:          x / length,
+					y:          y / length,
+					z:          z / length,
+					solidAngle: texelArea / math.Pow(1+u*u+v*v, 1.5),
+					color:      [3]float32{env.Faces[face][k], env.Faces[face][k+1], env.Faces[face][k+2]},
+				})
+			}
+		}
+	}
+
+	irradiance := NewFloatCubemap(size)
+	irradiance.forEachTexel(func(face, i, j int, x, y, z float64) {
+		var sum [3]float64
+		for _, s := range samples {
+			cos := x*s.x + y*s.y + z*s.z
+			if cos <= 0 {
+				continue
+			}
+			weight := cos * s.solidAngle
+			sum[0] += float64(s.color[0]) * weight
+			sum[1] += float64(s.color[1]) * weight
+			sum[2] += float64(s.color[2]) * weight
+		}
+		irradiance.set(face, i, j, [3]float32{
+			float32(sum[0] / math.Pi),
+			float32(sum[1] / math.Pi),
+			float32(sum[2] / math.Pi),
+		})
+	})
+	return irradiance
+}
+
+// ComputePrefiltered blurs env for the specular light of increasing roughness,
+// it assumes the view, normal and reflection are the same direction
+func ComputePrefiltered(env *FloatCubemap, levels, samples int) []*FloatCubemap {
+	// sampling a blurrier version of env where the lobe is wide removes most of the noise
+	mips := []*FloatCubemap{env}
+	for mips[len(mips)-1].Size > 1 {
+		mips = append(mips, mips[len(mips)-1].Downsample())
+	}
+
+	prefiltered := make([]*FloatCubemap, levels)
+	prefiltered[0] = env
+	for level := 1; level < levels; level++ {
+		roughness := float64(level) / float64(levels-1)
+		size := env.Size >> uint(level)
+		if size < 1 {
+			size = 1
+		}
+		dst := NewFloatCubemap(size)
+		dst.forEachTexel(func(face, i, j int, x, y, z float64) {
+			n := [3]float64{x, y, z}
+			var sum [3]float64
+			var totalWeight float64
+			for s := 0; s < samples; s++ {
+				xi0, xi1 := hammersley(s, samples)
+				h := importanceSampleGGX(xi0, xi1, n, roughness)
+				vDotH := dot3(n, h)
+				l := [3]float64{2*vDotH*h[0] - n[0], 2*vDotH*h[1] - n[1], 2*vDotH*h[2] - n[2]}
+				nDotL := dot3(n, l)
+				if nDotL <= 0 {
+					continue
+				}
+
+				// pick the mip whose texels cover about the solid angle of the sample
+				nDotH := dot3(n, h)
+				d := distributionGGX(nDotH, roughness)
+				pdf := d*nDotH/(4*vDotH) + 0.0001
+				saTexel := 4 * math.Pi / (6 * float64(env.Size*env.Size))
+				saSample := 1 / (float64(samples)*pdf + 0.0001)
+				mip := int(math.Max(0.5*math.Log2(saSample/saTexel)+1, 0))
+				if mip >= len(mips) {
+					mip = len(mips) - 1
+				}
+
+				color := mips[mip].Sample(l[0], l[1], l[2])
+				sum[0] += float64(color[0]) * nDotL
+				sum[1] += float64(color[1]) * nDotL
+				sum[2] += float64(color[2]) * nDotL
+				totalWeight += nDotL
+			}
+			dst.set(face, i, j, [3]float32{
+				float32(sum[0] / totalWeight),
+				float32(sum[1] / totalWeight),
+				float32(sum[2] / totalWeight),
+			})
+		})
+		prefiltered[level] = dst
+	}
+	return prefiltered
+}
+
+// ComputeBRDF integrates the specular BRDF for the split sum approximation
+func ComputeBRDF(size, samples int) *FloatImage {
+	img := NewFloatImage(size, size, 2)
+	var wg sync.WaitGroup
+	for j := 0; j < size; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			// rows go up in roughness so the texture is sampled with roughness as v
+			roughness := (float64(j) + 0.5) / float64(size)
+			for i := 0; i < size; i++ {
+				nDotV := (float64(i) + 0.5) / float64(size)
+				a, b := integrateBRDF(nDotV, roughness, samples)
+				pixel := img.At(i, j)
+				pixel[0], pixel[1] = float32(a), float32(b)
+			}
+		}(j)
+	}
+	wg.Wait()
+	return img
+}
+
+func integrateBRDF(nDotV, roughness float64, samples int) (a, b float64) {
+	v := [3]float64{math.Sqrt(1 - nDotV*nDotV), 0, nDotV}
+	n := [3]float64{0, 0, 1}
+	for s := 0; s < samples; s++ {
+		xi0, xi1 := hammersley(s, samples)
+		h := importanceSampleGGX(xi0, xi1, n, roughness)
+		vDotH := dot3(v, h)
+		l := [3]float64{2*vDotH*h[0] - v[0], 2*vDotH*h[1] - v[1], 2*vDotH*h[2] - v[2]}
+		nDotL := math.Max(l[2], 0)
+		nDotH := math.Max(h[2], 0)
+		vDotH = math.Max(vDotH, 0)
+		if nDotL <= 0 {
+			continue
+		}
+		g := geometrySmithIBL(nDotV, nDotL, roughness)
+		gVis := g * vDotH / (nDotH * nDotV)
+		fc := math.Pow(1-vDotH, 5)
+		a += (1 - fc) * gVis
+		b += fc * gVis
+	}
+	return a / float64(samples), b / float64(samples)
+}
+
+// hammersley returns the i-th point of a low discrepancy sequence of n in [0, 1)^2
+func hammersley(i, n int) (float64, float64) {
+	bits := uint32(i)
+	bits = (bits << 16) | (bits >> 16)
+	bits = ((bits & 0x55555555) << 1) | ((bits & 0xAAAAAAAA) >> 1)
+	bits = ((bits & 0x33333333) << 2) | ((bits & 0xCCCCCCCC) >> 2)
+	bits = ((bits & 0x0F0F0F0F) << 4) | ((bits & 0xF0F0F0F0) >> 4)
+	bits = ((bits & 0x00FF00FF) << 8) | ((bits & 0xFF00FF00) >> 8)
+	return float64(i) / float64(n), float64(bits) * 2.3283064365386963e-10
+}
+
+// importanceSampleGGX returns a half vector around n distributed as the GGX lobe
+func importanceSampleGGX(xi0, xi1 float64, n [3]float64, roughness float64) [3]float64 {
+	a := roughness * roughness
+	phi := 2 * math.Pi * xi0
+	cosTheta := math.Sqrt((1 - xi1) / (1 + (a*a-1)*xi1))
+	sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+	hx, hy, hz := math.Cos(phi)*sinTheta, math.Sin(phi)*sinTheta, cosTheta
+
+	// tangent space to world
+	up := [3]float64{0, 0, 1}
+	if math.Abs(n[2]) >= 0.999 {
+		up = [3]float64{1, 0, 0}
+	}
+	tangent := normalize3(cross3(up, n))
+	bitangent := cross3(n, tangent)
+	return normalize3([3]float64{
+		tangent[0]*hx + bitangent[0]*hy + n[0]*hz,
+		tangent[1]*hx + bitangent[1]*hy + n[1]*hz,
+		tangent[2]*hx + bitangent[2]*hy + n[2]*hz,
+	})
+}
+
+func distributionGGX(nDotH, roughness float64) float64 {
+	a := roughness * roughness
+	a2 := a * a
+	denom := nDotH*nDotH*(a2-1) + 1
+	return a2 / (math.Pi * denom * denom)
+}
+
+// geometrySmithIBL uses k = roughness^2 / 2, the remapping for image based lighting
+func geometrySmithIBL(nDotV, nDotL, roughness float64) float64 {
+	k := roughness * roughness / 2
+	return nDotV / (nDotV*(1-k) + k) * nDotL / (nDotL*(1-k) + k)
+}
+
+func dot3(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross3(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize3(a [3]float64) [3]float64 {
+	length := math.Sqrt(dot3(a, a))
+	return [3]float64{a[0] / length, a[1] / length, a[2] / length}
+}
+
+// Save writes the maps to a gzip compressed file that LoadIBLData reads back
+func (data *IBLData) Save(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	if err := gob.NewEncoder(zw).Encode(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func LoadIBLData(file string) (*IBLData, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	var data IBLData
+	if err := gob.NewDecoder(zr).Decode(&data); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &data, nil
+}
+
+// LoadOrComputeIBL reads cacheFile when it was baked with the same options and
+// is newer than hdrFile, else computes the maps and writes them to cacheFile
+func LoadOrComputeIBL(hdrFile, cacheFile string, opts IBLOptions) (*IBLData, error) {
+	hdrInfo, err := os.Stat(hdrFile)
+	if err != nil {
+		return nil, err
+	}
+	if cacheInfo, err := os.Stat(cacheFile); err == nil && cacheInfo.ModTime().After(hdrInfo.ModTime()) {
+		if data, err := LoadIBLData(cacheFile); err == nil && data.Options == opts {
+			return data, nil
+		}
+	}
+
+	img, err := LoadHDR(hdrFile)
+	if err != nil {
+		return nil, err
+	}
+	data := ComputeIBL(img, opts)
+	if err := data.Save(cacheFile); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// IBL holds the lighting maps on the gpu, bound to the irradianceMap,
+// prefilterMap and brdfLUT samplers of a pbr shader
+type IBL struct {
+	Irradiance  *Texture
+	Prefiltered *Texture
+	BRDF        *Texture
+	// MaxLod is the mip level of roughness 1 in Prefiltered
+	MaxLod float32
+}
+
+func NewIBL(data *IBLData) (*IBL, error) {
+	if data.Irradiance == nil || len(data.Prefiltered) == 0 || data.BRDF == nil {
+		return nil, fmt.Errorf("incomplete ibl data")
+	}
+	ibl := IBL{
+		Irradiance:  newFloatCubemapTexture([]*FloatCubemap{data.Irradiance}),
+		Prefiltered: newFloatCubemapTexture(data.Prefiltered),
+		MaxLod:      float32(len(data.Prefiltered) - 1),
+	}
+
+	var handle uint32
+	gl.GenTextures(1, &handle)
+	ibl.BRDF = &Texture{
+		handle: handle,
+		target: gl.TEXTURE_2D,
+		width:  int32(data.BRDF.Width),
+		height: int32(data.BRDF.Height),
+	}
+	ibl.BRDF.Bind(gl.TEXTURE0)
+	ibl.BRDF.setParameters(TextureOptions{
+		MinFilter: gl.LINEAR,
+		MagFilter: gl.LINEAR,
+		WrapS:     gl.CLAMP_TO_EDGE,
+		WrapT:     gl.CLAMP_TO_EDGE,
+		WrapR:     gl.CLAMP_TO_EDGE,
+	})
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RG16F, int32(data.BRDF.Width), int32(data.BRDF.Height), 0,
+		gl.RG, gl.FLOAT, gl.Ptr(data.BRDF.Pix))
+	ibl.BRDF.UnBind()
+
+	return &ibl, nil
+}
+
+// newFloatCubemapTexture uploads levels as the mip levels of a RGB16F cubemap
+func newFloatCubemapTexture(levels []*FloatCubemap) *Texture {
+	var handle uint32
+	gl.GenTextures(1, &handle)
+	texture := Texture{
+		handle: handle,
+		target: gl.TEXTURE_CUBE_MAP,
+		width:  int32(levels[0].Size),
+		height: int32(levels[0].Size),
+	}
+	texture.Bind(gl.TEXTURE0)
+	defer texture.UnBind()
+
+	opts := CubemapOptions()
+	opts.Mipmaps = len(levels) > 1
+	texture.setParameters(opts)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAX_LEVEL, int32(len(levels)-1))
+
+	for level, cubemap := range levels {
+		for face, pix := range cubemap.Faces {
+			gl.TexImage2D(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+face), int32(level), gl.RGB16F,
+				int32(cubemap.Size), int32(cubemap.Size), 0, gl.RGB, gl.FLOAT, gl.Ptr(pix))
+		}
+	}
+	return &texture
+}
+
+// Bind binds the maps to consecutive units starting at firstUnit, ex: gl.TEXTURE5
+func (ibl *IBL) Bind(firstUnit uint32) {
+	ibl.Irradiance.Bind(firstUnit)
+	ibl.Prefiltered.Bind(firstUnit + 1)
+	ibl.BRDF.Bind(firstUnit + 2)
+}
+
+// SetUniforms points the samplers of the program in use to the bound maps
+func (ibl *IBL) SetUniforms(irradianceLoc, prefilterLoc, brdfLoc, maxLodLoc int32) {
+	ibl.Irradiance.SetUniform(irradianceLoc)
+	ibl.Prefiltered.SetUniform(prefilterLoc)
+	ibl.BRDF.SetUniform(brdfLoc)
+	gl.Uniform1f(maxLodLoc, ibl.MaxLod)
+}
+
+func (ibl *IBL) UnBind() {
+	for _, texture := range []*Texture{ibl.Irradiance, ibl.Prefiltered, ibl.BRDF} {
+		if texture.texUnit != 0 {
+			texture.UnBind()
+		}
+	}
+	ActiveTexture(gl.TEXTURE0)
+}
+
+func (ibl *IBL) Delete() {
+	ibl.Irradiance.Delete()
+	ibl.Prefiltered.Delete()
+	ibl.BRDF.Delete()
+}
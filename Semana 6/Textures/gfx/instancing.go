@@ -0,0 +1,266 @@
+package gfx
+
+import (
+	"log"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// attribute locations of the per instance data, after the position, texture
+// coordinates and normals of the meshes. The model matrix takes four, one per column
+const (
+	InstanceModelLocation  = 3
+	InstanceTintLocation   = 7
+	InstanceCustomLocation = 8
+)
+
+// Instance is the data of one copy of an instanced mesh
+type Instance struct {
+	Model mgl32.Mat4
+	// Tint multiplies the color of the material, alpha included
+	Tint mgl32.Vec4
+	// Custom is free for the shaders, ex: a wind phase or a texture layer
+	Custom mgl32.Vec4
+}
+
+const instanceSize = int32(unsafe.Sizeof(Instance{}))
+
+// InstanceLayout is the layout of Instance, interleaved in the instance buffer
+var InstanceLayout = VertexLayout{Interleaved: true, Attributes: []VertexAttribute{
+	{Name: "instanceModel[0]", Location: InstanceModelLocation, Components: 4, Type: gl.FLOAT},
+	{Name: "instanceModel[1]", Location: InstanceModelLocation + 1, Components: 4, Type: gl.FLOAT},
+	{Name: "instanceModel[2]", Location: InstanceModelLocation + 2, Components: 4, Type: gl.FLOAT},
+	{Name: "instanceModel[3]", Location: InstanceModelLocation + 3, Components: 4, Type: gl.FLOAT},
+	{Name: "instanceTint", Location: InstanceTintLocation, Components: 4, Type: gl.FLOAT},
+	{Name: "instanceCustom", Location: InstanceCustomLocation, Components: 4, Type: gl.FLOAT},
+}}
+
+// InstanceBuffer holds the instances of a draw, it is attached to each vertex
+// array it is drawn with and grows as needed
+type InstanceBuffer struct {
+	buffer *Buffer
+	count  int
+}
+
+func NewInstanceBuffer(capacity int) *InstanceBuffer {
+	return &InstanceBuffer{
+		buffer: NewBuffer(gl.ARRAY_BUFFER, capacity*int(instanceSize), nil, gl.DYNAMIC_DRAW),
+	}
+}
+
+// AttachTo makes vao read the instance attributes from this buffer, advancing
+// once per instance instead of once per vertex
+func (b *InstanceBuffer) AttachTo(vao uint32) {
+	BindVertexArray(vao)
+	b.buffer.Bind()
+	offset := 0
+	for _, attribute := range InstanceLayout.Attributes {
+		gl.VertexAttribPointer(attribute.Location, attribute.Components, attribute.Type, false, instanceSize, gl.PtrOffset(offset))
+		gl.EnableVertexAttribArray(attribute.Location)
+		gl.VertexAttribDivisor(attribute.Location, 1)
+		offset += attribute.Size()
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	BindVertexArray(0)
+}
+
+// Set uploads instances, replacing the previous ones. The old storage is
+// orphaned so a draw still reading it does not stall the upload
+func (b *InstanceBuffer) Set(instances []Instance) {
+	b.count = len(instances)
+	size := b.count * int(instanceSize)
+	if size > b.buffer.Size() {
+		// the old instances are all replaced, no need to keep them
+		b.buffer.Allocate(size)
+	} else {
+		b.buffer.Orphan()
+	}
+	if b.count > 0 {
+		b.buffer.SetSubData(0, size, gl.Ptr(&instances[0]))
+	}
+}
+
+// Len is the number of instances of the last Set
+func (b *InstanceBuffer) Len() int {
+	return b.count
+}
+
+func (b *InstanceBuffer) Delete() {
+	b.buffer.Delete()
+}
+
+// Mesh is a vertex array and how it is drawn
+type Mesh struct {
+	VAO   uint32
+	Mode  uint32 // ex: gl.TRIANGLE_STRIP
+	Count int32  // vertices, or indices when Indexed
+	// Indexed meshes are drawn with DrawElements and uint32 indices
+	Indexed bool
+	// Layout, when it has attributes, is checked against the program the
+	// first time the mesh is drawn, ex: VertexArray.Layout()
+	Layout VertexLayout
+}
+
+// Material is the color and optional texture of the instanced draws
+type Material struct {
+	Color   mgl32.Vec3
+	Texture *Texture
+}
+
+const instancedVertSrc = `#version 410 core
+layout (location = 0) in vec3 position;
+layout (location = 1) in vec2 texCoord;
+layout (location = 3) in mat4 instanceModel;
+layout (location = 7) in vec4 instanceTint;
+layout (location = 8) in vec4 instanceCustom;
+
+uniform mat4 camera;
+uniform mat4 project;
+
+out vec2 TexCoord;
+out vec4 Tint;
+
+void main()
+{
+    gl_Position = project * camera * instanceModel * vec4(position, 1.0);
+    TexCoord = texCoord;
+    Tint = instanceTint;
+}`
+
+const instancedFragSrc = `#version 410 core
+in vec2 TexCoord;
+in vec4 Tint;
+
+out vec4 color;
+
+uniform vec3 objectColor;
+uniform bool hasTexture;
+uniform sampler2D material;
+
+void main()
+{
+    color = vec4(objectColor, 1.0) * Tint;
+    if (hasTexture) {
+        color *= texture(material, TexCoord);
+    }
+}`
+
+// InstanceRenderer draws many copies of a mesh in one draw call
+type InstanceRenderer struct {
+	program  *Program
+	buffer   *InstanceBuffer
+	attached map[uint32]bool
+	scratch  []Instance
+
+	cameraLoc     int32
+	projectLoc    int32
+	colorLoc      int32
+	hasTextureLoc int32
+	samplerLoc    int32
+}
+
+func NewInstanceRenderer() (*InstanceRenderer, error) {
+	vertShader, err := NewShader(instancedVertSrc, gl.VERTEX_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	fragShader, err := NewShader(instancedFragSrc, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	program, err := NewProgram(vertShader, fragShader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InstanceRenderer{
+		program:       program,
+		buffer:        NewInstanceBuffer(64),
+		attached:      make(map[uint32]bool),
+		cameraLoc:     program.GetUniformLocation("camera"),
+		projectLoc:    program.GetUniformLocation("project"),
+		colorLoc:      program.GetUniformLocation("objectColor"),
+		hasTextureLoc: program.GetUniformLocation("hasTexture"),
+		samplerLoc:    program.GetUniformLocation("material"),
+	}, nil
+}
+
+// Begin uses the program of the renderer and sets the camera, the draws may
+// follow. The program in use has to be set again afterwards
+func (r *InstanceRenderer) Begin(camera, project mgl32.Mat4) {
+	r.program.Use()
+	gl.UniformMatrix4fv(r.cameraLoc, 1, false, &camera[0])
+	gl.UniformMatrix4fv(r.projectLoc, 1, false, &project[0])
+}
+
+// DrawInstances draws mesh once per transform, untinted
+func (r *InstanceRenderer) DrawInstances(mesh *Mesh, material *Material, transforms []mgl32.Mat4) {
+	r.scratch = r.scratch[:0]
+	for _, transform := range transforms {
+		r.scratch = append(r.scratch, Instance{Model: transform, Tint: mgl32.Vec4{1, 1, 1, 1}})
+	}
+	r.DrawInstanceData(mesh, material, r.scratch)
+}
+
+// DrawInstanceData draws mesh once per instance
+func (r *InstanceRenderer) DrawInstanceData(mesh *Mesh, material *Material, instances []Instance) {
+	if len(instances) == 0 {
+		return
+	}
+	if !r.attached[mesh.VAO] {
+		r.buffer.AttachTo(mesh.VAO)
+		r.attached[mesh.VAO] = true
+		r.checkLayout(mesh, material)
+	}
+	r.buffer.Set(instances)
+
+	gl.Uniform3fv(r.colorLoc, 1, &material.Color[0])
+	if material.Texture != nil {
+		material.Texture.Bind(gl.TEXTURE0)
+		material.Texture.SetUniform(r.samplerLoc)
+		gl.Uniform1i(r.hasTextureLoc, 1)
+	} else {
+		gl.Uniform1i(r.hasTextureLoc, 0)
+	}
+
+	// the texture is left bound, the state cache skips binding it again for
+	// the next draw with the same material
+	mesh.DrawInstanced(int32(len(instances)))
+	checkError("drawing instances")
+}
+
+// checkLayout logs the inputs of the program the mesh and the instances do not
+// feed, untextured materials do not read the texture coordinates
+func (r *InstanceRenderer) checkLayout(mesh *Mesh, material *Material) {
+	if len(mesh.Layout.Attributes) == 0 {
+		return
+	}
+	var unused []string
+	if material.Texture == nil {
+		unused = append(unused, "texCoord")
+	}
+	layout := VertexLayout{Attributes: append(append([]VertexAttribute{}, mesh.Layout.Attributes...), InstanceLayout.Attributes...)}
+	if err := r.program.CheckLayout(layout, unused...); err != nil {
+		log.Printf("instanced mesh %d: %v", mesh.VAO, err)
+	}
+}
+
+// DrawInstanced binds the vertex array and draws instances copies of the mesh,
+// its vertex array must have the instance attributes of an InstanceBuffer.
+// The vertex array is left bound, the next draw of the same mesh skips the bind
+func (m *Mesh) DrawInstanced(instances int32) {
+	BindVertexArray(m.VAO)
+	if m.Indexed {
+		gl.DrawElementsInstanced(m.Mode, m.Count, gl.UNSIGNED_INT, nil, instances)
+	} else {
+		gl.DrawArraysInstanced(m.Mode, 0, m.Count, instances)
+	}
+}
+
+// Delete frees the program and the instance buffer, the meshes are left to their owners
+func (r *InstanceRenderer) Delete() {
+	r.buffer.Delete()
+	r.program.Delete()
+}
@@ -0,0 +1,175 @@
+package gfx
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// VertexAttribute is one input of the vertex shader
+type VertexAttribute struct {
+	Name       string // as in the shader, ex: "position"
+	Location   uint32
+	Components int32  // 1 to 4
+	Type       uint32 // ex: gl.FLOAT, gl.UNSIGNED_BYTE
+	// Normalized maps the integer types to [0, 1], or [-1, 1] when signed
+	Normalized bool
+}
+
+// Size is the number of bytes of the attribute in one vertex
+func (a VertexAttribute) Size() int {
+	return int(a.Components) * typeSize(a.Type)
+}
+
+func typeSize(glType uint32) int {
+	switch glType {
+	case gl.BYTE, gl.UNSIGNED_BYTE:
+		return 1
+	case gl.SHORT, gl.UNSIGNED_SHORT, gl.HALF_FLOAT:
+		return 2
+	case gl.DOUBLE:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// VertexLayout describes the vertices of a VertexArray
+type VertexLayout struct {
+	Attributes []VertexAttribute
+	// Interleaved keeps all the attributes of a vertex together in a single
+	// buffer, otherwise each attribute has a buffer of its own
+	Interleaved bool
+}
+
+// common layouts, the locations match the shaders of this module
+var (
+	PositionLayout = VertexLayout{Attributes: []VertexAttribute{
+		{Name: "position", Location: 0, Components: 3, Type: gl.FLOAT},
+	}}
+	PositionTexLayout = VertexLayout{Attributes: []VertexAttribute{
+		{Name: "position", Location: 0, Components: 3, Type: gl.FLOAT},
+		{Name: "texCoord", Location: 1, Components: 2, Type: gl.FLOAT},
+	}}
+)
+
+// Stride is the number of bytes of one whole vertex
+func (l VertexLayout) Stride() int {
+	stride := 0
+	for _, attribute := range l.Attributes {
+		stride += attribute.Size()
+	}
+	return stride
+}
+
+// validate checks the attributes and that data, one slice when interleaved or
+// one per attribute otherwise, hold the same whole number of vertices
+func (l VertexLayout) validate(data []interface{}) (vertices int, err error) {
+	if len(l.Attributes) == 0 {
+		return 0, fmt.Errorf("vertex layout without attributes")
+	}
+	locations := make(map[uint32]string)
+	for _, attribute := range l.Attributes {
+		if attribute.Components < 1 || attribute.Components > 4 {
+			return 0, fmt.Errorf("attribute %q has %d components", attribute.Name, attribute.Components)
+		}
+		if other, ok := locations[attribute.Location]; ok {
+			return 0, fmt.Errorf("attributes %q and %q share location %d", other, attribute.Name, attribute.Location)
+		}
+		locations[attribute.Location] = attribute.Name
+	}
+
+	if l.Interleaved {
+		if len(data) != 1 {
+			return 0, fmt.Errorf("interleaved layout needs 1 slice, got %d", len(data))
+		}
+		size, err := sliceSize(data[0])
+		if err != nil {
+			return 0, err
+		}
+		if size%l.Stride() != 0 {
+			return 0, fmt.Errorf("%d bytes of vertices are not a whole number of %d byte vertices", size, l.Stride())
+		}
+		return size / l.Stride(), nil
+	}
+
+	if len(data) != len(l.Attributes) {
+		return 0, fmt.Errorf("layout with %d attributes got %d slices", len(l.Attributes), len(data))
+	}
+	vertices = -1
+	for i, attribute := range l.Attributes {
+		size, err := sliceSize(data[i])
+		if err != nil {
+			return 0, fmt.Errorf("attribute %q: %v", attribute.Name, err)
+		}
+		if size%attribute.Size() != 0 {
+			return 0, fmt.Errorf("attribute %q: %d bytes are not a whole number of %d byte values",
+				attribute.Name, size, attribute.Size())
+		}
+		count := size / attribute.Size()
+		if vertices >= 0 && count != vertices {
+			return 0, fmt.Errorf("attribute %q has %d vertices, %q has %d",
+				attribute.Name, count, l.Attributes[0].Name, vertices)
+		}
+		vertices = count
+	}
+	return vertices, nil
+}
+
+// sliceSize is the number of bytes of the elements of a slice
+func sliceSize(slice interface{}) (int, error) {
+	value := reflect.ValueOf(slice)
+	if value.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("vertex data must be a slice, got %T", slice)
+	}
+	return value.Len() * int(value.Type().Elem().Size()), nil
+}
+
+// NewVertexArrayWithLayout builds a vertex array from data laid out as layout,
+// data is one slice of any element type when the layout is interleaved or one
+// slice per attribute otherwise. indices may be nil for arrays drawn with
+// DrawArrays. An error tells which attribute does not match its data
+func NewVertexArrayWithLayout(layout VertexLayout, indices []uint32, data ...interface{}) (*VertexArray, error) {
+	vertices, err := layout.validate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	va := NewVertexArray()
+	va.vertices = vertices
+	if layout.Interleaved {
+		size, _ := sliceSize(data[0])
+		buffer := NewBuffer(gl.ARRAY_BUFFER, size, slicePtr(data[0], size), gl.STATIC_DRAW)
+		offset := 0
+		for _, attribute := range layout.Attributes {
+			va.addAttribute(buffer, attribute, int32(layout.Stride()), offset)
+			offset += attribute.Size()
+		}
+	} else {
+		for i, attribute := range layout.Attributes {
+			size, _ := sliceSize(data[i])
+			buffer := NewBuffer(gl.ARRAY_BUFFER, size, slicePtr(data[i], size), gl.STATIC_DRAW)
+			va.addAttribute(buffer, attribute, int32(attribute.Size()), 0)
+		}
+	}
+	if len(indices) > 0 {
+		va.SetIndexBuffer(NewIndexBuffer(indices))
+	}
+	return va, nil
+}
+
+// addAttribute is AddBuffer for any attribute type, the integer types that
+// are not normalized still reach the shader as floats
+func (va *VertexArray) addAttribute(buffer *Buffer, attribute VertexAttribute, stride int32, offset int) {
+	BindVertexArray(va.handle)
+	buffer.Bind()
+	gl.VertexAttribPointer(attribute.Location, attribute.Components, attribute.Type, attribute.Normalized,
+		stride, gl.PtrOffset(offset))
+	gl.EnableVertexAttribArray(attribute.Location)
+	buffer.UnBind()
+	BindVertexArray(0)
+	checkError("adding a vertex attribute")
+	va.own(buffer)
+	va.attributes = append(va.attributes, attribute)
+}
@@ -0,0 +1,110 @@
+package gfx
+
+import (
+	"testing"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestVertexLayoutStride(t *testing.T) {
+	layout := VertexLayout{Attributes: []VertexAttribute{
+		{Name: "position", Location: 0, Components: 3, Type: gl.FLOAT},
+		{Name: "color", Location: 1, Components: 4, Type: gl.UNSIGNED_BYTE, Normalized: true},
+		{Name: "texCoord", Location: 2, Components: 2, Type: gl.HALF_FLOAT},
+	}}
+	if stride := layout.Stride(); stride != 12+4+4 {
+		t.Errorf("stride %d, want 20", stride)
+	}
+}
+
+func TestVertexLayoutValidate(t *testing.T) {
+	interleaved := VertexLayout{Interleaved: true, Attributes: PositionTexLayout.Attributes}
+	tests := []struct {
+		name     string
+		layout   VertexLayout
+		data     []interface{}
+		vertices int
+		ok       bool
+	}{
+		{
+			name:     "one slice per attribute",
+			layout:   PositionTexLayout,
+			data:     []interface{}{make([]mgl32.Vec3, 4), make([]mgl32.Vec2, 4)},
+			vertices: 4, ok: true,
+		},
+		{
+			name:     "flat floats",
+			layout:   PositionTexLayout,
+			data:     []interface{}{make([]float32, 9), make([]float32, 6)},
+			vertices: 3, ok: true,
+		},
+		{
+			name:     "interleaved",
+			layout:   interleaved,
+			data:     []interface{}{make([]float32, 5*6)},
+			vertices: 6, ok: true,
+		},
+		{
+			name:   "no attributes",
+			layout: VertexLayout{},
+			data:   []interface{}{make([]float32, 3)},
+		},
+		{
+			name: "too many components",
+			layout: VertexLayout{Attributes: []VertexAttribute{
+				{Name: "position", Location: 0, Components: 5, Type: gl.FLOAT},
+			}},
+			data: []interface{}{make([]float32, 5)},
+		},
+		{
+			name: "shared location",
+			layout: VertexLayout{Attributes: []VertexAttribute{
+				{Name: "position", Location: 0, Components: 3, Type: gl.FLOAT},
+				{Name: "normal", Location: 0, Components: 3, Type: gl.FLOAT},
+			}},
+			data: []interface{}{make([]float32, 3), make([]float32, 3)},
+		},
+		{
+			name:   "missing slice",
+			layout: PositionTexLayout,
+			data:   []interface{}{make([]float32, 3)},
+		},
+		{
+			name:   "interleaved with two slices",
+			layout: interleaved,
+			data:   []interface{}{make([]float32, 5), make([]float32, 5)},
+		},
+		{
+			name:   "interleaved partial vertex",
+			layout: interleaved,
+			data:   []interface{}{make([]float32, 7)},
+		},
+		{
+			name:   "partial value",
+			layout: PositionTexLayout,
+			data:   []interface{}{make([]float32, 4), make([]float32, 2)},
+		},
+		{
+			name:   "vertex count mismatch",
+			layout: PositionTexLayout,
+			data:   []interface{}{make([]mgl32.Vec3, 4), make([]mgl32.Vec2, 3)},
+		},
+		{
+			name:   "not a slice",
+			layout: PositionLayout,
+			data:   []interface{}{mgl32.Vec3{}},
+		},
+	}
+	for _, test := range tests {
+		vertices, err := test.layout.validate(test.data)
+		switch {
+		case test.ok && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.ok && vertices != test.vertices:
+			t.Errorf("%s: %d vertices, want %d", test.name, vertices, test.vertices)
+		case !test.ok && err == nil:
+			t.Errorf("%s: validated %d vertices", test.name, vertices)
+		}
+	}
+}
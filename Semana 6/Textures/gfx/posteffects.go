@@ -0,0 +1,389 @@
+package gfx
+
+// names of the built in post effects, used by PostChainConfig
+const (
+	EffectBloom        = "bloom"
+	EffectToneMapping  = "tonemapping"
+	EffectColorGrading = "colorgrading"
+	EffectVignette     = "vignette"
+	EffectGamma        = "gamma"
+	EffectFXAA         = "fxaa"
+)
+
+const copyFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+
+void main()
+{
+    color = texture(screen, TexCoord);
+}`
+
+const toneMappingFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform float exposure;
+uniform float aces; // > 0.5 uses the ACES filmic curve instead of Reinhard
+
+vec3 acesFilm(vec3 x)
+{
+    return clamp((x * (2.51 * x + 0.03)) / (x * (2.43 * x + 0.59) + 0.14), 0.0, 1.0);
+}
+
+void main()
+{
+    vec4 hdr = texture(screen, TexCoord);
+    vec3 mapped = hdr.rgb * exposure;
+    if (aces > 0.5) {
+        mapped = acesFilm(mapped);
+    } else {
+        mapped = mapped / (mapped + vec3(1.0));
+    }
+    color = vec4(mapped, hdr.a);
+}`
+
+const gammaFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform float gamma;
+
+void main()
+{
+    vec4 linear = texture(screen, TexCoord);
+    color = vec4(pow(linear.rgb, vec3(1.0 / gamma)), linear.a);
+}`
+
+// the LUT is a strip of lutSize slices of lutSize*lutSize texels, blue grows by slice
+const colorGradingFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform sampler2D lut;
+uniform float lutSize;
+uniform float strength;
+
+vec3 lookup(vec3 c)
+{
+    float n = lutSize;
+    float b = c.b * (n - 1.0);
+    float b0 = floor(b);
+    float b1 = min(b0 + 1.0, n - 1.0);
+    vec2 uv = (c.rg * (n - 1.0) + 0.5) / vec2(n * n, n);
+    vec3 c0 = texture(lut, uv + vec2(b0 / n, 0.0)).rgb;
+    vec3 c1 = texture(lut, uv + vec2(b1 / n, 0.0)).rgb;
+    return mix(c0, c1, b - b0);
+}
+
+void main()
+{
+    vec4 src = texture(screen, TexCoord);
+    vec3 graded = lookup(clamp(src.rgb, 0.0, 1.0));
+    color = vec4(mix(src.rgb, graded, strength), src.a);
+}`
+
+const vignetteFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform float radius;
+uniform float softness;
+uniform float strength;
+
+void main()
+{
+    vec4 src = texture(screen, TexCoord);
+    float dist = distance(TexCoord, vec2(0.5));
+    float vignette = smoothstep(radius, radius - softness, dist);
+    color = vec4(src.rgb * mix(1.0, vignette, strength), src.a);
+}`
+
+const fxaaFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform vec2 texelSize;
+uniform float spanMax;
+uniform float reduceMul;
+uniform float reduceMin;
+
+void main()
+{
+    vec3 rgbNW = texture(screen, TexCoord + vec2(-1.0, -1.0) * texelSize).rgb;
+    vec3 rgbNE = texture(screen, TexCoord + vec2(1.0, -1.0) * texelSize).rgb;
+    vec3 rgbSW = texture(screen, TexCoord + vec2(-1.0, 1.0) * texelSize).rgb;
+    vec3 rgbSE = texture(screen, TexCoord + vec2(1.0, 1.0) * texelSize).rgb;
+    vec4 rgbaM = texture(screen, TexCoord);
+
+    vec3 luma = vec3(0.299, 0.587, 0.114);
+    float lumaNW = dot(rgbNW, luma);
+    float lumaNE = dot(rgbNE, luma);
+    float lumaSW = dot(rgbSW, luma);
+    float lumaSE = dot(rgbSE, luma);
+    float lumaM = dot(rgbaM.rgb, luma);
+    float lumaMin = min(lumaM, min(min(lumaNW, lumaNE), min(lumaSW, lumaSE)));
+    float lumaMax = max(lumaM, max(max(lumaNW, lumaNE), max(lumaSW, lumaSE)));
+
+    // blur along the edge, perpendicular to the luma gradient
+    vec2 dir = vec2(-((lumaNW + lumaNE) - (lumaSW + lumaSE)), (lumaNW + lumaSW) - (lumaNE + lumaSE));
+    float dirReduce = max((lumaNW + lumaNE + lumaSW + lumaSE) * 0.25 * reduceMul, reduceMin);
+    float rcpDirMin = 1.0 / (min(abs(dir.x), abs(dir.y)) + dirReduce);
+    dir = clamp(dir * rcpDirMin, vec2(-spanMax), vec2(spanMax)) * texelSize;
+
+    vec3 rgbA = 0.5 * (texture(screen, TexCoord + dir * (1.0 / 3.0 - 0.5)).rgb +
+                       texture(screen, TexCoord + dir * (2.0 / 3.0 - 0.5)).rgb);
+    vec3 rgbB = rgbA * 0.5 + 0.25 * (texture(screen, TexCoord + dir * -0.5).rgb +
+                                     texture(screen, TexCoord + dir * 0.5).rgb);
+    float lumaB = dot(rgbB, luma);
+    if (lumaB < lumaMin || lumaB > lumaMax) {
+        color = vec4(rgbA, rgbaM.a);
+    } else {
+        color = vec4(rgbB, rgbaM.a);
+    }
+}`
+
+const bloomExtractFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform float threshold;
+
+void main()
+{
+    vec3 src = texture(screen, TexCoord).rgb;
+    float brightness = dot(src, vec3(0.2126, 0.7152, 0.0722));
+    // soft knee so pixels right above the threshold do not pop
+    color = vec4(src * max(brightness - threshold, 0.0) / max(brightness, 0.0001), 1.0);
+}`
+
+const bloomBlurFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform vec2 texelSize;
+uniform vec2 direction; // (1, 0) horizontal pass, (0, 1) vertical pass
+
+const float weights[5] = float[](0.227027, 0.1945946, 0.1216216, 0.054054, 0.016216);
+
+void main()
+{
+    vec2 offset = direction * texelSize;
+    vec3 result = texture(screen, TexCoord).rgb * weights[0];
+    for (int i = 1; i < 5; i++) {
+        result += texture(screen, TexCoord + offset * float(i)).rgb * weights[i];
+        result += texture(screen, TexCoord - offset * float(i)).rgb * weights[i];
+    }
+    color = vec4(result, 1.0);
+}`
+
+const bloomCombineFragSrc = `#version 410 core
+in vec2 TexCoord;
+out vec4 color;
+uniform sampler2D screen;
+uniform sampler2D bloom;
+uniform float intensity;
+
+void main()
+{
+    vec4 src = texture(screen, TexCoord);
+    color = vec4(src.rgb + texture(bloom, TexCoord).rgb * intensity, src.a);
+}`
+
+// NewToneMappingEffect maps HDR colors to [0, 1], params: exposure, aces
+func NewToneMappingEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectToneMapping, toneMappingFragSrc, map[string][]float32{
+		"exposure": {1},
+		"aces":     {0},
+	})
+}
+
+// NewGammaEffect encodes linear colors, params: gamma
+func NewGammaEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectGamma, gammaFragSrc, map[string][]float32{
+		"gamma": {2.2},
+	})
+}
+
+// NewColorGradingEffect grades colors through a strip LUT of lutSize slices,
+// params: strength. It stays disabled until lut, or Textures["lut"], is set
+// and Configure rejects enabling it before
+func NewColorGradingEffect(lut *Texture, lutSize int) (*ShaderEffect, error) {
+	effect, err := NewShaderEffect(EffectColorGrading, colorGradingFragSrc, map[string][]float32{
+		"lutSize":  {float32(lutSize)},
+		"strength": {1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	effect.required = []string{"lut"}
+	if lut != nil {
+		effect.Textures["lut"] = lut
+	}
+	return effect, nil
+}
+
+// NewVignetteEffect darkens the screen borders, params: radius, softness, strength
+func NewVignetteEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectVignette, vignetteFragSrc, map[string][]float32{
+		"radius":   {0.75},
+		"softness": {0.45},
+		"strength": {0.5},
+	})
+}
+
+// NewFXAAEffect smooths aliased edges, it expects gamma encoded colors so
+// it should run last, params: spanMax, reduceMul, reduceMin
+func NewFXAAEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectFXAA, fxaaFragSrc, map[string][]float32{
+		"spanMax":   {8},
+		"reduceMul": {1.0 / 8},
+		"reduceMin": {1.0 / 128},
+	})
+}
+
+// BloomEffect makes bright pixels glow: it extracts them into a half size
+// buffer, blurs it a few times and adds it back over the source
+type BloomEffect struct {
+	enabled    bool
+	Iterations int
+
+	extract *ShaderEffect
+	blur    *ShaderEffect
+	combine *ShaderEffect
+	buffers [2]*Framebuffer
+}
+
+// NewBloomEffect params: threshold, intensity, iterations. width and height
+// are the size of the chain, the blur buffers are half of it
+func NewBloomEffect(width, height int) (*BloomEffect, error) {
+	b := BloomEffect{enabled: true, Iterations: 5}
+	var err error
+	b.extract, err = NewShaderEffect(EffectBloom+"-extract", bloomExtractFragSrc, map[string][]float32{
+		"threshold": {1},
+	})
+	if err != nil {
+		b.Delete()
+		return nil, err
+	}
+	b.blur, err = NewShaderEffect(EffectBloom+"-blur", bloomBlurFragSrc, nil)
+	if err != nil {
+		b.Delete()
+		return nil, err
+	}
+	b.combine, err = NewShaderEffect(EffectBloom+"-combine", bloomCombineFragSrc, map[string][]float32{
+		"intensity": {1},
+	})
+	if err != nil {
+		b.Delete()
+		return nil, err
+	}
+
+	for i := range b.buffers {
+		b.buffers[i], err = NewFramebuffer(FramebufferOptions{
+			Width:  (width + 1) / 2,
+			Height: (height + 1) / 2,
+			Colors: []ColorFormat{ColorRGBA16F},
+		})
+		if err != nil {
+			b.Delete()
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+// Resize gives the blur buffers half the new size of the chain, PostChain.Resize calls it
+func (b *BloomEffect) Resize(width, height int) error {
+	for _, fb := range b.buffers {
+		if err := fb.Resize((width+1)/2, (height+1)/2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BloomEffect) Name() string {
+	return EffectBloom
+}
+
+func (b *BloomEffect) Enabled() bool {
+	return b.enabled
+}
+
+func (b *BloomEffect) SetEnabled(enabled bool) {
+	b.enabled = enabled
+}
+
+func (b *BloomEffect) SetParam(name string, values ...float32) {
+	switch name {
+	case "threshold":
+		b.extract.SetParam(name, values...)
+	case "intensity":
+		b.combine.SetParam(name, values...)
+	case "iterations":
+		if len(values) > 0 {
+			b.Iterations = int(values[0])
+		}
+	}
+}
+
+func (b *BloomEffect) Apply(chain *PostChain, src *Texture, dst *Framebuffer) {
+	b.extract.Apply(chain, src, b.buffers[0])
+	for i := 0; i < b.Iterations; i++ {
+		b.blur.SetParam("direction", 1, 0)
+		b.blur.Apply(chain, b.buffers[0].ColorTexture(0), b.buffers[1])
+		b.blur.SetParam("direction", 0, 1)
+		b.blur.Apply(chain, b.buffers[1].ColorTexture(0), b.buffers[0])
+	}
+
+	b.combine.Textures["bloom"] = b.buffers[0].ColorTexture(0)
+	b.combine.Apply(chain, src, dst)
+}
+
+func (b *BloomEffect) Delete() {
+	for _, effect := range []*ShaderEffect{b.extract, b.blur, b.combine} {
+		if effect != nil {
+			effect.Delete()
+		}
+	}
+	for _, fb := range b.buffers {
+		if fb != nil {
+			fb.Delete()
+		}
+	}
+}
+
+// NewDefaultPostChain builds a chain with every built in effect in the usual
+// order: bloom, tone mapping, color grading (off until a LUT is set), vignette, gamma and FXAA
+func NewDefaultPostChain(width, height int) (*PostChain, error) {
+	chain, err := NewPostChain(width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	bloom, err := NewBloomEffect(width, height)
+	if err != nil {
+		chain.Delete()
+		return nil, err
+	}
+	chain.Add(bloom)
+
+	builders := []func() (*ShaderEffect, error){
+		NewToneMappingEffect,
+		func() (*ShaderEffect, error) { return NewColorGradingEffect(nil, 16) },
+		NewVignetteEffect,
+		NewGammaEffect,
+		NewFXAAEffect,
+	}
+	for _, build := range builders {
+		effect, err := build()
+		if err != nil {
+			chain.Delete()
+			return nil, err
+		}
+		chain.Add(effect)
+	}
+	return chain, nil
+}
@@ -0,0 +1,394 @@
+package gfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// one triangle covering the whole screen, no vertex buffers needed
+const fullscreenVertSrc = `#version 410 core
+out vec2 TexCoord;
+
+void main()
+{
+    vec2 pos = vec2((gl_VertexID << 1) & 2, gl_VertexID & 2);
+    TexCoord = pos;
+    gl_Position = vec4(pos * 2.0 - 1.0, 0.0, 1.0);
+}`
+
+// PostEffect is a step of the post processing chain, it reads src and renders into dst
+type PostEffect interface {
+	Name() string
+	Enabled() bool
+	SetEnabled(enabled bool)
+	SetParam(name string, values ...float32)
+	// Apply renders into dst, or into the default framebuffer when dst is nil
+	Apply(chain *PostChain, src *Texture, dst *Framebuffer)
+	Delete()
+}
+
+// ShaderEffect is a post effect made of a single fragment shader, the source is
+// bound to the "screen" sampler and "texelSize" holds the size of one of its pixels
+type ShaderEffect struct {
+	name    string
+	enabled bool
+	program *Program
+
+	screenLoc    int32
+	texelSizeLoc int32
+	locations    map[string]int32
+
+	// Params are uploaded as float, vec2, vec3 or vec4 uniforms by their length
+	Params map[string][]float32
+	// Textures are extra samplers bound from TEXTURE1 up
+	Textures map[string]*Texture
+	// required names the Textures the shader can not run without
+	required []string
+}
+
+func NewShaderEffect(name, fragSrc string, params map[string][]float32) (*ShaderEffect, error) {
+	vertShader, err := NewShader(fullscreenVertSrc, gl.VERTEX_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	fragShader, err := NewShader(fragSrc, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	program, err := NewProgram(vertShader, fragShader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if params == nil {
+		params = map[string][]float32{}
+	}
+
+	return &ShaderEffect{
+		name:         name,
+		enabled:      true,
+		program:      program,
+		screenLoc:    program.GetUniformLocation("screen"),
+		texelSizeLoc: program.GetUniformLocation("texelSize"),
+		locations:    map[string]int32{},
+		Params:       params,
+		Textures:     map[string]*Texture{},
+	}, nil
+}
+
+func (e *ShaderEffect) Name() string {
+	return e.name
+}
+
+// Enabled is false while a required texture is missing, whatever SetEnabled was given
+func (e *ShaderEffect) Enabled() bool {
+	return e.enabled && e.missingTexture() == ""
+}
+
+// missingTexture returns the first required texture that is not set, "" if none
+func (e *ShaderEffect) missingTexture() string {
+	for _, name := range e.required {
+		if e.Textures[name] == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func (e *ShaderEffect) SetEnabled(enabled bool) {
+	e.enabled = enabled
+}
+
+func (e *ShaderEffect) SetParam(name string, values ...float32) {
+	e.Params[name] = values
+}
+
+func (e *ShaderEffect) location(name string) int32 {
+	loc, ok := e.locations[name]
+	if !ok {
+		loc = e.program.GetUniformLocation(name)
+		e.locations[name] = loc
+	}
+	return loc
+}
+
+func (e *ShaderEffect) Apply(chain *PostChain, src *Texture, dst *Framebuffer) {
+	chain.bindTarget(dst)
+	e.program.Use()
+
+	src.Bind(gl.TEXTURE0)
+	src.SetUniform(e.screenLoc)
+	width, height := src.Size()
+	gl.Uniform2f(e.texelSizeLoc, 1/float32(width), 1/float32(height))
+
+	unit := uint32(gl.TEXTURE1)
+	for name, tex := range e.Textures {
+		tex.Bind(unit)
+		tex.SetUniform(e.location(name))
+		unit++
+	}
+
+	for name, values := range e.Params {
+		loc := e.location(name)
+		switch len(values) {
+		case 1:
+			gl.Uniform1fv(loc, 1, &values[0])
+		case 2:
+			gl.Uniform2fv(loc, 1, &values[0])
+		case 3:
+			gl.Uniform3fv(loc, 1, &values[0])
+		case 4:
+			gl.Uniform4fv(loc, 1, &values[0])
+		}
+	}
+
+	chain.drawFullscreen()
+
+	for _, tex := range e.Textures {
+		tex.UnBind()
+	}
+	src.UnBind()
+}
+
+func (e *ShaderEffect) Delete() {
+	e.program.Delete()
+}
+
+// PostChain runs its enabled effects in order, ping-ponging between two
+// offscreen framebuffers and writing the last one to the requested target
+type PostChain struct {
+	width  int
+	height int
+
+	effects  []PostEffect
+	pingPong [2]*Framebuffer
+	copy     *ShaderEffect
+	vao      uint32
+}
+
+func NewPostChain(width, height int) (*PostChain, error) {
+	chain := PostChain{
+		width:  width,
+		height: height,
+	}
+	for i := range chain.pingPong {
+		fb, err := NewFramebuffer(FramebufferOptions{
+			Width:  width,
+			Height: height,
+			Colors: []ColorFormat{ColorRGBA16F},
+		})
+		if err != nil {
+			chain.Delete()
+			return nil, err
+		}
+		chain.pingPong[i] = fb
+	}
+
+	copyEffect, err := NewShaderEffect("copy", copyFragSrc, nil)
+	if err != nil {
+		chain.Delete()
+		return nil, err
+	}
+	chain.copy = copyEffect
+
+	// core profile needs a bound VAO even if the vertex shader uses no attributes
+	gl.GenVertexArrays(1, &chain.vao)
+
+	return &chain, nil
+}
+
+func (c *PostChain) Size() (width, height int) {
+	return c.width, c.height
+}
+
+// Add appends effects at the end of the chain
+func (c *PostChain) Add(effects ...PostEffect) {
+	c.effects = append(c.effects, effects...)
+}
+
+// Remove takes the named effect out of the chain and returns it, nil if missing
+func (c *PostChain) Remove(name string) PostEffect {
+	for i, effect := range c.effects {
+		if effect.Name() == name {
+			c.effects = append(c.effects[:i], c.effects[i+1:]...)
+			return effect
+		}
+	}
+	return nil
+}
+
+// Effect returns the named effect, nil if it is not in the chain
+func (c *PostChain) Effect(name string) PostEffect {
+	for _, effect := range c.effects {
+		if effect.Name() == name {
+			return effect
+		}
+	}
+	return nil
+}
+
+func (c *PostChain) Effects() []PostEffect {
+	return c.effects
+}
+
+func (c *PostChain) SetEnabled(name string, enabled bool) {
+	if effect := c.Effect(name); effect != nil {
+		effect.SetEnabled(enabled)
+	}
+}
+
+// resizer is a post effect with framebuffers of its own that follow the size
+// of the chain, ex: BloomEffect
+type resizer interface {
+	Resize(width, height int) error
+}
+
+// Resize changes the size of the intermediate framebuffers, of the ones of the
+// effects and of the default target
+func (c *PostChain) Resize(width, height int) error {
+	c.width, c.height = width, height
+	for _, fb := range c.pingPong {
+		if err := fb.Resize(width, height); err != nil {
+			return err
+		}
+	}
+	for _, effect := range c.effects {
+		r, ok := effect.(resizer)
+		if !ok {
+			continue
+		}
+		if err := r.Resize(width, height); err != nil {
+			return fmt.Errorf("%s: %v", effect.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Apply runs the enabled effects over src and renders the result into dst,
+// or into the default framebuffer when dst is nil
+func (c *PostChain) Apply(src *Texture, dst *Framebuffer) {
+	depthTest := SetCapability(gl.DEPTH_TEST, false)
+	blend := SetCapability(gl.BLEND, false)
+
+	var enabled []PostEffect
+	for _, effect := range c.effects {
+		if effect.Enabled() {
+			enabled = append(enabled, effect)
+		}
+	}
+	if len(enabled) == 0 {
+		enabled = append(enabled, c.copy)
+	}
+
+	input := src
+	for i, effect := range enabled {
+		target := dst
+		if i < len(enabled)-1 {
+			target = c.pingPong[i%2]
+		}
+		effect.Apply(c, input, target)
+		if target != nil {
+			input = target.ColorTexture(0)
+		}
+	}
+	c.bindTarget(dst)
+
+	SetCapability(gl.DEPTH_TEST, depthTest)
+	SetCapability(gl.BLEND, blend)
+}
+
+func (c *PostChain) bindTarget(dst *Framebuffer) {
+	if dst != nil {
+		dst.Bind()
+		return
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Viewport(0, 0, int32(c.width), int32(c.height))
+}
+
+func (c *PostChain) drawFullscreen() {
+	BindVertexArray(c.vao)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+}
+
+// Delete frees the chain and every effect in it
+func (c *PostChain) Delete() {
+	for _, effect := range c.effects {
+		effect.Delete()
+	}
+	c.effects = nil
+	for _, fb := range c.pingPong {
+		if fb != nil {
+			fb.Delete()
+		}
+	}
+	if c.copy != nil {
+		c.copy.Delete()
+	}
+	if c.vao != 0 {
+		gl.DeleteVertexArrays(1, &c.vao)
+		state.forgetVertexArray(c.vao)
+	}
+}
+
+// PostEffectConfig is the scene configuration of one effect
+type PostEffectConfig struct {
+	Name    string               `json:"name"`
+	Enabled bool                 `json:"enabled"`
+	Params  map[string][]float32 `json:"params,omitempty"`
+}
+
+// PostChainConfig lists the effects of a chain in the order they run
+type PostChainConfig struct {
+	Effects []PostEffectConfig `json:"effects"`
+}
+
+func LoadPostChainConfig(file string) (PostChainConfig, error) {
+	var cfg PostChainConfig
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// Configure reorders the chain as listed in cfg and applies its parameters,
+// effects of the chain that are not listed are disabled and kept at the end
+func (c *PostChain) Configure(cfg PostChainConfig) error {
+	ordered := make([]PostEffect, 0, len(c.effects))
+	listed := map[string]bool{}
+	for _, effectCfg := range cfg.Effects {
+		effect := c.Effect(effectCfg.Name)
+		if effect == nil {
+			return fmt.Errorf("unknown post effect %q", effectCfg.Name)
+		}
+		if listed[effectCfg.Name] {
+			return fmt.Errorf("post effect %q listed twice", effectCfg.Name)
+		}
+		listed[effectCfg.Name] = true
+		if shader, ok := effect.(*ShaderEffect); ok && effectCfg.Enabled {
+			if name := shader.missingTexture(); name != "" {
+				return fmt.Errorf("post effect %q can not be enabled without its %q texture", effectCfg.Name, name)
+			}
+		}
+
+		effect.SetEnabled(effectCfg.Enabled)
+		for name, values := range effectCfg.Params {
+			effect.SetParam(name, values...)
+		}
+		ordered = append(ordered, effect)
+	}
+	for _, effect := range c.effects {
+		if !listed[effect.Name()] {
+			effect.SetEnabled(false)
+			ordered = append(ordered, effect)
+		}
+	}
+	c.effects = ordered
+	return nil
+}
@@ -27,6 +27,7 @@ func (prog *Program) Delete() {
 		shader.Delete()
 	}
 	gl.DeleteProgram(prog.handle)
+	state.forgetProgram(prog.handle)
 }
 
 func (prog *Program) Attach(shaders ...*Shader) {
@@ -37,7 +38,8 @@ func (prog *Program) Attach(shaders ...*Shader) {
 }
 
 func (prog *Program) Use() {
-	gl.UseProgram(prog.handle)
+	UseProgram(prog.handle)
+	checkError("using a program")
 }
 
 func (prog *Program) Link() error {
@@ -125,4 +127,6 @@ func InitGl() {
 	version := gl.GoStr(gl.GetString(gl.VERSION))
 	log.Println("OpenGL version", version)
 
+	// filter across cubemap faces instead of clamping at each edge
+	gl.Enable(gl.TEXTURE_CUBE_MAP_SEAMLESS)
 }
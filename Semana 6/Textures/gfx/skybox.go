@@ -0,0 +1,143 @@
+package gfx
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const skyboxVertSrc = `#version 410 core
+layout (location = 0) in vec3 position;
+
+uniform mat4 view;
+uniform mat4 projection;
+
+out vec3 TexDir;
+
+void main()
+{
+    TexDir = position;
+    vec4 pos = projection * view * vec4(position, 1.0);
+    // z = w puts the sky at depth 1.0, behind everything else
+    gl_Position = pos.xyww;
+}`
+
+const skyboxFragSrc = `#version 410 core
+in vec3 TexDir;
+out vec4 color;
+
+uniform samplerCube skybox;
+uniform vec3 tint;
+
+void main()
+{
+    color = texture(skybox, TexDir) * vec4(tint, 1.0);
+}`
+
+// Skybox draws a cubemap at infinite distance using only the camera rotation
+type Skybox struct {
+	program *Program
+	cubemap *Texture
+	vao     uint32
+	vbo     uint32
+
+	viewLoc       int32
+	projectionLoc int32
+	samplerLoc    int32
+	tintLoc       int32
+
+	Tint mgl32.Vec3
+}
+
+func NewSkybox(cubemap *Texture) (*Skybox, error) {
+	vertShader, err := NewShader(skyboxVertSrc, gl.VERTEX_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	fragShader, err := NewShader(skyboxFragSrc, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	program, err := NewProgram(vertShader, fragShader)
+	if err != nil {
+		return nil, err
+	}
+
+	skybox := Skybox{
+		program:       program,
+		cubemap:       cubemap,
+		viewLoc:       program.GetUniformLocation("view"),
+		projectionLoc: program.GetUniformLocation("projection"),
+		samplerLoc:    program.GetUniformLocation("skybox"),
+		tintLoc:       program.GetUniformLocation("tint"),
+		Tint:          mgl32.Vec3{1, 1, 1},
+	}
+
+	vertices := skyboxVertices()
+	gl.GenVertexArrays(1, &skybox.vao)
+	BindVertexArray(skybox.vao)
+	gl.GenBuffers(1, &skybox.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, skybox.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STATIC_DRAW)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	BindVertexArray(0)
+
+	return &skybox, nil
+}
+
+// Cubemap returns the sky texture so it can also be sampled for reflections
+func (s *Skybox) Cubemap() *Texture {
+	return s.cubemap
+}
+
+// Draw renders the sky, call it after the opaque geometry so hidden pixels are skipped
+func (s *Skybox) Draw(view, projection mgl32.Mat4) {
+	// drop the translation so the sky never moves with the camera
+	rotation := view.Mat3().Mat4()
+
+	depthFunc := SetDepthFunc(gl.LEQUAL)
+	// the camera is inside the cube, so its faces must not be culled
+	cullFace := SetCapability(gl.CULL_FACE, false)
+
+	s.program.Use()
+	gl.UniformMatrix4fv(s.viewLoc, 1, false, &rotation[0])
+	gl.UniformMatrix4fv(s.projectionLoc, 1, false, &projection[0])
+	gl.Uniform3fv(s.tintLoc, 1, &s.Tint[0])
+	s.cubemap.Bind(gl.TEXTURE0)
+	s.cubemap.SetUniform(s.samplerLoc)
+
+	BindVertexArray(s.vao)
+	gl.DrawArrays(gl.TRIANGLES, 0, 36)
+
+	SetDepthFunc(depthFunc)
+	SetCapability(gl.CULL_FACE, cullFace)
+}
+
+// Delete frees the GL objects of the skybox, the cubemap is left to its owner
+func (s *Skybox) Delete() {
+	gl.DeleteBuffers(1, &s.vbo)
+	gl.DeleteVertexArrays(1, &s.vao)
+	state.forgetVertexArray(s.vao)
+	s.program.Delete()
+}
+
+func skyboxVertices() []float32 {
+	corners := [8][3]float32{
+		{-1, -1, -1}, {1, -1, -1}, {1, 1, -1}, {-1, 1, -1},
+		{-1, -1, 1}, {1, -1, 1}, {1, 1, 1}, {-1, 1, 1},
+	}
+	indices := []int{
+		0, 2, 1, 0, 3, 2, // back
+		4, 5, 6, 4, 6, 7, // front
+		0, 4, 7, 0, 7, 3, // left
+		1, 2, 6, 1, 6, 5, // right
+		3, 7, 6, 3, 6, 2, // top
+		0, 1, 5, 0, 5, 4, // bottom
+	}
+	vertices := make([]float32, 0, len(indices)*3)
+	for _, i := range indices {
+		vertices = append(vertices, corners[i][0], corners[i][1], corners[i][2])
+	}
+	return vertices
+}
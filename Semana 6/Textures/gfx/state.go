@@ -0,0 +1,201 @@
+package gfx
+
+import "github.com/go-gl/gl/v4.1-core/gl"
+
+// unknown marks the state not set through this package since the last
+// InvalidateState, the next call sets it whatever it is
+const unknown = ^uint32(0)
+
+type textureSlot struct {
+	unit   uint32 // ex: gl.TEXTURE0
+	target uint32 // ex: gl.TEXTURE_2D
+}
+
+// StateStats counts the state changes asked to the cache, Skipped ones did
+// not reach GL because the state was already set
+type StateStats struct {
+	Calls   int
+	Skipped int
+}
+
+// glState is the GL state last set through this package. Program, VAO and
+// texture binds, the capabilities and the blend, depth and cull functions go
+// through it so the redundant calls are skipped
+type glState struct {
+	program    uint32
+	vao        uint32
+	activeUnit uint32
+	textures   map[textureSlot]uint32
+	caps       map[uint32]bool
+	blendSrc   uint32
+	blendDst   uint32
+	depthFunc  uint32
+	depthMask  uint32 // gl.TRUE or gl.FALSE
+	cullFace   uint32
+	stats      StateStats
+}
+
+var state = newGlState()
+
+func newGlState() *glState {
+	var s glState
+	s.invalidate()
+	return &s
+}
+
+func (s *glState) invalidate() {
+	s.program = unknown
+	s.vao = unknown
+	s.activeUnit = unknown
+	s.textures = make(map[textureSlot]uint32)
+	s.caps = make(map[uint32]bool)
+	s.blendSrc, s.blendDst = unknown, unknown
+	s.depthFunc = unknown
+	s.depthMask = unknown
+	s.cullFace = unknown
+}
+
+// set counts a state change and tells if it has to reach GL
+func (s *glState) set(current *uint32, value uint32) bool {
+	s.stats.Calls++
+	if *current == value {
+		s.stats.Skipped++
+		return false
+	}
+	*current = value
+	return true
+}
+
+// InvalidateState forgets the cached state, call it after code outside this
+// package, ex: ge or plain gl calls, changed any of the state it tracks
+func InvalidateState() {
+	state.invalidate()
+}
+
+// GetStateStats returns the state changes since the last ResetStateStats
+func GetStateStats() StateStats {
+	return state.stats
+}
+
+func ResetStateStats() {
+	state.stats = StateStats{}
+}
+
+func UseProgram(handle uint32) {
+	if state.set(&state.program, handle) {
+		gl.UseProgram(handle)
+	}
+}
+
+func BindVertexArray(handle uint32) {
+	if state.set(&state.vao, handle) {
+		gl.BindVertexArray(handle)
+	}
+}
+
+// ActiveTexture makes unit, ex: gl.TEXTURE1, the one the texture binds go to
+func ActiveTexture(unit uint32) {
+	if state.set(&state.activeUnit, unit) {
+		gl.ActiveTexture(unit)
+	}
+}
+
+// BindTexture binds handle to target on unit, unit is left active
+func BindTexture(unit, target, handle uint32) {
+	ActiveTexture(unit)
+	slot := textureSlot{unit, target}
+	current, ok := state.textures[slot]
+	if !ok {
+		current = unknown
+	}
+	if state.set(&current, handle) {
+		gl.BindTexture(target, handle)
+		state.textures[slot] = handle
+	}
+}
+
+// SetCapability enables or disables capability, ex: gl.BLEND, and returns
+// whether it was enabled before, asking GL only when it is not known
+func SetCapability(capability uint32, enabled bool) (was bool) {
+	was, ok := state.caps[capability]
+	if !ok {
+		was = gl.IsEnabled(capability)
+	}
+	state.stats.Calls++
+	if ok && was == enabled {
+		state.stats.Skipped++
+		return was
+	}
+	if enabled {
+		gl.Enable(capability)
+	} else {
+		gl.Disable(capability)
+	}
+	state.caps[capability] = enabled
+	return was
+}
+
+func SetBlendFunc(src, dst uint32) {
+	state.stats.Calls++
+	if state.blendSrc == src && state.blendDst == dst {
+		state.stats.Skipped++
+		return
+	}
+	gl.BlendFunc(src, dst)
+	state.blendSrc, state.blendDst = src, dst
+}
+
+// SetDepthFunc sets the depth comparison, ex: gl.LESS, and returns the one
+// before, asking GL only when it is not known
+func SetDepthFunc(function uint32) (previous uint32) {
+	previous = state.depthFunc
+	if previous == unknown {
+		var current int32
+		gl.GetIntegerv(gl.DEPTH_FUNC, &current)
+		previous = uint32(current)
+	}
+	if state.set(&state.depthFunc, function) {
+		gl.DepthFunc(function)
+	}
+	return previous
+}
+
+func SetDepthMask(write bool) {
+	mask := uint32(gl.FALSE)
+	if write {
+		mask = gl.TRUE
+	}
+	if state.set(&state.depthMask, mask) {
+		gl.DepthMask(write)
+	}
+}
+
+// SetCullFace sets the faces culled when gl.CULL_FACE is enabled, ex: gl.BACK
+func SetCullFace(face uint32) {
+	if state.set(&state.cullFace, face) {
+		gl.CullFace(face)
+	}
+}
+
+// forgetProgram, forgetVertexArray and forgetTexture drop a deleted object,
+// GL unbinds it and a new one may get its handle
+func (s *glState) forgetProgram(handle uint32) {
+	if s.program == handle {
+		// a deleted program stays in use until another one is
+		s.program = unknown
+	}
+}
+
+func (s *glState) forgetVertexArray(handle uint32) {
+	if s.vao == handle {
+		s.vao = 0
+	}
+}
+
+func (s *glState) forgetTexture(handle uint32) {
+	for slot, bound := range s.textures {
+		if bound == handle {
+			s.textures[slot] = 0
+		}
+	}
+}
@@ -15,32 +15,102 @@ type Texture struct {
 	handle  uint32
 	target  uint32 // same target as gl.BindTexture(<this param>, ...)
 	texUnit uint32 // Texture unit that is currently bound to ex: gl.TEXTURE0
+	width   int32
+	height  int32
 }
 
 var errUnsupportedStride = errors.New("unsupported stride, only 32-bit colors supported")
 
 var errTextureNotBound = errors.New("texture not bound")
 
-func NewTextureFromFile(file string, wrapR, wrapS int32) (*Texture, error) {
-	imgFile, err := os.Open(file)
-	if err != nil {
-		return nil, err
+// anisotropic filtering is core since 4.6, the extension uses the same enums
+const (
+	textureMaxAnisotropy    = 0x84FE
+	maxTextureMaxAnisotropy = 0x84FF
+)
+
+// anisotropy is whether the context filters anisotropically, 0 until asked
+var anisotropy int
+
+// anisotropySupported looks for the extension once, querying the enums
+// without it is a GL_INVALID_ENUM error
+func anisotropySupported() bool {
+	if anisotropy == 0 {
+		anisotropy = -1
+		if hasExtension("GL_ARB_texture_filter_anisotropic") || hasExtension("GL_EXT_texture_filter_anisotropic") {
+			anisotropy = 1
+		}
 	}
-	defer imgFile.Close()
+	return anisotropy > 0
+}
 
-	// Decode detexts the type of image as long as its image/<type> is imported
-	img, _, err := image.Decode(imgFile)
+// TextureOptions holds the sampling and storage settings of a texture
+type TextureOptions struct {
+	MinFilter int32 // ex: gl.LINEAR_MIPMAP_LINEAR
+	MagFilter int32 // ex: gl.LINEAR
+
+	// Mipmaps generates the mipmap chain after uploading the image
+	Mipmaps bool
+	// Anisotropy is the max anisotropic samples, values <= 1 disable it
+	Anisotropy float32
+
+	WrapS int32
+	WrapT int32
+	WrapR int32
+	// BorderColor is used by the CLAMP_TO_BORDER wrap mode
+	BorderColor [4]float32
+
+	// Linear marks the data as linear (normal maps, height maps, etc.)
+	// instead of sRGB encoded color
+	Linear bool
+}
+
+// DefaultTextureOptions returns trilinear filtered, mipmapped, repeating sRGB options
+func DefaultTextureOptions() TextureOptions {
+	return TextureOptions{
+		MinFilter: gl.LINEAR_MIPMAP_LINEAR,
+		MagFilter: gl.LINEAR,
+		Mipmaps:   true,
+		WrapS:     gl.REPEAT,
+		WrapT:     gl.REPEAT,
+		WrapR:     gl.REPEAT,
+	}
+}
+
+// wrapOptions keeps the old wrapR, wrapS signature on top of the defaults.
+// wrapR is also used for T, the second axis of a 2D texture that the old
+// signature left at REPEAT, so a clamped texture is now clamped on both axes
+func wrapOptions(wrapR, wrapS int32) TextureOptions {
+	opts := DefaultTextureOptions()
+	opts.WrapR = wrapR
+	opts.WrapS = wrapS
+	opts.WrapT = wrapR
+	return opts
+}
+
+// NewTextureFromFile loads an sRGB color texture with DefaultTextureOptions,
+// mipmapped and trilinear filtered, wrapped with wrapR on R and T and wrapS on
+// S. Use NewTextureFromFileWithOptions for linear data like height maps
+func NewTextureFromFile(file string, wrapR, wrapS int32) (*Texture, error) {
+	return NewTextureFromFileWithOptions(file, wrapOptions(wrapR, wrapS))
+}
+
+func NewTextureFromFileWithOptions(file string, opts TextureOptions) (*Texture, error) {
+	img, err := loadImageFile(file)
 	if err != nil {
 		return nil, err
 	}
-	return NewTexture(img, wrapR, wrapS)
+	return NewTextureWithOptions(img, opts)
 }
 
 func NewTexture(img image.Image, wrapR, wrapS int32) (*Texture, error) {
-	rgba := image.NewRGBA(img.Bounds())
-	draw.Draw(rgba, rgba.Bounds(), img, image.Pt(0, 0), draw.Src)
-	if rgba.Stride != rgba.Rect.Size().X*4 { // TODO-cs: why?
-		return nil, errUnsupportedStride
+	return NewTextureWithOptions(img, wrapOptions(wrapR, wrapS))
+}
+
+func NewTextureWithOptions(img image.Image, opts TextureOptions) (*Texture, error) {
+	rgba, err := toRGBA(img)
+	if err != nil {
+		return nil, err
 	}
 
 	var handle uint32
@@ -48,6 +118,9 @@ func NewTexture(img image.Image, wrapR, wrapS int32) (*Texture, error) {
 
 	target := uint32(gl.TEXTURE_2D)
 	internalFmt := int32(gl.SRGB_ALPHA)
+	if opts.Linear {
+		internalFmt = gl.RGBA8
+	}
 	format := uint32(gl.RGBA)
 	width := int32(rgba.Rect.Size().X)
 	height := int32(rgba.Rect.Size().Y)
@@ -57,34 +130,90 @@ func NewTexture(img image.Image, wrapR, wrapS int32) (*Texture, error) {
 	texture := Texture{
 		handle: handle,
 		target: target,
+		width:  width,
+		height: height,
 	}
 
 	texture.Bind(gl.TEXTURE0)
 	defer texture.UnBind()
 
 	// set the texture wrapping/filtering options (applies to current bound texture obj)
-	// TODO-cs
-	gl.TexParameteri(texture.target, gl.TEXTURE_WRAP_R, wrapR)
-	gl.TexParameteri(texture.target, gl.TEXTURE_WRAP_S, wrapS)
-	gl.TexParameteri(texture.target, gl.TEXTURE_MIN_FILTER, gl.LINEAR) // minification filter
-	gl.TexParameteri(texture.target, gl.TEXTURE_MAG_FILTER, gl.LINEAR) // magnification filter
+	texture.setParameters(opts)
 
 	gl.TexImage2D(target, 0, internalFmt, width, height, 0, format, pixType, dataPtr)
 
-	gl.GenerateMipmap(texture.handle)
+	if opts.Mipmaps {
+		gl.GenerateMipmap(texture.target)
+	}
+	checkError("creating a texture")
 
 	return &texture, nil
 }
 
+// setParameters applies the filtering and wrapping options to the bound texture
+func (tex *Texture) setParameters(opts TextureOptions) {
+	minFilter := opts.MinFilter
+	if minFilter == 0 {
+		minFilter = gl.LINEAR
+	}
+	if !opts.Mipmaps {
+		// a mipmap min filter without mipmaps leaves the texture incomplete
+		switch minFilter {
+		case gl.NEAREST_MIPMAP_NEAREST, gl.NEAREST_MIPMAP_LINEAR:
+			minFilter = gl.NEAREST
+		case gl.LINEAR_MIPMAP_NEAREST, gl.LINEAR_MIPMAP_LINEAR:
+			minFilter = gl.LINEAR
+		}
+	}
+	magFilter := opts.MagFilter
+	if magFilter == 0 {
+		magFilter = gl.LINEAR
+	}
+
+	gl.TexParameteri(tex.target, gl.TEXTURE_WRAP_S, wrapOrDefault(opts.WrapS))
+	gl.TexParameteri(tex.target, gl.TEXTURE_WRAP_T, wrapOrDefault(opts.WrapT))
+	gl.TexParameteri(tex.target, gl.TEXTURE_WRAP_R, wrapOrDefault(opts.WrapR))
+	gl.TexParameterfv(tex.target, gl.TEXTURE_BORDER_COLOR, &opts.BorderColor[0])
+	gl.TexParameteri(tex.target, gl.TEXTURE_MIN_FILTER, minFilter) // minification filter
+	gl.TexParameteri(tex.target, gl.TEXTURE_MAG_FILTER, magFilter) // magnification filter
+
+	if opts.Anisotropy > 1 && anisotropySupported() {
+		var maxAnisotropy float32
+		gl.GetFloatv(maxTextureMaxAnisotropy, &maxAnisotropy)
+		if maxAnisotropy > 1 {
+			if opts.Anisotropy < maxAnisotropy {
+				maxAnisotropy = opts.Anisotropy
+			}
+			gl.TexParameterf(tex.target, textureMaxAnisotropy, maxAnisotropy)
+		}
+	}
+}
+
+func wrapOrDefault(wrap int32) int32 {
+	if wrap == 0 {
+		return gl.REPEAT
+	}
+	return wrap
+}
+
 func (tex *Texture) Bind(texUnit uint32) {
-	gl.ActiveTexture(texUnit)
-	gl.BindTexture(tex.target, tex.handle)
+	BindTexture(texUnit, tex.target, tex.handle)
 	tex.texUnit = texUnit
+	checkError("binding a texture")
 }
 
+// UnBind unbinds the texture from the unit it was bound to, or from the
+// active one when it was not
 func (tex *Texture) UnBind() {
+	unit := tex.texUnit
 	tex.texUnit = 0
-	gl.BindTexture(tex.target, 0)
+	if unit == 0 {
+		unit = state.activeUnit
+	}
+	if unit == unknown {
+		unit = gl.TEXTURE0
+	}
+	BindTexture(unit, tex.target, 0)
 }
 
 func (tex *Texture) SetUniform(uniformLoc int32) error {
@@ -95,6 +224,25 @@ func (tex *Texture) SetUniform(uniformLoc int32) error {
 	return nil
 }
 
+func (tex *Texture) Size() (width, height int) {
+	return int(tex.width), int(tex.height)
+}
+
+func (tex *Texture) Delete() {
+	gl.DeleteTextures(1, &tex.handle)
+	state.forgetTexture(tex.handle)
+}
+
+// toRGBA copies any image into a tightly packed RGBA image with origin at 0,0
+func toRGBA(img image.Image) (*image.RGBA, error) {
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	if rgba.Stride != rgba.Rect.Size().X*4 { // TODO-cs: why?
+		return nil, errUnsupportedStride
+	}
+	return rgba, nil
+}
+
 func loadImageFile(file string) (image.Image, error) {
 	infile, err := os.Open(file)
 	if err != nil {
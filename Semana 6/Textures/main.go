@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"runtime"
 
@@ -52,8 +53,8 @@ func programLoop(window *win.Window) error {
 	defer program.Delete()
 
 	// Ensure that triangles that are "behind" others do not draw over top of them
-	gl.Enable(gl.DEPTH_TEST)
-	gl.DepthFunc(gl.LESS)
+	gfx.SetCapability(gl.DEPTH_TEST, true)
+	gfx.SetDepthFunc(gl.LESS)
 	program.Use()
 
 	// set texture0 to uniform0 in the fragment shader
@@ -69,6 +70,8 @@ func programLoop(window *win.Window) error {
 	cameraUniformLocation := program.GetUniformLocation("camera")
 	projectUniformLocation := program.GetUniformLocation("project")
 	textureUniformLocation := program.GetUniformLocation("texture")
+	heightMapUniformLocation := program.GetUniformLocation("heightMap")
+	heightScaleUniformLocation := program.GetUniformLocation("heightScale")
 
 	// creates camara
 	camera := mgl32.LookAtV(mgl32.Vec3{3.5, 2.5, 5}, mgl32.Vec3{0, 1, 0}, mgl32.Vec3{0, 1, 0})
@@ -111,33 +114,85 @@ func programLoop(window *win.Window) error {
 	if err != nil {
 		panic(err.Error())
 	}
+	// the drifts of the plane are heights, not colors, so no sRGB decoding
+	driftOptions := gfx.DefaultTextureOptions()
+	driftOptions.WrapS, driftOptions.WrapT = gl.CLAMP_TO_EDGE, gl.CLAMP_TO_EDGE
+	driftOptions.Linear = true
+	snowDrift, err := gfx.NewTextureFromFileWithOptions("images/snowdrift.png", driftOptions)
+	if err != nil {
+		panic(err.Error())
+	}
 
 	// Get primitive vertices and create VAOs
+	// the vertex arrays own their buffers, all of them are freed on return
+	var vertexArrays []*gfx.VertexArray
+	defer func() {
+		for _, va := range vertexArrays {
+			va.Delete()
+		}
+	}()
+	// each vertex array is checked against the program, the ones without
+	// texture coordinates are not drawn textured
+	layouts := make(map[uint32]gfx.VertexLayout)
+	var layoutErr error
+	createVAO := func(vertices []mgl32.Vec3, textureCoord []mgl32.Vec2) uint32 {
+		va := gfx.NewMeshVertexArray(vertices, textureCoord)
+		vertexArrays = append(vertexArrays, va)
+		var unused []string
+		if len(textureCoord) == 0 {
+			unused = append(unused, "texCoord")
+		}
+		if err := program.CheckVertexArray(va, unused...); err != nil && layoutErr == nil {
+			layoutErr = err
+		}
+		layouts[va.Handle()] = va.Layout()
+		return va.Handle()
+	}
 	var theVoid []mgl32.Vec2
 	cubeVertices := ge.GetCubicHexahedronVertices3(1.5, 1, 1.5)
 	cubeTextureCoords := ge.GetCubicHexahedronTextureCoords(1, 1, 1)
-	cubeVAO := ge.CreateVAO(cubeVertices, cubeTextureCoords)
+	cubeVAO := createVAO(cubeVertices, cubeTextureCoords)
 
 	sideVertices, topVertices, bottomVertices := ge.GetCylinderVertices3(1, 0.1, 0.1, 5)
-	sideVAO, topVAO, bottomVAO := ge.CreateVAO(sideVertices, theVoid), ge.CreateVAO(topVertices, theVoid), ge.CreateVAO(bottomVertices, theVoid)
+	sideVAO, topVAO, bottomVAO := createVAO(sideVertices, theVoid), createVAO(topVertices, theVoid), createVAO(bottomVertices, theVoid)
 
 	planeVertices := ge.GetPlaneVertices3(12, 12, 1)
 	planeTextureCoords := ge.GetPlaneTextureCoords(12, 12, 1)
-	planeVAO := ge.CreateVAO(planeVertices, planeTextureCoords)
+	planeVAO := createVAO(planeVertices, planeTextureCoords)
 
 	sphereVertices, sphereTop, sphereBottom := ge.GetSphereVertices3(0.3, 16)
-	sphereVao, sphereTopVao, sphereBotVao := ge.CreateVAO(sphereVertices, theVoid), ge.CreateVAO(sphereTop, theVoid), ge.CreateVAO(sphereBottom, theVoid)
+	sphereVao, sphereTopVao, sphereBotVao := createVAO(sphereVertices, theVoid), createVAO(sphereTop, theVoid), createVAO(sphereBottom, theVoid)
 
 	noseVertices := ge.GetCircleVertices3(0.05, 8)
 	noseVertices[0] = mgl32.Vec3{0, 0.2, 0}
-	noseVao := ge.CreateVAO(noseVertices, theVoid)
+	noseVao := createVAO(noseVertices, theVoid)
 
 	snowCarpetVertices := ge.GetCubicHexahedronVertices3(1.5, 0.1, 1.5)
 	snowCarpetTextureCoords := ge.GetCubicHexahedronTextureCoords(1, 1, 1)
-	snowCarpetVAO := ge.CreateVAO(snowCarpetVertices, snowCarpetTextureCoords)
+	snowCarpetVAO := createVAO(snowCarpetVertices, snowCarpetTextureCoords)
 
 	sideVerticesHat, topVerticesHat, bottomVerticesHat := ge.GetCylinderVertices3(0.5, 0.5, 0.5, 5)
-	sideHatVAO, topHatVAO, bottomHatVAO := ge.CreateVAO(sideVerticesHat, theVoid), ge.CreateVAO(topVerticesHat, theVoid), ge.CreateVAO(bottomVerticesHat, theVoid)
+	sideHatVAO, topHatVAO, bottomHatVAO := createVAO(sideVerticesHat, theVoid), createVAO(topVerticesHat, theVoid), createVAO(bottomVerticesHat, theVoid)
+
+	if layoutErr != nil {
+		return layoutErr
+	}
+
+	// trees are drawn instanced, they share the meshes above
+	instances, err := gfx.NewInstanceRenderer()
+	if err != nil {
+		return err
+	}
+	defer instances.Delete()
+	sideMesh := gfx.Mesh{VAO: sideVAO, Layout: layouts[sideVAO], Mode: gl.TRIANGLE_STRIP, Count: int32(len(sideVertices))}
+	topMesh := gfx.Mesh{VAO: topVAO, Layout: layouts[topVAO], Mode: gl.TRIANGLE_FAN, Count: int32(len(topVertices))}
+	bottomMesh := gfx.Mesh{VAO: bottomVAO, Layout: layouts[bottomVAO], Mode: gl.TRIANGLE_FAN, Count: int32(len(bottomVertices))}
+	cubeMesh := gfx.Mesh{VAO: cubeVAO, Layout: layouts[cubeVAO], Mode: gl.TRIANGLE_STRIP, Count: int32(len(cubeVertices))}
+	snowCarpetMesh := gfx.Mesh{VAO: snowCarpetVAO, Layout: layouts[snowCarpetVAO], Mode: gl.TRIANGLE_STRIP, Count: int32(len(snowCarpetVertices))}
+	logMaterial := gfx.Material{Color: mgl32.Vec3{0.4, 0.2, 0}}
+	leavesMaterial := gfx.Material{Color: mgl32.Vec3{1, 1, 1}, Texture: leavesTexture}
+	snowMaterial := gfx.Material{Color: mgl32.Vec3{1, 1, 1}, Texture: snowTexture2}
+	var logTransforms, leavesTransforms, snowTransforms []mgl32.Mat4
 
 	for !window.ShouldClose() {
 		window.StartFrame()
@@ -165,194 +220,150 @@ func programLoop(window *win.Window) error {
 		}
 
 		// You shall draw here
+		// trees, each part of every tree in one instanced draw
+		scale1 := 1 - math32.Abs(math32.Sin(float32(time)))*0.04
+		scale2 := 1 - math32.Abs(math32.Cos(float32(time)))*0.04
+		logTransforms = logTransforms[:0]
+		leavesTransforms = leavesTransforms[:0]
+		snowTransforms = snowTransforms[:0]
 		for _, pos := range treePositions {
 			treeTranslate := mgl32.Translate3D(pos.X(), pos.Y(), pos.Z())
-			scale1 := 1 - math32.Abs(math32.Sin(float32(time)))*0.04
-			scale2 := 1 - math32.Abs(math32.Cos(float32(time)))*0.04
 			//log
-			gl.Uniform3f(colorUniformLocation, 0.4, 0.2, 0)
-			gl.BindVertexArray(sideVAO)
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &treeTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(sideVertices)))
-
-			gl.BindVertexArray(topVAO)
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &treeTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(topVertices)))
-
-			gl.BindVertexArray(bottomVAO)
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &treeTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(bottomVertices)))
-
-			//leaves
-
-			//big leavesTexture
-			leavesTexture.Bind(gl.TEXTURE0)
-			leavesTexture.SetUniform(textureUniformLocation)
-
-			gl.Uniform3f(colorUniformLocation, 1, 1, 1)
-			gl.BindVertexArray(cubeVAO)
+			logTransforms = append(logTransforms, treeTranslate)
+			//big leaves and snow
 			treeTranslate = treeTranslate.Mul4(mgl32.Translate3D(0, 1.*scale1, 0))
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &treeTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(cubeVertices)))
-			gl.BindVertexArray(0)
-			leavesTexture.UnBind()
-			// snow
-			snowTexture2.Bind(gl.TEXTURE0)
-			snowTexture2.SetUniform(textureUniformLocation)
-
-			gl.Uniform3f(colorUniformLocation, 1, 1, 1)
-			gl.BindVertexArray(snowCarpetVAO)
-			snowTranslate := treeTranslate.Mul4(mgl32.Translate3D(0, 1, 0))
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(snowCarpetVertices)))
-			gl.BindVertexArray(0)
-			snowTexture2.UnBind()
+			leavesTransforms = append(leavesTransforms, treeTranslate)
+			snowTransforms = append(snowTransforms, treeTranslate.Mul4(mgl32.Translate3D(0, 1, 0)))
 			//med
-			leavesTexture.Bind(gl.TEXTURE0)
-			leavesTexture.SetUniform(textureUniformLocation)
-			gl.Uniform3f(colorUniformLocation, 1, 1, 1)
-			gl.BindVertexArray(cubeVAO)
 			treeTranslate = treeTranslate.Mul4(mgl32.Scale3D(0.75, 0.75, 0.75)).Mul4(mgl32.Translate3D(0, 0.75*scale2, 0))
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &treeTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(cubeVertices)))
-			gl.BindVertexArray(0)
-			leavesTexture.UnBind()
-			// snow med
-			snowTexture2.Bind(gl.TEXTURE0)
-			snowTexture2.SetUniform(textureUniformLocation)
-			gl.Uniform3f(colorUniformLocation, 1, 1, 1)
-			gl.BindVertexArray(snowCarpetVAO)
-			snowTranslate = treeTranslate.Mul4(mgl32.Translate3D(0, 1, 0))
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(snowCarpetVertices)))
-			gl.BindVertexArray(0)
-			snowTexture2.UnBind()
-
+			leavesTransforms = append(leavesTransforms, treeTranslate)
+			snowTransforms = append(snowTransforms, treeTranslate.Mul4(mgl32.Translate3D(0, 1, 0)))
 			//smol
-			leavesTexture.Bind(gl.TEXTURE0)
-			leavesTexture.SetUniform(textureUniformLocation)
-
-			gl.Uniform3f(colorUniformLocation, 1, 1, 1)
-			gl.BindVertexArray(cubeVAO)
 			treeTranslate = treeTranslate.Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.Translate3D(0, 1.7*scale1, 0))
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &treeTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(cubeVertices)))
-			gl.BindVertexArray(0)
-			leavesTexture.UnBind()
-			// snow smol
-			snowTexture2.Bind(gl.TEXTURE0)
-			snowTexture2.SetUniform(textureUniformLocation)
-
-			gl.Uniform3f(colorUniformLocation, 1, 1, 1)
-			gl.BindVertexArray(snowCarpetVAO)
-			snowTranslate = treeTranslate.Mul4(mgl32.Translate3D(0, 1, 0))
-			gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowTranslate[0])
-			gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(snowCarpetVertices)))
-			gl.BindVertexArray(0)
-			snowTexture2.UnBind()
-
+			leavesTransforms = append(leavesTransforms, treeTranslate)
+			snowTransforms = append(snowTransforms, treeTranslate.Mul4(mgl32.Translate3D(0, 1, 0)))
 		}
+		instances.Begin(camera, projectTransform)
+		instances.DrawInstances(&sideMesh, &logMaterial, logTransforms)
+		instances.DrawInstances(&topMesh, &logMaterial, logTransforms)
+		instances.DrawInstances(&bottomMesh, &logMaterial, logTransforms)
+		instances.DrawInstances(&cubeMesh, &leavesMaterial, leavesTransforms)
+		instances.DrawInstances(&snowCarpetMesh, &snowMaterial, snowTransforms)
+		program.Use()
+		// basic.frag tells the untextured draws by an empty unit 0, the
+		// textures of the draws before are left bound
+		gfx.BindTexture(gl.TEXTURE0, gl.TEXTURE_2D, 0)
 
 		snowmanTranslate := snowManPathModel
 		gl.Uniform3f(colorUniformLocation, 1, 1, 1)
 		// fist sphere
 
-		gl.BindVertexArray(sphereTopVao)
+		gfx.BindVertexArray(sphereTopVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(sphereTop)))
 
-		gl.BindVertexArray(sphereVao)
+		gfx.BindVertexArray(sphereVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(sphereVertices)))
 
-		gl.BindVertexArray(sphereBotVao)
+		gfx.BindVertexArray(sphereBotVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(sphereBottom)))
-		gl.BindVertexArray(0)
 		//secodn sphere
 
 		snowmanTranslate = snowmanTranslate.Mul4(mgl32.Scale3D(0.75, 0.75, 0.75)).Mul4(mgl32.Translate3D(0, 0.6, 0))
-		gl.BindVertexArray(sphereTopVao)
+		gfx.BindVertexArray(sphereTopVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(sphereTop)))
 
-		gl.BindVertexArray(sphereVao)
+		gfx.BindVertexArray(sphereVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(sphereVertices)))
 
-		gl.BindVertexArray(sphereBotVao)
+		gfx.BindVertexArray(sphereBotVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(sphereBottom)))
-		gl.BindVertexArray(0)
 
 		// head
 
 		snowmanTranslate = snowmanTranslate.Mul4(mgl32.Scale3D(0.75, 0.75, 0.75)).Mul4(mgl32.Translate3D(0, 0.65, 0))
-		gl.BindVertexArray(sphereTopVao)
+		gfx.BindVertexArray(sphereTopVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(sphereTop)))
 
-		gl.BindVertexArray(sphereVao)
+		gfx.BindVertexArray(sphereVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(sphereVertices)))
 
-		gl.BindVertexArray(sphereBotVao)
+		gfx.BindVertexArray(sphereBotVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(sphereBottom)))
-		gl.BindVertexArray(0)
 
 		// nose
 		snowmanNoseTranslate := snowmanTranslate.Mul4(mgl32.Translate3D(0, 0.3, 0.25)).Mul4(mgl32.HomogRotate3DX(mgl32.DegToRad(90)))
 		gl.Uniform3f(colorUniformLocation, 1, 0.541, 0.380)
-		gl.BindVertexArray(noseVao)
+		gfx.BindVertexArray(noseVao)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanNoseTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(noseVertices)))
-		gl.BindVertexArray(0)
 
 		snowmanHatTranslate := snowmanTranslate.Mul4(mgl32.Translate3D(0, 0.5, 0))
 		gl.Uniform3f(colorUniformLocation, 0, 0, 0)
-		gl.BindVertexArray(bottomHatVAO)
+		gfx.BindVertexArray(bottomHatVAO)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanHatTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_FAN, 0, int32(len(bottomVerticesHat)))
-		gl.BindVertexArray(0)
 
 		snowmanHatTranslate = snowmanHatTranslate.Mul4(mgl32.Scale3D(0.55, 1, 0.55))
-		gl.BindVertexArray(sideHatVAO)
+		gfx.BindVertexArray(sideHatVAO)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanHatTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(sideVerticesHat)))
-		gl.BindVertexArray(0)
 
-		gl.BindVertexArray(topHatVAO)
+		gfx.BindVertexArray(topHatVAO)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &snowmanHatTranslate[0])
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(topVerticesHat)))
-		gl.BindVertexArray(0)
 
 		// plane
 
-		gl.BindVertexArray(planeVAO)
+		gfx.BindVertexArray(planeVAO)
 		snowTexture.Bind(gl.TEXTURE0)
 		snowTexture.SetUniform(textureUniformLocation)
+		snowDrift.Bind(gl.TEXTURE1)
+		snowDrift.SetUniform(heightMapUniformLocation)
+		gl.Uniform1f(heightScaleUniformLocation, 0.3)
 		gl.Uniform3f(colorUniformLocation, 1, 1, 1)
 		gl.UniformMatrix4fv(WorldUniformLocation, 1, false, &model[0])
 		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, int32(len(planeVertices)))
-		snowTexture.UnBind()
-
-		gl.BindVertexArray(0)
+		gl.Uniform1f(heightScaleUniformLocation, 0)
 	}
 
 	return nil
 }
 
 func main() {
+	// -gldebug logs the debug output of the driver, build with -tags gldebug
+	// to also check glGetError after the calls of gfx
+	debug := flag.Bool("gldebug", false, "log the OpenGL debug output")
+	flag.Parse()
 	runtime.LockOSThread()
 
-	win.InitGlfw(4, 1)
+	if *debug {
+		win.InitGlfwDebug(4, 1)
+	} else {
+		win.InitGlfw(4, 1)
+	}
 	defer glfw.Terminate()
 	window := win.NewWindow(width, height, title)
-	gfx.InitGl()
+	if *debug {
+		gfx.InitGlDebug(gfx.DebugOptions{MinSeverity: gl.DEBUG_SEVERITY_LOW})
+	} else {
+		gfx.InitGl()
+	}
 
 	err := programLoop(window)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// anything left here was never deleted
+	if memory := gfx.LiveMemory(); memory.Buffers > 0 || memory.VertexArrays > 0 {
+		log.Printf("leaked %d buffers (%d bytes) and %d vertex arrays", memory.Buffers, memory.Bytes, memory.VertexArrays)
+	}
 }
@@ -6,11 +6,16 @@ layout (location = 1) in vec2 texCoord;
 uniform mat4 world;
 uniform mat4 camera;
 uniform mat4 project;
+// linear heights raising the vertices along y, 0 scale for the flat draws
+uniform sampler2D heightMap;
+uniform float heightScale;
 out vec2 TexCoord;
 
 void main()
 {
     //gl_Position = vec4(position, 1.0);
-    gl_Position = project * camera * world * vec4(position, 1.0);
+    vec3 displaced = position;
+    displaced.y += textureLod(heightMap, texCoord, 0.0).r * heightScale;
+    gl_Position = project * camera * world * vec4(displaced, 1.0);
     TexCoord = texCoord;    // pass the texture coords on to the fragment shader
 }
@@ -100,3 +100,10 @@ func InitGlfw(versionMajor, versionMinor int) {
 	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
 	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 }
+
+// InitGlfwDebug is InitGlfw asking for a debug context, so the driver sends
+// its debug output to gfx.InitGlDebug
+func InitGlfwDebug(versionMajor, versionMinor int) {
+	InitGlfw(versionMajor, versionMinor)
+	glfw.WindowHint(glfw.OpenGLDebugContext, glfw.True)
+}
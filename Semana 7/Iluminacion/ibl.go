@@ -0,0 +1,128 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"os"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// the types below mirror the ones of the glcore gfx package the .ibl files are
+// written with, gob matches them by field name
+
+type iblOptions struct {
+	EnvironmentSize  int
+	IrradianceSize   int
+	PrefilterLevels  int
+	PrefilterSamples int
+	BRDFSize         int
+	BRDFSamples      int
+}
+
+type floatCubemap struct {
+	Size  int
+	Faces [6][]float32 // rgb rows of +X, -X, +Y, -Y, +Z, -Z
+}
+
+type floatImage struct {
+	Width    int
+	Height   int
+	Channels int
+	Pix      []float32
+}
+
+type iblData struct {
+	Options     iblOptions
+	Irradiance  *floatCubemap
+	Prefiltered []*floatCubemap // one per mip level, roughness 0 to 1
+	BRDF        *floatImage     // scale and bias of F0, rg
+}
+
+// IBL holds the image based lighting maps of pbr.frag, baked from a .hdr image
+// by "Semana 6/Textures/cmd/iblbake"
+type IBL struct {
+	Irradiance  uint32 // cubemap
+	Prefiltered uint32 // cubemap
+	BRDF        uint32 // 2D texture
+	// MaxLod is the mip level of roughness 1 in Prefiltered
+	MaxLod float32
+}
+
+// LoadIBL reads an .ibl cache file and uploads its maps
+func LoadIBL(file string) (*IBL, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	var data iblData
+	if err := gob.NewDecoder(zr).Decode(&data); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if data.Irradiance == nil || len(data.Prefiltered) == 0 || data.BRDF == nil {
+		return nil, fmt.Errorf("%s: incomplete ibl data", file)
+	}
+	if data.BRDF.Channels != 2 {
+		return nil, fmt.Errorf("%s: brdf with %d channels, expected 2", file, data.BRDF.Channels)
+	}
+
+	ibl := IBL{
+		Irradiance:  newFloatCubemapTexture([]*floatCubemap{data.Irradiance}),
+		Prefiltered: newFloatCubemapTexture(data.Prefiltered),
+		MaxLod:      float32(len(data.Prefiltered) - 1),
+	}
+
+	gl.GenTextures(1, &ibl.BRDF)
+	gl.BindTexture(gl.TEXTURE_2D, ibl.BRDF)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RG16F, int32(data.BRDF.Width), int32(data.BRDF.Height), 0,
+		gl.RG, gl.FLOAT, gl.Ptr(data.BRDF.Pix))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	return &ibl, nil
+}
+
+// newFloatCubemapTexture uploads levels as the mip levels of a RGB16F cubemap
+func newFloatCubemapTexture(levels []*floatCubemap) uint32 {
+	var handle uint32
+	gl.GenTextures(1, &handle)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, handle)
+
+	minFilter := int32(gl.LINEAR)
+	if len(levels) > 1 {
+		minFilter = gl.LINEAR_MIPMAP_LINEAR
+	}
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MIN_FILTER, minFilter)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAX_LEVEL, int32(len(levels)-1))
+
+	for level, cubemap := range levels {
+		for face, pix := range cubemap.Faces {
+			gl.TexImage2D(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+face), int32(level), gl.RGB16F,
+				int32(cubemap.Size), int32(cubemap.Size), 0, gl.RGB, gl.FLOAT, gl.Ptr(pix))
+		}
+	}
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+	return handle
+}
+
+func (ibl *IBL) Delete() {
+	if ibl == nil {
+		return
+	}
+	textures := []uint32{ibl.Irradiance, ibl.Prefiltered, ibl.BRDF}
+	gl.DeleteTextures(int32(len(textures)), &textures[0])
+}
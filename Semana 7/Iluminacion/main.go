@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"runtime"
@@ -19,47 +20,60 @@ const (
 	title  = "Light"
 )
 
-var (
-	vert = []string{"shaders/phong.vert", "shaders/gouraud.vert", "shaders/flat.vert"}
-	frag = []string{"shaders/phong.frag", "shaders/gouraud.frag", "shaders/flat.frag"}
-)
-
-func createVAO(vertices []float32, indices []uint32) uint32 {
+// index of the pbr shader in vert and frag
+const pbrShader = 3
 
-	var VAO uint32
-	gl.GenVertexArrays(1, &VAO)
+// iblFile is a cache baked by "Semana 6/Textures/cmd/iblbake", ex:
+//
+//	go run ./cmd/iblbake -in images/sky.hdr -out images/sky.ibl
+var iblFile = flag.String("ibl", "", "image based lighting maps of the pbr shader (.ibl)")
 
-	var VBO uint32
-	gl.GenBuffers(1, &VBO)
+var (
+	vert = []string{"shaders/phong.vert", "shaders/gouraud.vert", "shaders/flat.vert", "shaders/pbr.vert"}
+	frag = []string{"shaders/phong.frag", "shaders/gouraud.frag", "shaders/flat.frag", "shaders/pbr.frag"}
+)
 
-	var EBO uint32
-	gl.GenBuffers(1, &EBO)
+// VertexArray is a vertex array with the buffer it owns
+type VertexArray struct {
+	ID     uint32
+	Buffer uint32
+}
 
-	// Bind the Vertex Array Object first, then bind and set vertex buffer(s) and attribute pointers()
-	gl.BindVertexArray(VAO)
+// createVAO builds a vertex array of interleaved vertices, the position and
+// normal of each vertex together at locations 0 and 1
+func createVAO(vertices []float32) (*VertexArray, error) {
+	const stride = (3 + 3) * 4
+	if len(vertices) == 0 || len(vertices)*4%stride != 0 {
+		return nil, fmt.Errorf("%d floats are not a whole number of position and normal vertices", len(vertices))
+	}
 
-	// copy vertices data into VBO (it needs to be bound first)
-	gl.BindBuffer(gl.ARRAY_BUFFER, VBO)
+	var va VertexArray
+	gl.GenVertexArrays(1, &va.ID)
+	gl.GenBuffers(1, &va.Buffer)
+	gl.BindVertexArray(va.ID)
+	gl.BindBuffer(gl.ARRAY_BUFFER, va.Buffer)
 	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STATIC_DRAW)
 
-	// size of one whole vertex (sum of attrib sizes)
-	var stride int32 = 3*4 + 3*4
-	var offset int = 0
-
 	// position
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, stride, gl.PtrOffset(offset))
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, stride, gl.PtrOffset(0))
 	gl.EnableVertexAttribArray(0)
-	offset += 3 * 4
-
 	// normal
-	gl.VertexAttribPointer(1, 3, gl.FLOAT, false, stride, gl.PtrOffset(offset))
+	gl.VertexAttribPointer(1, 3, gl.FLOAT, false, stride, gl.PtrOffset(3*4))
 	gl.EnableVertexAttribArray(1)
-	offset += 3 * 4
 
-	// unbind the VAO (safe practice so we don't accidentally (mis)configure it later)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
 	gl.BindVertexArray(0)
+	return &va, nil
+}
 
-	return VAO
+// Delete frees the vertex array and its buffer
+func (va *VertexArray) Delete() {
+	if va == nil || va.ID == 0 {
+		return
+	}
+	gl.DeleteVertexArrays(1, &va.ID)
+	gl.DeleteBuffers(1, &va.Buffer)
+	va.ID = 0
 }
 
 type AnimationManager struct {
@@ -150,6 +164,22 @@ func programLoop(window *win.Window, shader int) error {
 	lightColorUniformLocation := program.GetUniformLocation("lightColor")
 	objectColorUniformLocation := program.GetUniformLocation("objectColor")
 	lightPosUniformLocation := program.GetUniformLocation("lightPos")
+	viewPosUniformLocation := program.GetUniformLocation("viewPos")
+
+	// only used by the pbr shader
+	lightIntensityUniformLocation := program.GetUniformLocation("lightIntensity")
+	ambientColorUniformLocation := program.GetUniformLocation("ambientColor")
+	hdrOutputUniformLocation := program.GetUniformLocation("hdrOutput")
+	pbrLocations := newPBRLocations(program)
+	// same color as the other shaders, a bit of metal to show the highlights
+	material := NewPBRMaterial(mgl32.Vec3{1., .0, .2}, 0.3, 0.4)
+	var ibl *IBL
+	if shader == pbrShader && *iblFile != "" {
+		if ibl, err = LoadIBL(*iblFile); err != nil {
+			return err
+		}
+		defer ibl.Delete()
+	}
 
 	modelLightUniformLocation := lightProgram.GetUniformLocation("model")
 	viewLightUniformLocation := lightProgram.GetUniformLocation("view")
@@ -174,7 +204,11 @@ func programLoop(window *win.Window, shader int) error {
 
 	// Scene and animation
 	animationCtl := NewAnimationManager()
-	VAO := createVAO(cubeVertices, nil)
+	VAO, err := createVAO(cubeVertices)
+	if err != nil {
+		return err
+	}
+	defer VAO.Delete()
 	lightVAO := VAO
 
 	animationCtl.Init()
@@ -198,23 +232,35 @@ func programLoop(window *win.Window, shader int) error {
 		gl.UniformMatrix4fv(viewUniformLocation, 1, false, &camera[0])
 		gl.UniformMatrix4fv(projectUniformLocation, 1, false, &projectTransform[0])
 
-		gl.BindVertexArray(VAO)
+		gl.BindVertexArray(VAO.ID)
 
 		// obj is colored, light is white
 		gl.Uniform3f(objectColorUniformLocation, 1., .0, .2)
 		gl.Uniform3f(lightColorUniformLocation, 1.0, 1.0, 1.0)
 		gl.Uniform3f(lightPosUniformLocation, lightPos.X(), lightPos.Y(), lightPos.Z())
+		gl.Uniform3fv(viewPosUniformLocation, 1, &eye[0])
+		if shader == pbrShader {
+			gl.Uniform1f(lightIntensityUniformLocation, 10)
+			gl.Uniform3f(ambientColorUniformLocation, 0.03, 0.03, 0.03)
+			gl.Uniform1i(hdrOutputUniformLocation, 0)
+			// nil without -ibl, the ambient color lights the scene then
+			pbrLocations.SetEnvironment(ibl)
+			material.Bind(pbrLocations)
+		}
 
 		worldTranslate := mgl32.Translate3D(0.0, 0.0, 0.0)
 		worldTransform := worldTranslate.Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())).Mul4(mgl32.HomogRotate3DZ(float32(animationCtl.GetAngle()))))
 		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &worldTransform[0])
 		gl.DrawArrays(gl.TRIANGLES, 0, 36)
 		gl.BindVertexArray(0)
+		if shader == pbrShader {
+			material.UnBind()
+		}
 
 		// Draw the light obj after the other boxes using its separate shader program
 		// this means that we must re-bind any uniforms
 		lightProgram.Use()
-		gl.BindVertexArray(lightVAO)
+		gl.BindVertexArray(lightVAO.ID)
 		gl.UniformMatrix4fv(modelLightUniformLocation, 1, false, &lightTransform[0])
 		gl.UniformMatrix4fv(viewLightUniformLocation, 1, false, &camera[0])
 		gl.UniformMatrix4fv(projectLightUniformLocation, 1, false, &projectTransform[0])
@@ -226,10 +272,11 @@ func programLoop(window *win.Window, shader int) error {
 }
 
 func main() {
-	fmt.Println("Please select a shader:\n[0] Phong\n[1] Gouraund\n[2] Flat")
+	flag.Parse()
+	fmt.Println("Please select a shader:\n[0] Phong\n[1] Gouraund\n[2] Flat\n[3] PBR")
 	var shader int
 	fmt.Scanln(&shader)
-	if shader > 2 {
+	if shader < 0 || shader >= len(vert) {
 		shader = 0
 	}
 
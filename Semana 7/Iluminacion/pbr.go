@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// PBRMaterial is a metallic-roughness material for pbr.frag, each map replaces
+// its constant when it is not nil. Colors are linear, the albedo and emissive
+// maps are srgb
+type PBRMaterial struct {
+	Albedo    mgl32.Vec3
+	Metallic  float32
+	Roughness float32
+	AO        float32
+	Emissive  mgl32.Vec3
+
+	AlbedoMap    *gfx.Texture
+	MetallicMap  *gfx.Texture
+	RoughnessMap *gfx.Texture
+	AOMap        *gfx.Texture
+	EmissiveMap  *gfx.Texture
+}
+
+func NewPBRMaterial(albedo mgl32.Vec3, metallic, roughness float32) *PBRMaterial {
+	return &PBRMaterial{
+		Albedo:    albedo,
+		Metallic:  metallic,
+		Roughness: roughness,
+		AO:        1,
+	}
+}
+
+type pbrMapLocations struct {
+	sampler, hasMap int32
+}
+
+// texture units of the image based lighting maps, after the material maps
+const (
+	irradianceUnit = gl.TEXTURE5
+	prefilterUnit  = gl.TEXTURE6
+	brdfUnit       = gl.TEXTURE7
+)
+
+type pbrLocations struct {
+	albedo, metallic, roughness, ao, emissive int32
+	maps                                      [5]pbrMapLocations
+
+	useIBL, irradianceMap, prefilterMap, brdfLUT, prefilterMaxLod int32
+}
+
+func newPBRLocations(program *gfx.Program) pbrLocations {
+	names := [5][2]string{
+		{"albedoMap", "material.hasAlbedoMap"},
+		{"metallicMap", "material.hasMetallicMap"},
+		{"roughnessMap", "material.hasRoughnessMap"},
+		{"aoMap", "material.hasAOMap"},
+		{"emissiveMap", "material.hasEmissiveMap"},
+	}
+	locs := pbrLocations{
+		albedo:    program.GetUniformLocation("material.albedo"),
+		metallic:  program.GetUniformLocation("material.metallic"),
+		roughness: program.GetUniformLocation("material.roughness"),
+		ao:        program.GetUniformLocation("material.ao"),
+		emissive:  program.GetUniformLocation("material.emissive"),
+
+		useIBL:          program.GetUniformLocation("useIBL"),
+		irradianceMap:   program.GetUniformLocation("irradianceMap"),
+		prefilterMap:    program.GetUniformLocation("prefilterMap"),
+		brdfLUT:         program.GetUniformLocation("brdfLUT"),
+		prefilterMaxLod: program.GetUniformLocation("prefilterMaxLod"),
+	}
+	for i, name := range names {
+		locs.maps[i] = pbrMapLocations{
+			sampler: program.GetUniformLocation(name[0]),
+			hasMap:  program.GetUniformLocation(name[1]),
+		}
+	}
+	return locs
+}
+
+// SetEnvironment binds the image based lighting maps of a pbr program in use,
+// a nil ibl turns it off and the constant ambientColor lights the scene
+func (locs pbrLocations) SetEnvironment(ibl *IBL) {
+	// the cube samplers must never share a unit with the 2D ones, even unused
+	gl.Uniform1i(locs.irradianceMap, irradianceUnit-gl.TEXTURE0)
+	gl.Uniform1i(locs.prefilterMap, prefilterUnit-gl.TEXTURE0)
+	gl.Uniform1i(locs.brdfLUT, brdfUnit-gl.TEXTURE0)
+	if ibl == nil {
+		gl.Uniform1i(locs.useIBL, 0)
+		return
+	}
+	gl.Uniform1i(locs.useIBL, 1)
+	gl.Uniform1f(locs.prefilterMaxLod, ibl.MaxLod)
+	gl.ActiveTexture(irradianceUnit)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, ibl.Irradiance)
+	gl.ActiveTexture(prefilterUnit)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, ibl.Prefiltered)
+	gl.ActiveTexture(brdfUnit)
+	gl.BindTexture(gl.TEXTURE_2D, ibl.BRDF)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+func (m *PBRMaterial) textures() [5]*gfx.Texture {
+	return [5]*gfx.Texture{m.AlbedoMap, m.MetallicMap, m.RoughnessMap, m.AOMap, m.EmissiveMap}
+}
+
+// Bind sets the material uniforms of the program in use and binds its maps
+// from TEXTURE0 up
+func (m *PBRMaterial) Bind(locs pbrLocations) {
+	gl.Uniform3fv(locs.albedo, 1, &m.Albedo[0])
+	gl.Uniform1f(locs.metallic, m.Metallic)
+	gl.Uniform1f(locs.roughness, m.Roughness)
+	gl.Uniform1f(locs.ao, m.AO)
+	gl.Uniform3fv(locs.emissive, 1, &m.Emissive[0])
+
+	for i, texture := range m.textures() {
+		loc := locs.maps[i]
+		if texture == nil {
+			gl.Uniform1i(loc.hasMap, 0)
+			continue
+		}
+		gl.Uniform1i(loc.hasMap, 1)
+		texture.Bind(uint32(gl.TEXTURE0 + i))
+		texture.SetUniform(loc.sampler)
+	}
+}
+
+func (m *PBRMaterial) UnBind() {
+	for _, texture := range m.textures() {
+		if texture != nil {
+			texture.UnBind()
+		}
+	}
+}
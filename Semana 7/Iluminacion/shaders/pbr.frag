@@ -0,0 +1,140 @@
+#version 410 core
+out vec4 FragColor;
+
+in vec3 FragPos;
+in vec3 Normal;
+in vec2 TexCoord;
+
+// metallic-roughness material, each map replaces its constant when present
+struct Material {
+    vec3 albedo;    // linear color
+    float metallic;
+    float roughness;
+    float ao;
+    vec3 emissive;  // linear radiance, added unlit
+
+    bool hasAlbedoMap;
+    bool hasMetallicMap;
+    bool hasRoughnessMap;
+    bool hasAOMap;
+    bool hasEmissiveMap;
+};
+
+uniform Material material;
+uniform sampler2D albedoMap;    // srgb color
+uniform sampler2D metallicMap;  // red channel
+uniform sampler2D roughnessMap; // red channel
+uniform sampler2D aoMap;        // red channel
+uniform sampler2D emissiveMap;  // srgb color
+
+uniform vec3 lightPos;
+uniform vec3 lightColor;
+uniform float lightIntensity; // radiant intensity, falls with the squared distance
+uniform vec3 ambientColor;    // constant ambient light when there are no environment maps
+uniform vec3 viewPos;
+// image based lighting, the maps are made by gfx.ComputeIBL from a .hdr environment
+uniform bool useIBL;
+uniform samplerCube irradianceMap; // diffuse light around each normal
+uniform samplerCube prefilterMap;  // specular light, blurrier for rougher mip levels
+uniform sampler2D brdfLUT;         // scale and bias of F0 by NdotV and roughness
+uniform float prefilterMaxLod;     // mip level of roughness 1
+// true writes the linear radiance for a float framebuffer and a post process,
+// false tone maps and gamma corrects for the default framebuffer
+uniform bool hdrOutput;
+
+const float PI = 3.14159265359;
+
+// Trowbridge-Reitz GGX normal distribution
+float DistributionGGX(vec3 N, vec3 H, float roughness)
+{
+    float a = roughness * roughness;
+    float a2 = a * a;
+    float NdotH = max(dot(N, H), 0.0);
+    float denom = NdotH * NdotH * (a2 - 1.0) + 1.0;
+    return a2 / (PI * denom * denom);
+}
+
+// Schlick-GGX geometry term of one direction, k remapped for direct lights
+float GeometrySchlickGGX(float NdotV, float roughness)
+{
+    float r = roughness + 1.0;
+    float k = (r * r) / 8.0;
+    return NdotV / (NdotV * (1.0 - k) + k);
+}
+
+// Smith's method, shadowing from the light and masking from the viewer
+float GeometrySmith(vec3 N, vec3 V, vec3 L, float roughness)
+{
+    return GeometrySchlickGGX(max(dot(N, V), 0.0), roughness) * GeometrySchlickGGX(max(dot(N, L), 0.0), roughness);
+}
+
+vec3 FresnelSchlick(float cosTheta, vec3 F0)
+{
+    return F0 + (1.0 - F0) * pow(clamp(1.0 - cosTheta, 0.0, 1.0), 5.0);
+}
+
+// rough surfaces reflect less of the environment at grazing angles
+vec3 FresnelSchlickRoughness(float cosTheta, vec3 F0, float roughness)
+{
+    return F0 + (max(vec3(1.0 - roughness), F0) - F0) * pow(clamp(1.0 - cosTheta, 0.0, 1.0), 5.0);
+}
+
+void main()
+{
+    vec3 albedo = material.albedo;
+    if (material.hasAlbedoMap)
+        albedo = pow(texture(albedoMap, TexCoord).rgb, vec3(2.2));
+    float metallic = material.hasMetallicMap ? texture(metallicMap, TexCoord).r : material.metallic;
+    float roughness = material.hasRoughnessMap ? texture(roughnessMap, TexCoord).r : material.roughness;
+    // a perfectly smooth surface makes the highlight a single infinite point
+    roughness = clamp(roughness, 0.04, 1.0);
+    float ao = material.hasAOMap ? texture(aoMap, TexCoord).r : material.ao;
+    vec3 emissive = material.emissive;
+    if (material.hasEmissiveMap)
+        emissive = pow(texture(emissiveMap, TexCoord).rgb, vec3(2.2));
+
+    vec3 N = normalize(Normal);
+    vec3 V = normalize(viewPos - FragPos);
+
+    // dielectrics reflect about 4% at normal incidence, metals tint it with their albedo
+    vec3 F0 = mix(vec3(0.04), albedo, metallic);
+
+    // radiance of the point light, inverse square falloff
+    vec3 L = normalize(lightPos - FragPos);
+    vec3 H = normalize(V + L);
+    float distance = length(lightPos - FragPos);
+    vec3 radiance = lightColor * lightIntensity / (distance * distance);
+
+    // Cook-Torrance specular BRDF
+    float NDF = DistributionGGX(N, H, roughness);
+    float G = GeometrySmith(N, V, L, roughness);
+    vec3 F = FresnelSchlick(max(dot(H, V), 0.0), F0);
+    float NdotL = max(dot(N, L), 0.0);
+    vec3 specular = NDF * G * F / (4.0 * max(dot(N, V), 0.0) * NdotL + 0.0001);
+
+    // what is not reflected is refracted and diffused, metals have no diffuse
+    vec3 kD = (vec3(1.0) - F) * (1.0 - metallic);
+    vec3 Lo = (kD * albedo / PI + specular) * radiance * NdotL;
+
+    vec3 ambient = ambientColor * albedo * ao;
+    if (useIBL) {
+        // split sum approximation, the diffuse and specular parts of the
+        // environment are weighted like the direct light
+        float NdotV = max(dot(N, V), 0.0);
+        vec3 F = FresnelSchlickRoughness(NdotV, F0, roughness);
+        vec3 kD = (vec3(1.0) - F) * (1.0 - metallic);
+        vec3 diffuse = texture(irradianceMap, N).rgb * albedo;
+        vec3 prefiltered = textureLod(prefilterMap, reflect(-V, N), roughness * prefilterMaxLod).rgb;
+        vec2 brdf = texture(brdfLUT, vec2(NdotV, roughness)).rg;
+        vec3 specularIBL = prefiltered * (F * brdf.x + brdf.y);
+        ambient = (kD * diffuse + specularIBL) * ao;
+    }
+    vec3 color = ambient + Lo + emissive;
+
+    if (!hdrOutput) {
+        // reinhard tone mapping and gamma correction
+        color = color / (color + vec3(1.0));
+        color = pow(color, vec3(1.0 / 2.2));
+    }
+    FragColor = vec4(color, 1.0);
+}
@@ -28,47 +28,77 @@ var (
 	}
 )
 
-func createVAO(vertices, normals, tCoords []float32, indices []uint32) uint32 {
-
-	var VAO uint32
-	gl.GenVertexArrays(1, &VAO)
-	gl.BindVertexArray(VAO)
-
-	var VBO uint32
-	gl.GenBuffers(1, &VBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, VBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STATIC_DRAW)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(0)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
-	var NBO uint32
-	gl.GenBuffers(1, &NBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, NBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(normals)*4, gl.Ptr(normals), gl.STATIC_DRAW)
-	gl.VertexAttribPointer(1, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(1)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+// VertexArray is a vertex array with the buffers it owns
+type VertexArray struct {
+	ID      uint32
+	Buffers []uint32 // one per attribute, in the order of createVAO
+	EBO     uint32   // 0 without indices
+}
 
-	if len(tCoords) > 0 {
-		var TBO uint32
-		gl.GenBuffers(1, &TBO)
-		gl.BindBuffer(gl.ARRAY_BUFFER, TBO)
-		gl.BufferData(gl.ARRAY_BUFFER, len(tCoords)*4, gl.Ptr(tCoords), gl.STATIC_DRAW)
-		gl.VertexAttribPointer(2, 2, gl.FLOAT, false, 2*4, gl.PtrOffset(0))
-		gl.EnableVertexAttribArray(2)
-		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+// createVAO builds a vertex array reading the positions and normals at
+// locations 0 and 1 and, when tCoords is not empty, the texture coordinates
+// at location 2, each from a buffer of its own. All of them must hold the
+// same number of vertices
+func createVAO(vertices, normals, tCoords []float32, indices []uint32) (*VertexArray, error) {
+	attributes := []struct {
+		name       string
+		components int
+		data       []float32
+	}{
+		{"aPos", 3, vertices},
+		{"aNormal", 3, normals},
+		{"texCoord", 2, tCoords},
+	}
+	if len(tCoords) == 0 {
+		attributes = attributes[:2]
+	}
+	count := len(vertices) / 3
+	if count == 0 {
+		return nil, fmt.Errorf("vertex array without vertices")
+	}
+	for _, attribute := range attributes {
+		if len(attribute.data) != count*attribute.components {
+			return nil, fmt.Errorf("attribute %s has %d floats, %d vertices need %d",
+				attribute.name, len(attribute.data), count, count*attribute.components)
+		}
+	}
+	for _, index := range indices {
+		if int(index) >= count {
+			return nil, fmt.Errorf("index %d past the %d vertices", index, count)
+		}
 	}
 
-	var EBO uint32
-	gl.GenBuffers(1, &EBO)
-	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, EBO)
-	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+	va := VertexArray{Buffers: make([]uint32, len(attributes))}
+	gl.GenVertexArrays(1, &va.ID)
+	gl.BindVertexArray(va.ID)
+	gl.GenBuffers(int32(len(va.Buffers)), &va.Buffers[0])
+	for i, attribute := range attributes {
+		gl.BindBuffer(gl.ARRAY_BUFFER, va.Buffers[i])
+		gl.BufferData(gl.ARRAY_BUFFER, len(attribute.data)*4, gl.Ptr(attribute.data), gl.STATIC_DRAW)
+		gl.VertexAttribPointer(uint32(i), int32(attribute.components), gl.FLOAT, false, 0, nil)
+		gl.EnableVertexAttribArray(uint32(i))
+	}
 	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
+	if len(indices) > 0 {
+		gl.GenBuffers(1, &va.EBO)
+		gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, va.EBO)
+		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+	}
 	gl.BindVertexArray(0)
+	return &va, nil
+}
 
-	return VAO
+// Delete frees the vertex array and its buffers
+func (va *VertexArray) Delete() {
+	if va == nil || va.ID == 0 {
+		return
+	}
+	gl.DeleteVertexArrays(1, &va.ID)
+	gl.DeleteBuffers(int32(len(va.Buffers)), &va.Buffers[0])
+	if va.EBO != 0 {
+		gl.DeleteBuffers(1, &va.EBO)
+	}
+	va.ID = 0
 }
 
 func pointLightsUniformLocations(program *gfx.Program) [][]int32 {
@@ -173,7 +203,11 @@ func programLoop(window *win.Window) error {
 	// Geometry
 	xSegments := 30
 	ySegments := 30
-	VAO := createVAO(Sphere(xSegments, ySegments))
+	VAO, err := createVAO(Sphere(xSegments, ySegments))
+	if err != nil {
+		return err
+	}
+	defer VAO.Delete()
 	lightVAO := VAO
 
 	// Scene and animation always needs to be after the model and buffers initialization
@@ -219,7 +253,7 @@ func programLoop(window *win.Window) error {
 		}
 
 		// render models
-		gl.BindVertexArray(VAO)
+		gl.BindVertexArray(VAO.ID)
 		earthTexture.Bind(gl.TEXTURE0)
 		earthTexture.SetUniform(textureUniformLocation)
 
@@ -236,7 +270,7 @@ func programLoop(window *win.Window) error {
 		gl.UniformMatrix4fv(projectSourceUniformLocation, 1, false, &projectTransform[0])
 		gl.UniformMatrix4fv(viewSourceUniformLocation, 1, false, &camera[0])
 		gl.Uniform3f(objectColorSourceUniformLocation, lightColor.X(), lightColor.Y(), lightColor.Z())
-		gl.BindVertexArray(lightVAO)
+		gl.BindVertexArray(lightVAO.ID)
 		for _, lp := range pointLightPositions {
 			cubeM := mgl32.Ident4()
 			cubeM = cubeM.Mul4(mgl32.Translate3D(lp.Elem())).Mul4(mgl32.Scale3D(0.2, 0.2, 0.2))
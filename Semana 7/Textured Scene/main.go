@@ -70,47 +70,77 @@ func turnStar(im *win.InputManager, colorNum int, changeColor bool) (mgl32.Vec3,
 
 }
 
-func createVAO(vertices, normals, tCoords []float32, indices []uint32) uint32 {
-
-	var VAO uint32
-	gl.GenVertexArrays(1, &VAO)
-	gl.BindVertexArray(VAO)
-
-	var VBO uint32
-	gl.GenBuffers(1, &VBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, VBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STATIC_DRAW)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(0)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
-	var NBO uint32
-	gl.GenBuffers(1, &NBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, NBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(normals)*4, gl.Ptr(normals), gl.STATIC_DRAW)
-	gl.VertexAttribPointer(1, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(1)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+// VertexArray is a vertex array with the buffers it owns
+type VertexArray struct {
+	ID      uint32
+	Buffers []uint32 // one per attribute, in the order of createVAO
+	EBO     uint32   // 0 without indices
+}
 
-	if len(tCoords) > 0 {
-		var TBO uint32
-		gl.GenBuffers(1, &TBO)
-		gl.BindBuffer(gl.ARRAY_BUFFER, TBO)
-		gl.BufferData(gl.ARRAY_BUFFER, len(tCoords)*4, gl.Ptr(tCoords), gl.STATIC_DRAW)
-		gl.VertexAttribPointer(2, 2, gl.FLOAT, false, 2*4, gl.PtrOffset(0))
-		gl.EnableVertexAttribArray(2)
-		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+// createVAO builds a vertex array reading the positions and normals at
+// locations 0 and 1 and, when tCoords is not empty, the texture coordinates
+// at location 2, each from a buffer of its own. All of them must hold the
+// same number of vertices
+func createVAO(vertices, normals, tCoords []float32, indices []uint32) (*VertexArray, error) {
+	attributes := []struct {
+		name       string
+		components int
+		data       []float32
+	}{
+		{"aPos", 3, vertices},
+		{"aNormal", 3, normals},
+		{"texCoord", 2, tCoords},
+	}
+	if len(tCoords) == 0 {
+		attributes = attributes[:2]
+	}
+	count := len(vertices) / 3
+	if count == 0 {
+		return nil, fmt.Errorf("vertex array without vertices")
+	}
+	for _, attribute := range attributes {
+		if len(attribute.data) != count*attribute.components {
+			return nil, fmt.Errorf("attribute %s has %d floats, %d vertices need %d",
+				attribute.name, len(attribute.data), count, count*attribute.components)
+		}
+	}
+	for _, index := range indices {
+		if int(index) >= count {
+			return nil, fmt.Errorf("index %d past the %d vertices", index, count)
+		}
 	}
 
-	var EBO uint32
-	gl.GenBuffers(1, &EBO)
-	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, EBO)
-	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+	va := VertexArray{Buffers: make([]uint32, len(attributes))}
+	gl.GenVertexArrays(1, &va.ID)
+	gl.BindVertexArray(va.ID)
+	gl.GenBuffers(int32(len(va.Buffers)), &va.Buffers[0])
+	for i, attribute := range attributes {
+		gl.BindBuffer(gl.ARRAY_BUFFER, va.Buffers[i])
+		gl.BufferData(gl.ARRAY_BUFFER, len(attribute.data)*4, gl.Ptr(attribute.data), gl.STATIC_DRAW)
+		gl.VertexAttribPointer(uint32(i), int32(attribute.components), gl.FLOAT, false, 0, nil)
+		gl.EnableVertexAttribArray(uint32(i))
+	}
 	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
+	if len(indices) > 0 {
+		gl.GenBuffers(1, &va.EBO)
+		gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, va.EBO)
+		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+	}
 	gl.BindVertexArray(0)
+	return &va, nil
+}
 
-	return VAO
+// Delete frees the vertex array and its buffers
+func (va *VertexArray) Delete() {
+	if va == nil || va.ID == 0 {
+		return
+	}
+	gl.DeleteVertexArrays(1, &va.ID)
+	gl.DeleteBuffers(int32(len(va.Buffers)), &va.Buffers[0])
+	if va.EBO != 0 {
+		gl.DeleteBuffers(1, &va.EBO)
+	}
+	va.ID = 0
 }
 
 func pointLightsUniformLocations(program *gfx.Program) [][]int32 {
@@ -257,11 +287,27 @@ func programLoop(window *win.Window) error {
 	logModel := mgl32.Ident4()
 
 	// Buffers
-	cylinderVAO := createVAO(verticesCylinder, normalsCylinder, tCoordsCylinder, indicesCylinder)
-	planeVAO := createVAO(verticesPlane, normalsPlane, tCoordsPlane, indicesPlane)
-	coneVAO := createVAO(verticesCone, normalsCone, tCoordsCone, indicesCone)
-	lightVAO := createVAO(verticesSpere, normalsSpere, tCoordsSpere, indicesSpere)
-	skyVAO := createVAO(Cube(80, 80, 80))
+	var cylinderVAO, planeVAO, coneVAO, lightVAO, skyVAO *VertexArray
+	defer func() {
+		for _, vao := range []*VertexArray{cylinderVAO, planeVAO, coneVAO, lightVAO, skyVAO} {
+			vao.Delete()
+		}
+	}()
+	if cylinderVAO, err = createVAO(verticesCylinder, normalsCylinder, tCoordsCylinder, indicesCylinder); err != nil {
+		return err
+	}
+	if planeVAO, err = createVAO(verticesPlane, normalsPlane, tCoordsPlane, indicesPlane); err != nil {
+		return err
+	}
+	if coneVAO, err = createVAO(verticesCone, normalsCone, tCoordsCone, indicesCone); err != nil {
+		return err
+	}
+	if lightVAO, err = createVAO(verticesSpere, normalsSpere, tCoordsSpere, indicesSpere); err != nil {
+		return err
+	}
+	if skyVAO, err = createVAO(Cube(80, 80, 80)); err != nil {
+		return err
+	}
 	// Scene and animation always needs to be after the model and buffers initialization
 	animationCtl := gfx.NewAnimationManager()
 	lightColor, numColor, changeColor := turnStar(window.InputManager(), 0, true)
@@ -370,7 +416,7 @@ func programLoop(window *win.Window) error {
 		}
 
 		// render models
-		gl.BindVertexArray(planeVAO)
+		gl.BindVertexArray(planeVAO.ID)
 		snowTexture.Bind(gl.TEXTURE0)
 		snowTexture.SetUniform(textureUniformLocation)
 
@@ -383,7 +429,7 @@ func programLoop(window *win.Window) error {
 
 		// log
 		logModelTransform := logModel.Mul4(mgl32.Scale3D(1, 3, 1))
-		gl.BindVertexArray(cylinderVAO)
+		gl.BindVertexArray(cylinderVAO.ID)
 		logTexture.Bind(gl.TEXTURE0)
 		logTexture.SetUniform(textureUniformLocation)
 		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &logModelTransform[0])
@@ -393,7 +439,7 @@ func programLoop(window *win.Window) error {
 		// leave 1
 		leaveModelTranslate := logModelTransform.Mul4(mgl32.Scale3D(4, 1, 4).Mul4(mgl32.Translate3D(0, 1, 0)))
 		leaveOneModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(10), mgl32.Vec3{0, 0, 1})).Mul4(mgl32.Scale3D(1, 1.5, 1))
-		gl.BindVertexArray(coneVAO)
+		gl.BindVertexArray(coneVAO.ID)
 		leavesTexture.Bind(gl.TEXTURE0)
 		leavesTexture.SetUniform(textureUniformLocation)
 		decoratorLeavesTexture.Bind(gl.TEXTURE1)
@@ -423,7 +469,7 @@ func programLoop(window *win.Window) error {
 		gl.UniformMatrix4fv(viewSourceUniformLocation, 1, false, &camTransform[0])
 		moonTexture.Bind(gl.TEXTURE0)
 		moonTexture.SetUniform(texSampler3SourceUniformLocation)
-		gl.BindVertexArray(lightVAO)
+		gl.BindVertexArray(lightVAO.ID)
 
 		cubeM := mgl32.Ident4()
 		cubeM = cubeM.Mul4(mgl32.Translate3D(pointLightPositions[2].Elem())).Mul4(mgl32.Scale3D(3, 3, 3))
@@ -435,7 +481,7 @@ func programLoop(window *win.Window) error {
 		gl.BindVertexArray(0)
 		discoBall.Bind(gl.TEXTURE0)
 		discoBall.SetUniform(texSampler3SourceUniformLocation)
-		gl.BindVertexArray(lightVAO)
+		gl.BindVertexArray(lightVAO.ID)
 
 		gl.Uniform3f(objectColorSourceUniformLocation, lightColor.X(), lightColor.Y(), lightColor.Z())
 		starModel := model.Mul4(mgl32.Translate3D(-0.2, 9.9, 0)).Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())))
@@ -444,7 +490,7 @@ func programLoop(window *win.Window) error {
 		discoBall.UnBind()
 		gl.BindVertexArray(0)
 
-		gl.BindVertexArray(lightVAO)
+		gl.BindVertexArray(lightVAO.ID)
 		gl.Uniform3f(objectColorSourceUniformLocation, pointLightColorsRef[4].X(), pointLightColorsRef[4].Y(), pointLightColorsRef[4].Z())
 		shootingModel := model.Mul4(mgl32.Translate3D(pointLightPositions[4].Elem())).Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())))
 		gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &shootingModel[0])
@@ -452,7 +498,7 @@ func programLoop(window *win.Window) error {
 		gl.BindVertexArray(0)
 
 		//Sky box
-		gl.BindVertexArray(skyVAO)
+		gl.BindVertexArray(skyVAO.ID)
 		starsTexture.Bind(gl.TEXTURE0)
 		starsTexture.SetUniform(texSampler3SourceUniformLocation)
 		gl.Uniform3f(objectColorSourceUniformLocation, backgroundColor.X(), backgroundColor.Y(), backgroundColor.Z())
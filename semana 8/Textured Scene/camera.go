@@ -97,6 +97,11 @@ func (c *FpsCamera) updateVectors() {
 	c.up = c.right.Cross(c.front).Normalize()
 }
 
+// GetPosition returns the position of the camera in world coordinates
+func (camera *FpsCamera) GetPosition() mgl32.Vec3 {
+	return camera.pos
+}
+
 // GetCameraTransform gets the matrix to transform from world coordinates to
 // this camera's coordinates.
 func (camera *FpsCamera) GetTransform() mgl32.Mat4 {
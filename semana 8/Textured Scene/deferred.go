@@ -0,0 +1,248 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// G-buffer targets, in the order of the outputs of gbuffer.frag
+const (
+	gPosition = iota // world position and view depth
+	gNormal   = iota // world normal, alpha is 0 where there is no geometry
+	gAlbedo   = iota // color multiplied by the light
+	gUnlit    = iota // color added once without light
+	gTargets  = iota
+)
+
+// the light volumes are low poly spheres, scaled up so they contain the real one
+const lightVolumeScale = 1.05
+
+// DeferredRenderer draws the lit models in two steps: the geometry pass stores
+// the surface of each pixel in the G-buffer, then the lights are added only to
+// the pixels they reach, a screen triangle for directional and spot lights and
+// a sphere per point light. It is an alternative to LightManager.Render and
+// gives the same image
+type DeferredRenderer struct {
+	width, height int32
+
+	gbuffer *Framebuffer
+
+	geometryProgram  *gfx.Program
+	geometryLocs     geometryLocations
+	fullscreenLight  *gfx.Program
+	fullscreenLocs   deferredLightLocations
+	volumeLight      *gfx.Program
+	volumeLocs       deferredLightLocations
+	emptyVAO         uint32
+	sphereVAO        uint32
+	sphereNumIndices int32
+}
+
+type geometryLocations struct {
+	model, view, projection, objectColor, texSampler, texSampler2 int32
+}
+
+type deferredLightLocations struct {
+	model, view, projection, viewPos, screenSize int32
+	targets                                      [gTargets]int32
+}
+
+// NewDeferredRenderer creates a width x height G-buffer, sphereVAO holds a
+// unit sphere drawn with sphereNumIndices indices
+func NewDeferredRenderer(width, height int32, sphereVAO uint32, sphereNumIndices int32) (*DeferredRenderer, error) {
+	r := DeferredRenderer{
+		width:            width,
+		height:           height,
+		sphereVAO:        sphereVAO,
+		sphereNumIndices: sphereNumIndices,
+	}
+
+	r.gbuffer = NewFramebuffer(width, height)
+	for _, format := range [gTargets]int32{gl.RGBA32F, gl.RGBA16F, gl.RGBA8, gl.RGBA8} {
+		r.gbuffer.AddColor(format, gl.RGBA, gl.FLOAT, gl.NEAREST)
+	}
+	// same format as the default framebuffer so the depth can be blitted to it
+	r.gbuffer.AddDepthBuffer(gl.DEPTH24_STENCIL8)
+	if err := r.gbuffer.Complete(); err != nil {
+		r.Delete()
+		return nil, err
+	}
+
+	var err error
+	r.geometryProgram, err = newProgramFromFiles("shaders/phong_ml.vert", "shaders/gbuffer.frag")
+	if err != nil {
+		r.Delete()
+		return nil, err
+	}
+	r.geometryLocs = geometryLocations{
+		model:       r.geometryProgram.GetUniformLocation("model"),
+		view:        r.geometryProgram.GetUniformLocation("view"),
+		projection:  r.geometryProgram.GetUniformLocation("projection"),
+		objectColor: r.geometryProgram.GetUniformLocation("objectColor"),
+		texSampler:  r.geometryProgram.GetUniformLocation("texSampler"),
+		texSampler2: r.geometryProgram.GetUniformLocation("texSampler2"),
+	}
+
+	r.fullscreenLight, err = newProgramFromFiles("shaders/deferred_fullscreen.vert", "shaders/deferred_light.frag")
+	if err != nil {
+		r.Delete()
+		return nil, err
+	}
+	r.fullscreenLocs = newDeferredLightLocations(r.fullscreenLight)
+
+	r.volumeLight, err = newProgramFromFiles("shaders/deferred_volume.vert", "shaders/deferred_light.frag")
+	if err != nil {
+		r.Delete()
+		return nil, err
+	}
+	r.volumeLocs = newDeferredLightLocations(r.volumeLight)
+
+	// core profile needs a bound VAO even if the vertex shader uses no attributes
+	gl.GenVertexArrays(1, &r.emptyVAO)
+
+	return &r, nil
+}
+
+func newDeferredLightLocations(program *gfx.Program) deferredLightLocations {
+	return deferredLightLocations{
+		model:      program.GetUniformLocation("model"),
+		view:       program.GetUniformLocation("view"),
+		projection: program.GetUniformLocation("projection"),
+		viewPos:    program.GetUniformLocation("viewPos"),
+		screenSize: program.GetUniformLocation("screenSize"),
+		targets: [gTargets]int32{
+			program.GetUniformLocation("gPosition"),
+			program.GetUniformLocation("gNormal"),
+			program.GetUniformLocation("gAlbedo"),
+			program.GetUniformLocation("gUnlit"),
+		},
+	}
+}
+
+// Geometry clears the G-buffer and fills it with what draw renders, draw gets
+// the uniform locations of the geometry program the same way drawModels does.
+// target is bound again when it returns, nil for the default framebuffer
+func (r *DeferredRenderer) Geometry(target *Framebuffer, view, projection mgl32.Mat4, objectColor mgl32.Vec3,
+	draw func(modelLoc, texLoc, tex2Loc int32)) {
+
+	r.gbuffer.Bind()
+	// black albedo and zero normal alpha mark the background
+	gl.ClearColor(0, 0, 0, 0)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+
+	r.geometryProgram.Use()
+	gl.UniformMatrix4fv(r.geometryLocs.view, 1, false, &view[0])
+	gl.UniformMatrix4fv(r.geometryLocs.projection, 1, false, &projection[0])
+	gl.Uniform3fv(r.geometryLocs.objectColor, 1, &objectColor[0])
+	draw(r.geometryLocs.model, r.geometryLocs.texSampler, r.geometryLocs.texSampler2)
+
+	target.Bind()
+}
+
+// GeometryTextures returns the position and normal targets of the G-buffer,
+// the input of SSAO.Compute
+func (r *DeferredRenderer) GeometryTextures() (position, normal uint32) {
+	return r.gbuffer.Texture(gPosition), r.gbuffer.Texture(gNormal)
+}
+
+// Light culls the lights of lm and adds them to target, nil for the default
+// framebuffer, then copies the depth of the G-buffer to it so forward objects
+// drawn afterwards are hidden by the lit models. ssao and fog may be nil
+func (r *DeferredRenderer) Light(target *Framebuffer, lm *LightManager, view, projection mgl32.Mat4, viewPos mgl32.Vec3,
+	ssao *SSAO, fog *Fog) {
+
+	lm.Cull(projection.Mul4(view))
+	state := saveRenderState()
+	target.Bind()
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.DepthMask(false)
+	gl.BlendFunc(gl.ONE, gl.ONE)
+	for i := 0; i < gTargets; i++ {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + i))
+		gl.BindTexture(gl.TEXTURE_2D, r.gbuffer.Texture(i))
+	}
+
+	// directional and spot lights, the first pass replaces the background of the
+	// lit pixels and also adds the unlit colors
+	r.fullscreenLight.Use()
+	r.setLightUniforms(r.fullscreenLocs, view, projection, viewPos)
+	if ssao != nil {
+		ssao.Apply(r.fullscreenLight, true)
+	}
+	if fog != nil {
+		fog.Apply(r.fullscreenLight, viewPos, true)
+	}
+	gl.BindVertexArray(r.emptyVAO)
+	for i, pass := range lm.passes {
+		if i > 0 && len(pass.dirs) == 0 && len(pass.spots) == 0 {
+			continue
+		}
+		setEnabled(gl.BLEND, i > 0)
+		lm.uploadLights(r.fullscreenLight, lightPass{dirs: pass.dirs, spots: pass.spots}, i)
+		gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	}
+
+	// point lights, only the back faces so the volume is lit even with the
+	// camera inside it
+	gl.Enable(gl.BLEND)
+	gl.Enable(gl.CULL_FACE)
+	gl.CullFace(gl.FRONT)
+	r.volumeLight.Use()
+	r.setLightUniforms(r.volumeLocs, view, projection, viewPos)
+	if ssao != nil {
+		ssao.Apply(r.volumeLight, true)
+	}
+	if fog != nil {
+		fog.Apply(r.volumeLight, viewPos, true)
+	}
+	gl.BindVertexArray(r.sphereVAO)
+	for _, pass := range lm.passes {
+		for _, light := range pass.points {
+			radius := lightRange(light.Color, light.Diffuse, light.Constant, light.Linear, light.Quadratic) * lightVolumeScale
+			model := mgl32.Translate3D(light.Position.Elem()).Mul4(mgl32.Scale3D(radius, radius, radius))
+			gl.UniformMatrix4fv(r.volumeLocs.model, 1, false, &model[0])
+			lm.uploadLights(r.volumeLight, lightPass{points: []*PointLight{light}}, 1)
+			gl.DrawElements(gl.TRIANGLES, r.sphereNumIndices, gl.UNSIGNED_INT, nil)
+		}
+	}
+	gl.BindVertexArray(0)
+
+	for i := 0; i < gTargets; i++ {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + i))
+		gl.BindTexture(gl.TEXTURE_2D, 0)
+	}
+	gl.ActiveTexture(gl.TEXTURE0)
+	if ssao != nil {
+		ssao.Apply(r.volumeLight, false)
+	}
+	state.restore()
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, r.gbuffer.Handle())
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, target.Handle())
+	gl.BlitFramebuffer(0, 0, r.width, r.height, 0, 0, r.width, r.height, gl.DEPTH_BUFFER_BIT, gl.NEAREST)
+	target.Bind()
+}
+
+func (r *DeferredRenderer) setLightUniforms(locs deferredLightLocations, view, projection mgl32.Mat4, viewPos mgl32.Vec3) {
+	gl.UniformMatrix4fv(locs.view, 1, false, &view[0])
+	gl.UniformMatrix4fv(locs.projection, 1, false, &projection[0])
+	gl.Uniform3fv(locs.viewPos, 1, &viewPos[0])
+	gl.Uniform2f(locs.screenSize, float32(r.width), float32(r.height))
+	for i, loc := range locs.targets {
+		gl.Uniform1i(loc, int32(i))
+	}
+}
+
+func (r *DeferredRenderer) Delete() {
+	r.gbuffer.Delete()
+	if r.emptyVAO != 0 {
+		gl.DeleteVertexArrays(1, &r.emptyVAO)
+	}
+	for _, program := range []*gfx.Program{r.geometryProgram, r.fullscreenLight, r.volumeLight} {
+		if program != nil {
+			program.Delete()
+		}
+	}
+}
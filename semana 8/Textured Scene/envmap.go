@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// the environment cubemap goes after the two textures of the models and before
+// the shadow maps
+const envUnit = gl.TEXTURE3
+
+// Cubemap is a color cubemap sampled by the environment materials
+type Cubemap struct {
+	ID   uint32
+	Size int32
+}
+
+// LoadCubemap reads the faces in the GL order +X, -X, +Y, -Y, +Z, -Z, all of
+// them must be square images of the same size. The skybox is loaded with it
+func LoadCubemap(faces [6]string) (*Cubemap, error) {
+	var cubemap Cubemap
+	gl.GenTextures(1, &cubemap.ID)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, cubemap.ID)
+	for i, file := range faces {
+		rgba, err := loadRGBA(file)
+		if err == nil {
+			err = checkCubemapFace(file, rgba.Rect.Size(), cubemap.Size)
+		}
+		if err != nil {
+			gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+			cubemap.Delete()
+			return nil, err
+		}
+		cubemap.Size = int32(rgba.Rect.Size().X)
+		gl.TexImage2D(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), 0, gl.RGBA, cubemap.Size, cubemap.Size,
+			0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba.Pix))
+	}
+	setCubemapParameters()
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+	return &cubemap, nil
+}
+
+// checkCubemapFace tells why a face of size can not go in a cubemap with faces
+// of faceSize, 0 for the first one
+func checkCubemapFace(file string, size image.Point, faceSize int32) error {
+	if size.X != size.Y {
+		return fmt.Errorf("cubemap face %s is %dx%d, it must be square", file, size.X, size.Y)
+	}
+	if faceSize != 0 && int32(size.X) != faceSize {
+		return fmt.Errorf("cubemap face %s is %d pixels wide, the other faces %d", file, size.X, faceSize)
+	}
+	return nil
+}
+
+func loadRGBA(file string) (*image.RGBA, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba, nil
+}
+
+func setCubemapParameters() {
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+}
+
+func (c *Cubemap) Delete() {
+	if c.ID != 0 {
+		gl.DeleteTextures(1, &c.ID)
+	}
+}
+
+// EnvironmentProbe renders the scene seen from Position into a cubemap, every
+// Interval frames so a few probes do not redraw the scene six times each frame
+type EnvironmentProbe struct {
+	Cubemap
+	Position  mgl32.Vec3
+	Interval  int
+	Near, Far float32
+
+	target *Framebuffer
+	frame  int
+}
+
+func NewEnvironmentProbe(position mgl32.Vec3, size int32, interval int) (*EnvironmentProbe, error) {
+	probe := EnvironmentProbe{
+		Cubemap:  Cubemap{Size: size},
+		Position: position,
+		Interval: interval,
+		Near:     0.1,
+		Far:      100,
+	}
+
+	gl.GenTextures(1, &probe.ID)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, probe.ID)
+	for i := 0; i < 6; i++ {
+		gl.TexImage2D(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), 0, gl.RGBA8, size, size, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	}
+	setCubemapParameters()
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+
+	// the faces are attached in turn by Update
+	probe.target = NewFramebuffer(size, size)
+	probe.target.AttachColor(gl.TEXTURE_CUBE_MAP_POSITIVE_X, probe.ID)
+	probe.target.AddDepthBuffer(gl.DEPTH_COMPONENT24)
+	if err := probe.target.Complete(); err != nil {
+		probe.Delete()
+		return nil, err
+	}
+
+	return &probe, nil
+}
+
+// Update redraws the cubemap on the first call and then once every Interval
+// calls, an Interval of 0 or less renders only once. Each face is cleared with
+// the current clear color before drawScene draws it. target is bound again
+// when it returns, nil for the default framebuffer
+func (p *EnvironmentProbe) Update(target *Framebuffer, drawScene func(view, projection mgl32.Mat4, viewPos mgl32.Vec3)) bool {
+	render := p.frame == 0 || (p.Interval > 0 && p.frame%p.Interval == 0)
+	p.frame++
+	if !render {
+		return false
+	}
+
+	projection := mgl32.Perspective(mgl32.DegToRad(90), 1, p.Near, p.Far)
+	p.target.Bind()
+	for i, view := range cubeFaceViews(p.Position) {
+		p.target.SetColor(0, uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), p.ID)
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		drawScene(view, projection, p.Position)
+	}
+	target.Bind()
+	return true
+}
+
+func (p *EnvironmentProbe) Delete() {
+	p.target.Delete()
+	p.Cubemap.Delete()
+}
+
+// EnvironmentMaterial reflects and optionally refracts an environment cubemap,
+// the Fresnel term blends from the surface at normal incidence to the mirror
+// image at grazing angles
+type EnvironmentMaterial struct {
+	Environment *Cubemap
+	Color       mgl32.Vec3
+	// reflectance at normal incidence, about 0.02 for water and ice and close
+	// to 1 for metals
+	Reflectivity float32
+	// ratio of the refractive indices outside and inside the surface, 1/1.31
+	// for ice, 0 makes it opaque
+	RefractionRatio float32
+	// surface under the reflection when it is opaque, it may be nil
+	Texture *gfx.Texture
+	// 0 is opaque, otherwise it must be drawn in a TransparencyPass. The
+	// reflection at grazing angles stays opaque
+	Transparency float32
+}
+
+// EnvironmentRenderer draws models with an EnvironmentMaterial
+type EnvironmentRenderer struct {
+	program *gfx.Program
+	locs    environmentLocations
+}
+
+type environmentLocations struct {
+	model, view, projection, viewPos                       int32
+	environment, color, reflectivity, refractionRatio, tex int32
+	hasTexture, opacity, oitPass                           int32
+}
+
+func NewEnvironmentRenderer() (*EnvironmentRenderer, error) {
+	program, err := newProgramFromFiles("shaders/environment.vert", "shaders/environment.frag")
+	if err != nil {
+		return nil, err
+	}
+	return &EnvironmentRenderer{
+		program: program,
+		locs: environmentLocations{
+			model:           program.GetUniformLocation("model"),
+			view:            program.GetUniformLocation("view"),
+			projection:      program.GetUniformLocation("projection"),
+			viewPos:         program.GetUniformLocation("viewPos"),
+			environment:     program.GetUniformLocation("environment"),
+			color:           program.GetUniformLocation("material.color"),
+			reflectivity:    program.GetUniformLocation("material.reflectivity"),
+			refractionRatio: program.GetUniformLocation("material.refractionRatio"),
+			tex:             program.GetUniformLocation("material.texture"),
+			hasTexture:      program.GetUniformLocation("material.hasTexture"),
+			opacity:         program.GetUniformLocation("material.opacity"),
+			oitPass:         program.GetUniformLocation("oitPass"),
+		},
+	}, nil
+}
+
+// Begin uses the program and sets the camera, Draw may then be called for each model
+func (r *EnvironmentRenderer) Begin(view, projection mgl32.Mat4, viewPos mgl32.Vec3) {
+	r.program.Use()
+	gl.UniformMatrix4fv(r.locs.view, 1, false, &view[0])
+	gl.UniformMatrix4fv(r.locs.projection, 1, false, &projection[0])
+	gl.Uniform3fv(r.locs.viewPos, 1, &viewPos[0])
+	gl.Uniform1i(r.locs.oitPass, 0)
+}
+
+// SetOIT makes the next draws write the outputs of the order independent
+// transparency, see TransparentDraw
+func (r *EnvironmentRenderer) SetOIT(enabled bool) {
+	if enabled {
+		gl.Uniform1i(r.locs.oitPass, 1)
+	} else {
+		gl.Uniform1i(r.locs.oitPass, 0)
+	}
+}
+
+// Draw sets the model matrix and material, then draw binds the vertices and draws them
+func (r *EnvironmentRenderer) Draw(material *EnvironmentMaterial, model mgl32.Mat4, draw func()) {
+	gl.UniformMatrix4fv(r.locs.model, 1, false, &model[0])
+	gl.Uniform3fv(r.locs.color, 1, &material.Color[0])
+	gl.Uniform1f(r.locs.reflectivity, material.Reflectivity)
+	gl.Uniform1f(r.locs.refractionRatio, material.RefractionRatio)
+	gl.Uniform1f(r.locs.opacity, 1-material.Transparency)
+
+	gl.ActiveTexture(envUnit)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, material.Environment.ID)
+	gl.Uniform1i(r.locs.environment, envUnit-gl.TEXTURE0)
+	gl.Uniform1i(r.locs.hasTexture, 0)
+	if material.Texture != nil {
+		material.Texture.Bind(gl.TEXTURE0)
+		material.Texture.SetUniform(r.locs.tex)
+		gl.Uniform1i(r.locs.hasTexture, 1)
+	}
+
+	draw()
+
+	if material.Texture != nil {
+		material.Texture.UnBind()
+	}
+	gl.ActiveTexture(envUnit)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+func (r *EnvironmentRenderer) Delete() {
+	r.program.Delete()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// fog modes, must match fog.glsl
+const (
+	FogNone   = iota
+	FogLinear = iota // from Start to End
+	FogExp    = iota // 1 - e^(-density * distance)
+	FogExp2   = iota // 1 - e^(-(density * distance)^2)
+	FogHeight = iota // exponential, thinner going up from BaseHeight
+)
+
+// Fog hides what is far from the camera behind Color, it is set on every
+// program that includes fog.glsl
+type Fog struct {
+	Mode  int32
+	Color mgl32.Vec3
+	// linear fog
+	Start, End float32
+	// exponential and height fog
+	Density float32
+	// height fog
+	BaseHeight, HeightFalloff float32
+
+	locations map[*gfx.Program]fogLocations
+}
+
+type fogLocations struct {
+	mode, color, eye, start, end, density, baseHeight, heightFalloff int32
+}
+
+// NewFog creates a fog of the given mode with values that suit the scale of
+// the scenes, color is usually the background or the average of the sky
+func NewFog(mode int32, color mgl32.Vec3) *Fog {
+	return &Fog{
+		Mode:          mode,
+		Color:         color,
+		Start:         10,
+		End:           60,
+		Density:       0.04,
+		BaseHeight:    0,
+		HeightFalloff: 0.3,
+		locations:     map[*gfx.Program]fogLocations{},
+	}
+}
+
+// Apply sets the fog seen from eye to program, which must be in use, disabled
+// draws it clear. Models far away like the sky usually disable it
+func (f *Fog) Apply(program *gfx.Program, eye mgl32.Vec3, enabled bool) {
+	locs, ok := f.locations[program]
+	if !ok {
+		locs = fogLocations{
+			mode:          program.GetUniformLocation("fog.mode"),
+			color:         program.GetUniformLocation("fog.color"),
+			eye:           program.GetUniformLocation("fog.eye"),
+			start:         program.GetUniformLocation("fog.start"),
+			end:           program.GetUniformLocation("fog.end"),
+			density:       program.GetUniformLocation("fog.density"),
+			baseHeight:    program.GetUniformLocation("fog.baseHeight"),
+			heightFalloff: program.GetUniformLocation("fog.heightFalloff"),
+		}
+		f.locations[program] = locs
+	}
+
+	if !enabled {
+		gl.Uniform1i(locs.mode, FogNone)
+		return
+	}
+	gl.Uniform1i(locs.mode, f.Mode)
+	gl.Uniform3fv(locs.color, 1, &f.Color[0])
+	gl.Uniform3fv(locs.eye, 1, &eye[0])
+	gl.Uniform1f(locs.start, f.Start)
+	gl.Uniform1f(locs.end, f.End)
+	gl.Uniform1f(locs.density, f.Density)
+	gl.Uniform1f(locs.baseHeight, f.BaseHeight)
+	gl.Uniform1f(locs.heightFalloff, f.HeightFalloff)
+}
+
+// AverageColor returns the mean color of an image file, a fog of the color of
+// the sky texture blends the models into it
+func AverageColor(file string) (mgl32.Vec3, error) {
+	rgba, err := loadRGBA(file)
+	if err != nil {
+		return mgl32.Vec3{}, err
+	}
+	var sum [3]float64
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		sum[0] += float64(rgba.Pix[i])
+		sum[1] += float64(rgba.Pix[i+1])
+		sum[2] += float64(rgba.Pix[i+2])
+	}
+	n := float64(len(rgba.Pix)/4) * 255
+	if n == 0 {
+		return mgl32.Vec3{}, nil
+	}
+	return mgl32.Vec3{float32(sum[0] / n), float32(sum[1] / n), float32(sum[2] / n)}, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+var errFramebuffer = errors.New("FRAMEBUFFER::INCOMPLETE")
+
+// Framebuffer is a framebuffer object with the textures and depth buffer it
+// renders to. NewFramebuffer leaves it bound so the attachments can be added,
+// Complete checks it and unbinds it. A nil *Framebuffer is the default
+// framebuffer of the window, for the passes that draw to a target they are
+// given
+type Framebuffer struct {
+	fbo           uint32
+	textures      []uint32 // color textures created by AddColor, deleted with it
+	depth         uint32   // renderbuffer created by AddDepthBuffer
+	colors        []uint32 // color attachments in use, for DrawBuffers
+	width, height int32
+}
+
+func NewFramebuffer(width, height int32) *Framebuffer {
+	f := Framebuffer{width: width, height: height}
+	gl.GenFramebuffers(1, &f.fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, f.fbo)
+	return &f
+}
+
+// AddColor creates a texture of internalFormat, ex: gl.RGBA16F, and attaches
+// it to the next color attachment. The texture has no mipmaps and clamps to
+// the edge, filter is ex: gl.NEAREST
+func (f *Framebuffer) AddColor(internalFormat int32, format, xtype uint32, filter int32) uint32 {
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, internalFormat, f.width, f.height, 0, format, xtype, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, filter)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, filter)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	f.textures = append(f.textures, texture)
+	f.AttachColor(gl.TEXTURE_2D, texture)
+	return texture
+}
+
+// AttachColor attaches a texture the framebuffer does not own to the next
+// color attachment, target is ex: a face of a cubemap
+func (f *Framebuffer) AttachColor(target, texture uint32) {
+	f.colors = append(f.colors, uint32(gl.COLOR_ATTACHMENT0+len(f.colors)))
+	f.SetColor(len(f.colors)-1, target, texture)
+}
+
+// SetColor replaces the texture of the i-th color attachment of the bound
+// framebuffer, ex: to render each face of a cubemap in turn
+func (f *Framebuffer) SetColor(i int, target, texture uint32) {
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, uint32(gl.COLOR_ATTACHMENT0+i), target, texture, 0)
+}
+
+// AddDepthBuffer creates a renderbuffer of format, ex: gl.DEPTH_COMPONENT24,
+// for the depth test. gl.DEPTH24_STENCIL8 is the format of the default
+// framebuffer, the one to use when the depth is blitted from or to it
+func (f *Framebuffer) AddDepthBuffer(format uint32) {
+	gl.GenRenderbuffers(1, &f.depth)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, f.depth)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, format, f.width, f.height)
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+	attachment := uint32(gl.DEPTH_ATTACHMENT)
+	if format == gl.DEPTH24_STENCIL8 || format == gl.DEPTH32F_STENCIL8 {
+		attachment = gl.DEPTH_STENCIL_ATTACHMENT
+	}
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, attachment, gl.RENDERBUFFER, f.depth)
+}
+
+// AttachDepthLayer attaches a layer of a depth texture array the framebuffer
+// does not own, for the shadow maps
+func (f *Framebuffer) AttachDepthLayer(texture uint32, layer int32) {
+	gl.FramebufferTextureLayer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, texture, 0, layer)
+}
+
+// AttachDepth attaches a depth texture the framebuffer does not own, target is
+// ex: a face of a cubemap
+func (f *Framebuffer) AttachDepth(target, texture uint32) {
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, target, texture, 0)
+}
+
+// Complete sets the draw buffers to the color attachments, none for a depth
+// only framebuffer, and unbinds it. It returns errFramebuffer when it can not
+// be rendered to, the caller deletes it then
+func (f *Framebuffer) Complete() error {
+	if len(f.colors) == 0 {
+		gl.DrawBuffer(gl.NONE)
+		gl.ReadBuffer(gl.NONE)
+	} else {
+		gl.DrawBuffers(int32(len(f.colors)), &f.colors[0])
+	}
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		return errFramebuffer
+	}
+	return nil
+}
+
+// Bind makes the framebuffer the one drawn to, with a viewport that covers it
+func (f *Framebuffer) Bind() {
+	if f == nil {
+		gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+		gl.Viewport(0, 0, width, height)
+		return
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, f.fbo)
+	gl.Viewport(0, 0, f.width, f.height)
+}
+
+// Handle returns the framebuffer object, 0 for the default framebuffer
+func (f *Framebuffer) Handle() uint32 {
+	if f == nil {
+		return 0
+	}
+	return f.fbo
+}
+
+// Texture returns the i-th texture created by AddColor
+func (f *Framebuffer) Texture(i int) uint32 {
+	return f.textures[i]
+}
+
+func (f *Framebuffer) Size() (width, height int32) {
+	return f.width, f.height
+}
+
+func (f *Framebuffer) Delete() {
+	if f == nil {
+		return
+	}
+	if f.fbo != 0 {
+		gl.DeleteFramebuffers(1, &f.fbo)
+	}
+	if len(f.textures) > 0 {
+		gl.DeleteTextures(int32(len(f.textures)), &f.textures[0])
+		f.textures = nil
+	}
+	if f.depth != 0 {
+		gl.DeleteRenderbuffers(1, &f.depth)
+	}
+}
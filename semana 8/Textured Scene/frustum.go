@@ -0,0 +1,149 @@
+package main
+
+import (
+	"git.maze.io/go/math32"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Frustum is the volume seen by a camera as its left, right, bottom, top, near
+// and far planes, (normal, distance) with unit normals pointing inside
+type Frustum [6]mgl32.Vec4
+
+// NewFrustum extracts the planes of viewProjection (Gribb and Hartmann)
+func NewFrustum(viewProjection mgl32.Mat4) Frustum {
+	row := viewProjection.Row
+	f := Frustum{
+		row(3).Add(row(0)),
+		row(3).Sub(row(0)),
+		row(3).Add(row(1)),
+		row(3).Sub(row(1)),
+		row(3).Add(row(2)),
+		row(3).Sub(row(2)),
+	}
+	for i, plane := range f {
+		f[i] = plane.Mul(1 / plane.Vec3().Len())
+	}
+	return f
+}
+
+// SphereVisible is false only when the sphere is entirely outside one of the
+// planes, spheres near the corners may pass without being seen
+func (f *Frustum) SphereVisible(center mgl32.Vec3, radius float32) bool {
+	for _, plane := range f {
+		if plane.Vec3().Dot(center)+plane.W() < -radius {
+			return false
+		}
+	}
+	return true
+}
+
+// BoxVisible is SphereVisible for the axis aligned box from min to max
+func (f *Frustum) BoxVisible(min, max mgl32.Vec3) bool {
+	for _, plane := range f {
+		// the corner farthest along the normal
+		corner := min
+		for i := 0; i < 3; i++ {
+			if plane[i] > 0 {
+				corner[i] = max[i]
+			}
+		}
+		if plane.Vec3().Dot(corner)+plane.W() < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Camera is anything that gives a view matrix
+type Camera interface {
+	GetTransform() mgl32.Mat4
+}
+
+// CameraFrustum returns the frustum seen by camera through projection
+func CameraFrustum(camera Camera, projection mgl32.Mat4) Frustum {
+	return NewFrustum(projection.Mul4(camera.GetTransform()))
+}
+
+// Frustum returns the volume this camera sees through projection
+func (camera *FpsCamera) Frustum(projection mgl32.Mat4) Frustum {
+	return CameraFrustum(camera, projection)
+}
+
+// BoundingSphere encloses a model
+type BoundingSphere struct {
+	Center mgl32.Vec3
+	Radius float32
+}
+
+// BoundingSphereOf returns a sphere around the xyz positions of vertices, the
+// center of their box, which is close enough to the smallest sphere for the
+// geometry of this scene
+func BoundingSphereOf(vertices []float32) BoundingSphere {
+	if len(vertices) < 3 {
+		return BoundingSphere{}
+	}
+	min := mgl32.Vec3{vertices[0], vertices[1], vertices[2]}
+	max := min
+	for i := 3; i+2 < len(vertices); i += 3 {
+		for j := 0; j < 3; j++ {
+			min[j] = math32.Min(min[j], vertices[i+j])
+			max[j] = math32.Max(max[j], vertices[i+j])
+		}
+	}
+	s := BoundingSphere{Center: min.Add(max).Mul(0.5)}
+	for i := 0; i+2 < len(vertices); i += 3 {
+		d := mgl32.Vec3{vertices[i], vertices[i+1], vertices[i+2]}.Sub(s.Center).Len()
+		s.Radius = math32.Max(s.Radius, d)
+	}
+	return s
+}
+
+// Transform returns the sphere around the model moved by model, the radius
+// grows with the largest scale so it stays conservative for uneven scales
+func (s BoundingSphere) Transform(model mgl32.Mat4) BoundingSphere {
+	scale := math32.Max(model.Col(0).Vec3().Len(), math32.Max(model.Col(1).Vec3().Len(), model.Col(2).Vec3().Len()))
+	return BoundingSphere{
+		Center: model.Mul4x1(s.Center.Vec4(1)).Vec3(),
+		Radius: s.Radius * scale,
+	}
+}
+
+// CullStats counts the objects tested by a Culler
+type CullStats struct {
+	Drawn  int
+	Culled int
+}
+
+// Culler tests the bounding volumes of the objects against a Frustum and counts
+// the results, a nil Culler lets everything through, for passes like the
+// shadows that must see what the camera does not
+type Culler struct {
+	Frustum Frustum
+	Stats   CullStats
+}
+
+func NewCuller(viewProjection mgl32.Mat4) *Culler {
+	return &Culler{Frustum: NewFrustum(viewProjection)}
+}
+
+// Visible reports whether the sphere of an object reaches the frustum
+func (c *Culler) Visible(sphere BoundingSphere) bool {
+	if c == nil {
+		return true
+	}
+	if !c.Frustum.SphereVisible(sphere.Center, sphere.Radius) {
+		c.Stats.Culled++
+		return false
+	}
+	c.Stats.Drawn++
+	return true
+}
+
+// Uncounted returns a Culler with the same frustum that does not add to Stats,
+// for the passes that draw again objects already counted this frame
+func (c *Culler) Uncounted() *Culler {
+	if c == nil {
+		return nil
+	}
+	return &Culler{Frustum: c.Frustum}
+}
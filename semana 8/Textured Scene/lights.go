@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"git.maze.io/go/math32"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// max lights of each type, must match the defines in lighting.glsl
+const (
+	maxPointLights = 8
+	maxDirLights   = 4
+	maxSpotLights  = 8
+)
+
+type PointLight struct {
+	Enabled  bool
+	Position mgl32.Vec3
+	Color    mgl32.Vec3
+
+	Ambient  mgl32.Vec3
+	Diffuse  mgl32.Vec3
+	Specular mgl32.Vec3
+
+	// attenuation = 1 / (constant + linear*d + quadratic*d*d)
+	Constant  float32
+	Linear    float32
+	Quadratic float32
+
+	// Shadow makes the light cast shadows when set, up to maxPointShadows lights
+	Shadow *PointShadow
+}
+
+// DirectionalLight lights the whole scene from far away, ex: the moon
+type DirectionalLight struct {
+	Enabled   bool
+	Direction mgl32.Vec3 // direction the light travels, from the light to the scene
+	Color     mgl32.Vec3
+
+	Ambient  mgl32.Vec3
+	Diffuse  mgl32.Vec3
+	Specular mgl32.Vec3
+
+	// Shadow makes the light cast shadows when set, only the first enabled
+	// directional light with a shadow uses it
+	Shadow *DirectionalShadow
+}
+
+// SpotLight is a point light restricted to a cone, full intensity inside the
+// inner angle and fading out to zero at the outer angle
+type SpotLight struct {
+	Enabled   bool
+	Position  mgl32.Vec3
+	Direction mgl32.Vec3
+	Color     mgl32.Vec3
+
+	Ambient  mgl32.Vec3
+	Diffuse  mgl32.Vec3
+	Specular mgl32.Vec3
+
+	Constant  float32
+	Linear    float32
+	Quadratic float32
+
+	InnerAngle float32 // degrees from the direction
+	OuterAngle float32 // degrees from the direction
+}
+
+// NewPointLight creates an enabled point light with a ~50 units range
+func NewPointLight(position, color mgl32.Vec3, ambient mgl32.Vec3, diffuse float32) *PointLight {
+	return &PointLight{
+		Enabled:   true,
+		Position:  position,
+		Color:     color,
+		Ambient:   ambient,
+		Diffuse:   mgl32.Vec3{diffuse, diffuse, diffuse},
+		Specular:  mgl32.Vec3{1, 1, 1},
+		Constant:  1,
+		Linear:    0.09,
+		Quadratic: 0.032,
+	}
+}
+
+type pointLightLocations struct {
+	position, color, ambient, diffuse, specular, constant, linear, quadratic int32
+}
+
+type dirLightLocations struct {
+	direction, color, ambient, diffuse, specular int32
+}
+
+type spotLightLocations struct {
+	pointLightLocations
+	direction, cutOff, outerCutOff int32
+}
+
+type dirShadowLocations struct {
+	light, numCascades, bias, slopeBias, pcfRadius int32
+	lightSpace, splits                             [maxCascades]int32
+	shadowMap                                      int32
+}
+
+type pointShadowLocations struct {
+	light, farPlane, bias, pcfRadius int32
+	shadowMap                        int32
+}
+
+type lightLocations struct {
+	numPointLights, numDirLights, numSpotLights int32
+	lightPass                                   int32
+
+	pointLights []pointLightLocations
+	dirLights   []dirLightLocations
+	spotLights  []spotLightLocations
+
+	dirShadow       dirShadowLocations
+	numPointShadows int32
+	pointShadows    []pointShadowLocations
+}
+
+// LightStats are the light counts of the last frame
+type LightStats struct {
+	Visible int // uploaded to the shader
+	Culled  int // enabled but out of the view
+	Dropped int // visible but over the limit of passes
+	Passes  int
+}
+
+// lightPass holds the lights drawn together, at most as many as the shader holds
+type lightPass struct {
+	points []*PointLight
+	dirs   []*DirectionalLight
+	spots  []*SpotLight
+}
+
+// LightManager keeps every light of the scene and uploads the enabled ones
+// to the programs that include lighting.glsl. There is no limit of lights, when
+// more are visible than the shader holds the scene is drawn again for each
+// group of lights and the results are added
+type LightManager struct {
+	PointLights []*PointLight
+	DirLights   []*DirectionalLight
+	SpotLights  []*SpotLight
+
+	// MaxPasses limits how many times the scene is drawn per frame, 0 means no limit
+	MaxPasses int
+
+	locations map[*gfx.Program]*lightLocations
+	passes    []lightPass
+	stats     LightStats
+	dropped   map[string]int
+}
+
+func NewLightManager() *LightManager {
+	return &LightManager{
+		MaxPasses: 32,
+		locations: map[*gfx.Program]*lightLocations{},
+		dropped:   map[string]int{},
+	}
+}
+
+func (lm *LightManager) AddPointLight(light *PointLight) *PointLight {
+	lm.PointLights = append(lm.PointLights, light)
+	return light
+}
+
+func (lm *LightManager) AddDirectionalLight(light *DirectionalLight) *DirectionalLight {
+	lm.DirLights = append(lm.DirLights, light)
+	return light
+}
+
+func (lm *LightManager) AddSpotLight(light *SpotLight) *SpotLight {
+	lm.SpotLights = append(lm.SpotLights, light)
+	return light
+}
+
+func (lm *LightManager) uniformLocations(program *gfx.Program) *lightLocations {
+	if locs, ok := lm.locations[program]; ok {
+		return locs
+	}
+
+	locs := &lightLocations{
+		numPointLights: program.GetUniformLocation("numPointLights"),
+		numDirLights:   program.GetUniformLocation("numDirLights"),
+		numSpotLights:  program.GetUniformLocation("numSpotLights"),
+		lightPass:      program.GetUniformLocation("lightPass"),
+	}
+	pointLocations := func(name string, i int) pointLightLocations {
+		field := func(f string) int32 {
+			return program.GetUniformLocation(fmt.Sprint(name, "[", i, "].", f))
+		}
+		return pointLightLocations{
+			position:  field("position"),
+			color:     field("lightColor"),
+			ambient:   field("ambient"),
+			diffuse:   field("diffuse"),
+			specular:  field("specular"),
+			constant:  field("constant"),
+			linear:    field("linear"),
+			quadratic: field("quadratic"),
+		}
+	}
+	for i := 0; i < maxPointLights; i++ {
+		locs.pointLights = append(locs.pointLights, pointLocations("pointLights", i))
+	}
+	for i := 0; i < maxDirLights; i++ {
+		field := func(f string) int32 {
+			return program.GetUniformLocation(fmt.Sprint("dirLights[", i, "].", f))
+		}
+		locs.dirLights = append(locs.dirLights, dirLightLocations{
+			direction: field("direction"),
+			color:     field("lightColor"),
+			ambient:   field("ambient"),
+			diffuse:   field("diffuse"),
+			specular:  field("specular"),
+		})
+	}
+	for i := 0; i < maxSpotLights; i++ {
+		field := func(f string) int32 {
+			return program.GetUniformLocation(fmt.Sprint("spotLights[", i, "].", f))
+		}
+		locs.spotLights = append(locs.spotLights, spotLightLocations{
+			pointLightLocations: pointLocations("spotLights", i),
+			direction:           field("direction"),
+			cutOff:              field("cutOff"),
+			outerCutOff:         field("outerCutOff"),
+		})
+	}
+
+	dirShadowField := func(f string) int32 {
+		return program.GetUniformLocation("dirShadow." + f)
+	}
+	locs.dirShadow = dirShadowLocations{
+		light:       dirShadowField("light"),
+		numCascades: dirShadowField("numCascades"),
+		bias:        dirShadowField("bias"),
+		slopeBias:   dirShadowField("slopeBias"),
+		pcfRadius:   dirShadowField("pcfRadius"),
+		shadowMap:   program.GetUniformLocation("dirShadowMap"),
+	}
+	for i := 0; i < maxCascades; i++ {
+		locs.dirShadow.lightSpace[i] = dirShadowField(fmt.Sprint("lightSpace[", i, "]"))
+		locs.dirShadow.splits[i] = dirShadowField(fmt.Sprint("splits[", i, "]"))
+	}
+	locs.numPointShadows = program.GetUniformLocation("numPointShadows")
+	for i := 0; i < maxPointShadows; i++ {
+		field := func(f string) int32 {
+			return program.GetUniformLocation(fmt.Sprint("pointShadows[", i, "].", f))
+		}
+		locs.pointShadows = append(locs.pointShadows, pointShadowLocations{
+			light:     field("light"),
+			farPlane:  field("farPlane"),
+			bias:      field("bias"),
+			pcfRadius: field("pcfRadius"),
+			shadowMap: program.GetUniformLocation(fmt.Sprint("pointShadowMaps[", i, "]")),
+		})
+	}
+
+	lm.locations[program] = locs
+	return locs
+}
+
+// Cull keeps the enabled lights that reach the volume seen by viewProjection
+// and groups them in passes of as many lights as the shader holds
+func (lm *LightManager) Cull(viewProjection mgl32.Mat4) {
+	frustum := NewFrustum(viewProjection)
+	lm.group(func(position mgl32.Vec3, radius float32) bool {
+		return frustum.SphereVisible(position, radius)
+	}, lm.MaxPasses)
+}
+
+// group splits the enabled lights for which visible is true, or all of them
+// when it is nil, in at most maxPasses passes, 0 means no limit
+func (lm *LightManager) group(visible func(position mgl32.Vec3, radius float32) bool, maxPasses int) {
+	lm.stats = LightStats{}
+
+	var points []*PointLight
+	for _, light := range lm.PointLights {
+		if !light.Enabled {
+			continue
+		}
+		if visible != nil && !visible(light.Position, lightRange(light.Color, light.Diffuse,
+			light.Constant, light.Linear, light.Quadratic)) {
+			lm.stats.Culled++
+			continue
+		}
+		points = append(points, light)
+	}
+	var spots []*SpotLight
+	for _, light := range lm.SpotLights {
+		if !light.Enabled {
+			continue
+		}
+		if visible != nil && !visible(light.Position, lightRange(light.Color, light.Diffuse,
+			light.Constant, light.Linear, light.Quadratic)) {
+			lm.stats.Culled++
+			continue
+		}
+		spots = append(spots, light)
+	}
+	var dirs []*DirectionalLight
+	for _, light := range lm.DirLights {
+		if light.Enabled {
+			dirs = append(dirs, light)
+		}
+	}
+
+	passes := (len(points) + maxPointLights - 1) / maxPointLights
+	if spotPasses := (len(spots) + maxSpotLights - 1) / maxSpotLights; spotPasses > passes {
+		passes = spotPasses
+	}
+	if passes == 0 {
+		passes = 1
+	}
+	if maxPasses > 0 && passes > maxPasses {
+		passes = maxPasses
+	}
+
+	droppedPoints, droppedSpots, droppedDirs := 0, 0, 0
+	if len(points) > passes*maxPointLights {
+		droppedPoints = len(points) - passes*maxPointLights
+		points = points[:passes*maxPointLights]
+	}
+	if len(spots) > passes*maxSpotLights {
+		droppedSpots = len(spots) - passes*maxSpotLights
+		spots = spots[:passes*maxSpotLights]
+	}
+	// directional lights light everything, they all go in the first pass
+	if len(dirs) > maxDirLights {
+		droppedDirs = len(dirs) - maxDirLights
+		dirs = dirs[:maxDirLights]
+	}
+	lm.warnDropped("point", droppedPoints, passes*maxPointLights, passes)
+	lm.warnDropped("spot", droppedSpots, passes*maxSpotLights, passes)
+	lm.warnDropped("directional", droppedDirs, maxDirLights, passes)
+
+	lm.passes = lm.passes[:0]
+	for i := 0; i < passes; i++ {
+		var pass lightPass
+		n := maxPointLights
+		if n > len(points) {
+			n = len(points)
+		}
+		pass.points, points = points[:n], points[n:]
+		n = maxSpotLights
+		if n > len(spots) {
+			n = len(spots)
+		}
+		pass.spots, spots = spots[:n], spots[n:]
+		if i == 0 {
+			pass.dirs = dirs
+		}
+		lm.passes = append(lm.passes, pass)
+		lm.stats.Visible += len(pass.points) + len(pass.spots) + len(pass.dirs)
+	}
+	lm.stats.Passes = passes
+	lm.stats.Dropped = droppedPoints + droppedSpots + droppedDirs
+}
+
+// warnDropped logs when the number of dropped lights of a kind changes, a scene
+// that keeps dropping the same lights does not flood the log every frame
+func (lm *LightManager) warnDropped(kind string, dropped, limit, passes int) {
+	if lm.dropped[kind] == dropped {
+		return
+	}
+	lm.dropped[kind] = dropped
+	if dropped > 0 {
+		log.Printf("lights: dropping %d visible %s lights, only %d fit in %d passes, raise MaxPasses or disable lights",
+			dropped, kind, limit, passes)
+	}
+}
+
+// Stats returns the light counts of the last Cull
+func (lm *LightManager) Stats() LightStats {
+	return lm.stats
+}
+
+// Render culls the lights and calls draw once per pass with the lights of the
+// pass uploaded to program, the passes after the first are added on top of it
+// with blending. The blend and depth state are restored at the end
+func (lm *LightManager) Render(program *gfx.Program, viewProjection mgl32.Mat4, draw func()) {
+	lm.Cull(viewProjection)
+
+	state := saveRenderState()
+
+	for i := range lm.passes {
+		if i == 1 {
+			// later passes only add light to the pixels that won the first one
+			gl.Enable(gl.BLEND)
+			gl.BlendFunc(gl.ONE, gl.ONE)
+			gl.DepthFunc(gl.EQUAL)
+			gl.DepthMask(false)
+		}
+		lm.uploadLights(program, lm.passes[i], i)
+		draw()
+	}
+
+	if len(lm.passes) > 1 {
+		state.restore()
+	}
+}
+
+// Upload sets every enabled light to program in a single pass without culling,
+// lights that do not fit in the shader are dropped with a warning
+func (lm *LightManager) Upload(program *gfx.Program) {
+	lm.group(nil, 1)
+	lm.uploadLights(program, lm.passes[0], 0)
+}
+
+// uploadLights sets the lights of pass to program, which must be in use, and
+// binds the shadow maps of the lights that cast them. index is the lightPass
+// uniform, only the pass 0 adds the unlit colors
+func (lm *LightManager) uploadLights(program *gfx.Program, pass lightPass, index int) {
+	locs := lm.uniformLocations(program)
+	gl.Uniform1i(locs.lightPass, int32(index))
+
+	// the shadow samplers always point to their own units, samplers of different
+	// types sharing a unit make the draw calls fail even if they are not sampled
+	gl.Uniform1i(locs.dirShadow.shadowMap, dirShadowUnit-gl.TEXTURE0)
+	gl.Uniform1i(locs.dirShadow.light, -1)
+	for i, loc := range locs.pointShadows {
+		gl.Uniform1i(loc.shadowMap, int32(pointShadowUnit0-gl.TEXTURE0+i))
+	}
+
+	numPointShadows := 0
+	for i, light := range pass.points {
+		uploadPointLight(locs.pointLights[i], light.Position, light.Color, light.Ambient, light.Diffuse,
+			light.Specular, light.Constant, light.Linear, light.Quadratic)
+		if light.Shadow != nil && numPointShadows < maxPointShadows {
+			uploadPointShadow(locs.pointShadows[numPointShadows], numPointShadows, i, light.Shadow)
+			numPointShadows++
+		}
+	}
+	gl.Uniform1i(locs.numPointLights, int32(len(pass.points)))
+	gl.Uniform1i(locs.numPointShadows, int32(numPointShadows))
+
+	dirShadow := false
+	for i, light := range pass.dirs {
+		if light.Shadow != nil && !dirShadow {
+			uploadDirShadow(locs.dirShadow, i, light.Shadow)
+			dirShadow = true
+		}
+		loc := locs.dirLights[i]
+		direction := light.Direction.Normalize()
+		gl.Uniform3fv(loc.direction, 1, &direction[0])
+		gl.Uniform3fv(loc.color, 1, &light.Color[0])
+		gl.Uniform3fv(loc.ambient, 1, &light.Ambient[0])
+		gl.Uniform3fv(loc.diffuse, 1, &light.Diffuse[0])
+		gl.Uniform3fv(loc.specular, 1, &light.Specular[0])
+	}
+	gl.Uniform1i(locs.numDirLights, int32(len(pass.dirs)))
+
+	for i, light := range pass.spots {
+		loc := locs.spotLights[i]
+		uploadPointLight(loc.pointLightLocations, light.Position, light.Color, light.Ambient, light.Diffuse,
+			light.Specular, light.Constant, light.Linear, light.Quadratic)
+		direction := light.Direction.Normalize()
+		gl.Uniform3fv(loc.direction, 1, &direction[0])
+		// the shader compares cosines so it does not need acos per fragment
+		gl.Uniform1f(loc.cutOff, math32.Cos(mgl32.DegToRad(light.InnerAngle)))
+		gl.Uniform1f(loc.outerCutOff, math32.Cos(mgl32.DegToRad(light.OuterAngle)))
+	}
+	gl.Uniform1i(locs.numSpotLights, int32(len(pass.spots)))
+}
+
+func uploadPointLight(loc pointLightLocations, position, color, ambient, diffuse, specular mgl32.Vec3,
+	constant, linear, quadratic float32) {
+	gl.Uniform3fv(loc.position, 1, &position[0])
+	gl.Uniform3fv(loc.color, 1, &color[0])
+	gl.Uniform3fv(loc.ambient, 1, &ambient[0])
+	gl.Uniform3fv(loc.diffuse, 1, &diffuse[0])
+	gl.Uniform3fv(loc.specular, 1, &specular[0])
+	gl.Uniform1f(loc.constant, constant)
+	gl.Uniform1f(loc.linear, linear)
+	gl.Uniform1f(loc.quadratic, quadratic)
+}
+
+func uploadDirShadow(loc dirShadowLocations, light int, shadow *DirectionalShadow) {
+	gl.Uniform1i(loc.light, int32(light))
+	gl.Uniform1i(loc.numCascades, int32(shadow.Cascades()))
+	gl.Uniform1f(loc.bias, shadow.Settings.Bias)
+	gl.Uniform1f(loc.slopeBias, shadow.Settings.SlopeBias)
+	gl.Uniform1i(loc.pcfRadius, shadow.Settings.PCFRadius)
+	for i, split := range shadow.Settings.CascadeSplits {
+		gl.UniformMatrix4fv(loc.lightSpace[i], 1, false, &shadow.lightSpace[i][0])
+		gl.Uniform1f(loc.splits[i], split)
+	}
+	gl.ActiveTexture(dirShadowUnit)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, shadow.depthArray)
+}
+
+func uploadPointShadow(loc pointShadowLocations, index, light int, shadow *PointShadow) {
+	gl.Uniform1i(loc.light, int32(light))
+	gl.Uniform1f(loc.farPlane, shadow.Far)
+	gl.Uniform1f(loc.bias, shadow.Settings.Bias)
+	gl.Uniform1i(loc.pcfRadius, shadow.Settings.PCFRadius)
+	gl.ActiveTexture(uint32(pointShadowUnit0 + index))
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, shadow.cubemap)
+}
+
+// lightRange returns the distance where a light adds less than 1/256 of its
+// color, past it the light can be skipped without visible changes
+func lightRange(color, diffuse mgl32.Vec3, constant, linear, quadratic float32) float32 {
+	brightness := float32(0)
+	for i := range color {
+		brightness = math32.Max(brightness, color[i]*diffuse[i])
+	}
+	c := constant - 256*brightness
+	if quadratic == 0 {
+		if linear == 0 {
+			return math32.Inf(1)
+		}
+		return -c / linear
+	}
+	return (-linear + math32.Sqrt(linear*linear-4*quadratic*c)) / (2 * quadratic)
+}
+
+// renderState is the blend, depth and culling state changed by the light passes
+type renderState struct {
+	blend, depthTest, depthMask, cullFace         bool
+	srcRGB, dstRGB, srcAlpha, dstAlpha, depthFunc int32
+	cullMode                                      int32
+}
+
+func saveRenderState() renderState {
+	var state renderState
+	state.blend = gl.IsEnabled(gl.BLEND)
+	state.depthTest = gl.IsEnabled(gl.DEPTH_TEST)
+	state.cullFace = gl.IsEnabled(gl.CULL_FACE)
+	gl.GetBooleanv(gl.DEPTH_WRITEMASK, &state.depthMask)
+	gl.GetIntegerv(gl.BLEND_SRC_RGB, &state.srcRGB)
+	gl.GetIntegerv(gl.BLEND_DST_RGB, &state.dstRGB)
+	gl.GetIntegerv(gl.BLEND_SRC_ALPHA, &state.srcAlpha)
+	gl.GetIntegerv(gl.BLEND_DST_ALPHA, &state.dstAlpha)
+	gl.GetIntegerv(gl.DEPTH_FUNC, &state.depthFunc)
+	gl.GetIntegerv(gl.CULL_FACE_MODE, &state.cullMode)
+	return state
+}
+
+func (s renderState) restore() {
+	setEnabled(gl.BLEND, s.blend)
+	setEnabled(gl.DEPTH_TEST, s.depthTest)
+	setEnabled(gl.CULL_FACE, s.cullFace)
+	gl.DepthMask(s.depthMask)
+	gl.BlendFuncSeparate(uint32(s.srcRGB), uint32(s.dstRGB), uint32(s.srcAlpha), uint32(s.dstAlpha))
+	gl.DepthFunc(uint32(s.depthFunc))
+	gl.CullFace(uint32(s.cullMode))
+}
+
+func setEnabled(capability uint32, enabled bool) {
+	if enabled {
+		gl.Enable(capability)
+	} else {
+		gl.Disable(capability)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"runtime"
 	"unsafe"
 
@@ -16,9 +18,16 @@ import (
 )
 
 const (
-	width  = 1080
-	height = 720
-	title  = "Textured scene and geometry shader"
+	width          = 1080
+	height         = 720
+	title          = "Textured scene and geometry shader"
+	numFairyLights = 48
+	pondRadius     = 4
+	numOrnaments   = 12
+	mirrorWidth    = 8
+	mirrorHeight   = 6
+	// must match MAX_SPRITES in particles.geom
+	maxSnowSprites = 8
 )
 
 var (
@@ -50,35 +59,26 @@ var (
 		{0.792, 0.792, 0.725},
 		{1, 1, 1},
 	}
-	snowFallRef = []mgl32.Vec3{
+	discoBallPosition = mgl32.Vec3{-0.2, 9.9, 0}
+	// slightly above the snow so they do not fight for the same depth
+	pondPosition = mgl32.Vec3{9, 0.02, 7}
+	// center of the mirror wall, its bottom edge rests on the snow
+	mirrorPosition = mgl32.Vec3{-12, mirrorHeight / 2, 0}
+	mirrorNormal   = mgl32.Vec3{1, 0, 0}
+	snowFallRef    = []mgl32.Vec3{
 		{0, 20, 0},
 		{1, 1, 1},
 	}
 )
 
-func createParticleVAO(points []float32) (uint32, uint32) {
-
-	var VAO uint32
-	gl.GenVertexArrays(1, &VAO)
-	gl.BindVertexArray(VAO)
-
-	var VBO uint32
-	gl.GenBuffers(1, &VBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, VBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(points)*4, gl.Ptr(points), gl.STATIC_DRAW)
-
-	// size of one whole vertex (sum of attrib sizes)
-	var stride int32 = 3*4 + 4*4
-	var offset int = 0
-
-	gl.EnableVertexAttribArray(0)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, stride, gl.PtrOffset(offset))
-	gl.EnableVertexAttribArray(1)
-	offset += 3 * 4
-	gl.VertexAttribPointer(1, 4, gl.FLOAT, false, stride, gl.PtrOffset(offset))
-	gl.BindVertexArray(0)
-
-	return VAO, VBO
+// createParticleVAO returns the vertex array of the snow and the stream buffer
+// its points, a position and a color each, are written to every frame
+func createParticleVAO(numParticles int) (*VertexArray, *StreamBuffer) {
+	VAO, stream, err := NewStreamVertexArray(particleLayout, numParticles)
+	if err != nil {
+		panic(err)
+	}
+	return VAO, stream
 }
 
 func turnStar(im *win.InputManager, colorNum int, changeColor bool) (mgl32.Vec3, int, bool) {
@@ -90,7 +90,6 @@ func turnStar(im *win.InputManager, colorNum int, changeColor bool) (mgl32.Vec3,
 	if im.IsActive(win.PLAYER_SWITCH) {
 		if changeColor {
 			colorNum = (colorNum + 1) % 2
-			pointLightColors[3] = colors[colorNum]
 			return colors[colorNum], colorNum, false
 		}
 		return colors[colorNum], colorNum, changeColor
@@ -100,110 +99,103 @@ func turnStar(im *win.InputManager, colorNum int, changeColor bool) (mgl32.Vec3,
 
 }
 
-func createVAO(vertices, normals, tCoords []float32, indices []uint32) uint32 {
-
-	var VAO uint32
-	gl.GenVertexArrays(1, &VAO)
-	gl.BindVertexArray(VAO)
-
-	var VBO uint32
-	gl.GenBuffers(1, &VBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, VBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*4, gl.Ptr(vertices), gl.STATIC_DRAW)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(0)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
-	var NBO uint32
-	gl.GenBuffers(1, &NBO)
-	gl.BindBuffer(gl.ARRAY_BUFFER, NBO)
-	gl.BufferData(gl.ARRAY_BUFFER, len(normals)*4, gl.Ptr(normals), gl.STATIC_DRAW)
-	gl.VertexAttribPointer(1, 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
-	gl.EnableVertexAttribArray(1)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
-	if len(tCoords) > 0 {
-		var TBO uint32
-		gl.GenBuffers(1, &TBO)
-		gl.BindBuffer(gl.ARRAY_BUFFER, TBO)
-		gl.BufferData(gl.ARRAY_BUFFER, len(tCoords)*4, gl.Ptr(tCoords), gl.STATIC_DRAW)
-		gl.VertexAttribPointer(2, 2, gl.FLOAT, false, 2*4, gl.PtrOffset(0))
-		gl.EnableVertexAttribArray(2)
-		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-	}
-
-	var EBO uint32
-	gl.GenBuffers(1, &EBO)
-	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, EBO)
-	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-
-	gl.BindVertexArray(0)
-
-	return VAO
+// keyToggle flips its value each time key is pressed
+type keyToggle struct {
+	key   glfw.Key
+	value bool
+	down  bool
 }
 
-func pointLightsUniformLocations(program *gfx.Program) [][]int32 {
-	uniformLocations := [][]int32{}
-	for i := 0; i < len(pointLightPositions); i++ {
-		uniformLocations = append(uniformLocations,
-			[]int32{program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].position")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].ambient")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].diffuse")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].specular")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].constant")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].linear")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].quadratic")),
-				program.GetUniformLocation(fmt.Sprint("pointLights[", i, "].lightColor"))})
-	}
-	return uniformLocations
+func (t *keyToggle) Update() bool {
+	pressed := glfw.GetCurrentContext().GetKey(t.key) == glfw.Press
+	if pressed && !t.down {
+		t.value = !t.value
+	}
+	t.down = pressed
+	return t.value
 }
 
-func programLoop(window *win.Window) error {
+// the snow atlas is packed from the flake images of snowFlakesDir, the packed
+// image and sprites are saved next to them and read back on the next runs
+const (
+	snowFlakesDir   = "textures/snowflakes"
+	snowAtlasImage  = "textures/snowflakes_atlas.png"
+	snowAtlasSprite = "textures/snowflakes_atlas.json"
+)
 
-	// Shaders and textures
-	vertShader, err := gfx.NewShaderFromFile("shaders/phong_ml.vert", gl.VERTEX_SHADER)
-	if err != nil {
-		return err
-	}
-	fragShader, err := gfx.NewShaderFromFile("shaders/phong_ml.frag", gl.FRAGMENT_SHADER)
-	if err != nil {
-		return err
+var snowFlakes = []string{"flake1", "flake2", "flake3", "flake4"}
+
+// loadSnowAtlas reads the saved snow atlas, packing and saving it again first
+// when it is missing or older than any of the flake images
+func loadSnowAtlas() (*Atlas, error) {
+	built, err := os.Stat(snowAtlasSprite)
+	stale := err != nil
+	for _, name := range snowFlakes {
+		if stale {
+			break
+		}
+		flake, err := os.Stat(filepath.Join(snowFlakesDir, name+".png"))
+		stale = err != nil || flake.ModTime().After(built.ModTime())
 	}
 
-	program, err := gfx.NewProgram(vertShader, fragShader)
-	if err != nil {
-		return err
+	if stale {
+		builder := NewAtlasBuilder(2, 512)
+		for _, name := range snowFlakes {
+			if err := builder.AddFile(name, filepath.Join(snowFlakesDir, name+".png")); err != nil {
+				return nil, err
+			}
+		}
+		atlas, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+		if err := atlas.Save(snowAtlasImage, snowAtlasSprite); err != nil {
+			return nil, err
+		}
 	}
-	defer program.Delete()
+	return LoadAtlas(snowAtlasImage, snowAtlasSprite)
+}
 
-	sourceVertShader, err := gfx.NewShaderFromFile("shaders/source.vert", gl.VERTEX_SHADER)
+// createVAO returns the vertex array of a mesh of meshLayout, tCoords may be
+// empty for the meshes without texture. It panics when the data does not match
+func createVAO(vertices, normals, tCoords []float32, indices []uint32) *VertexArray {
+	layout, data := meshLayout, [][]float32{vertices, normals, tCoords}
+	if len(tCoords) == 0 {
+		layout, data = meshLayout.Without("texCoord"), data[:2]
+	}
+	VAO, err := NewVertexArray(layout, indices, data...)
 	if err != nil {
-		return err
+		panic(err)
 	}
+	return VAO
+}
+
+func programLoop(window *win.Window) error {
 
-	sourceFragShader, err := gfx.NewShaderFromFile("shaders/source.frag", gl.FRAGMENT_SHADER)
+	// Shaders and textures
+	program, err := newProgramFromFiles("shaders/phong_ml.vert", "shaders/phong_ml.frag")
 	if err != nil {
 		return err
 	}
+	defer program.Delete()
 
 	// special shader program so that lights themselves are not affected by lighting
-	sourceProgram, err := gfx.NewProgram(sourceVertShader, sourceFragShader)
+	sourceProgram, err := newProgramFromFiles("shaders/source.vert", "shaders/source.frag")
 	if err != nil {
 		return err
 	}
 
 	// particle shaders
 
-	particlesVS, err := gfx.NewShaderFromFile("shaders/particles.vert", gl.VERTEX_SHADER)
+	particlesVS, err := newShaderFromFile("shaders/particles.vert", gl.VERTEX_SHADER)
 	if err != nil {
 		return err
 	}
-	particlesFS, err := gfx.NewShaderFromFile("shaders/particles.frag", gl.FRAGMENT_SHADER)
+	particlesFS, err := newShaderFromFile("shaders/particles.frag", gl.FRAGMENT_SHADER)
 	if err != nil {
 		return err
 	}
-	particlesGS, err := gfx.NewShaderFromFile("shaders/particles.geom", gl.GEOMETRY_SHADER)
+	particlesGS, err := newShaderFromFile("shaders/particles.geom", gl.GEOMETRY_SHADER)
 	if err != nil {
 		return err
 	}
@@ -217,7 +209,6 @@ func programLoop(window *win.Window) error {
 	// Ensure that triangles that are "behind" others do not draw over top of them
 	gl.Enable(gl.DEPTH_TEST)
 	gl.DepthFunc(gl.LESS)
-	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
 	// Base model
 	model := mgl32.Ident4()
 
@@ -227,7 +218,6 @@ func programLoop(window *win.Window) error {
 	projectUniformLocation := program.GetUniformLocation("projection")
 	objectColorUniformLocation := program.GetUniformLocation("objectColor")
 	viewPosUniformLocation := program.GetUniformLocation("viewPos")
-	numLightsUniformLocation := program.GetUniformLocation("numLights")
 	textureUniformLocation := program.GetUniformLocation("texSampler")
 	texture2UniformLocation := program.GetUniformLocation("texSampler2")
 
@@ -242,8 +232,8 @@ func programLoop(window *win.Window) error {
 	particlesProjectUL := particlesProgram.GetUniformLocation("projection")
 	particlesSizeUL := particlesProgram.GetUniformLocation("particle_size")
 	particlesTextureUL := particlesProgram.GetUniformLocation("tex0")
-
-	pointLightsUniformLocations := pointLightsUniformLocations(program)
+	particlesSpritesUL := particlesProgram.GetUniformLocation("sprites")
+	particlesNumSpritesUL := particlesProgram.GetUniformLocation("numSprites")
 
 	// creates camara
 	eye := mgl32.Vec3{0, 10, 15}
@@ -256,11 +246,27 @@ func programLoop(window *win.Window) error {
 	gl.UniformMatrix4fv(projectUniformLocation, 1, false, &projectTransform[0])
 
 	// Textures
-	particlTexture, err := gfx.NewTextureFromFile("textures/snowflakes.png",
+	snowAtlas, err := loadSnowAtlas()
+	if err != nil {
+		return err
+	}
+	particlTexture, err := gfx.NewTextureFromFile(snowAtlasImage,
 		gl.CLAMP_TO_EDGE, gl.CLAMP_TO_EDGE)
 	if err != nil {
 		panic(err.Error())
 	}
+	// each flake is one of these sprites of the atlas, picked at random. The
+	// first flake is left out as it always was
+	snowSprites, err := snowAtlas.UVRects("flake2", "flake3", "flake4")
+	if err != nil {
+		return err
+	}
+	if len(snowSprites) > maxSnowSprites {
+		return fmt.Errorf("%d snow sprites, at most %d", len(snowSprites), maxSnowSprites)
+	}
+	particlesProgram.Use()
+	gl.Uniform4fv(particlesSpritesUL, int32(len(snowSprites)), &snowSprites[0][0])
+	gl.Uniform1i(particlesNumSpritesUL, int32(len(snowSprites)))
 
 	snowTexture, err := gfx.NewTextureFromFile("textures/snow.jpg",
 		gl.CLAMP_TO_EDGE, gl.CLAMP_TO_EDGE)
@@ -294,8 +300,8 @@ func programLoop(window *win.Window) error {
 	if err != nil {
 		panic(err.Error())
 	}
-	starsTexture, err := gfx.NewTextureFromFile("textures/sky.jpg",
-		gl.CLAMP_TO_EDGE, gl.CLAMP_TO_EDGE)
+	mirrorTexture, err := gfx.NewTextureFromFile("textures/mirror.jpeg",
+		gl.REPEAT, gl.REPEAT)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -305,6 +311,16 @@ func programLoop(window *win.Window) error {
 	backgroundColor := mgl32.Vec3{0.2, 0.2, 0.2}
 	lightColor := mgl32.Vec3{1, 0.95, 0.75}
 
+	// Fog, low mist of the color of the sky so the models far away blend into
+	// it, NewFog(FogExp, backgroundColor) would follow the background instead
+	skyColor, err := AverageColor("textures/sky.jpg")
+	if err != nil {
+		return err
+	}
+	fog := NewFog(FogHeight, skyColor)
+	fog.Density = 0.05
+	fog.HeightFalloff = 0.25
+
 	// Settings
 	particle_size := 1
 	numParticles := 200
@@ -317,6 +333,63 @@ func programLoop(window *win.Window) error {
 
 	particles := NewParticles(numParticles, *particlesColor, *particlesPos, particlesVel, particlesMinLife, particlesMaxLife, particlesAmplitude)
 
+	// Lights
+	ambientColor := backgroundColor.Add(mgl32.Vec3{0.2, 0.2, 0.2}).Mul(0.5)
+	lights := NewLightManager()
+	treeLights := []*PointLight{
+		lights.AddPointLight(NewPointLight(pointLightPositions[0], pointLightColors[0], ambientColor, 5)),
+		lights.AddPointLight(NewPointLight(pointLightPositions[1], pointLightColors[1], ambientColor, 5)),
+	}
+	// the moon is far enough to light the scene with parallel rays, its values are
+	// about what the old point light reached at the center of the scene
+	moonLight := lights.AddDirectionalLight(&DirectionalLight{
+		Enabled:   true,
+		Direction: pointLightPositions[2].Mul(-1),
+		Color:     pointLightColors[2],
+		Ambient:   ambientColor.Mul(0.03),
+		Diffuse:   mgl32.Vec3{0.8, 0.8, 0.8},
+		Specular:  mgl32.Vec3{0.03, 0.03, 0.03},
+	})
+	// fairy lights in a spiral around the tree, more than the shader holds at
+	// once so the light manager draws the tree in several passes
+	fairyColors := []mgl32.Vec3{pointLightColorsRef[0], pointLightColorsRef[1], {1, 0.84, 0.4}}
+	var fairyLights []*PointLight
+	for i := 0; i < numFairyLights; i++ {
+		t := float32(i) / numFairyLights
+		angle := t * 6 * 2 * math32.Pi
+		radius := 3.5*(1-t) + 0.5
+		position := mgl32.Vec3{radius * math32.Cos(angle), 3.5 + t*6, radius * math32.Sin(angle)}
+		light := NewPointLight(position, fairyColors[i%len(fairyColors)], mgl32.Vec3{}, 1)
+		// short range, they only light the leaves next to them
+		light.Linear = 0.7
+		light.Quadratic = 1.8
+		fairyLights = append(fairyLights, lights.AddPointLight(light))
+	}
+	starLight := lights.AddPointLight(NewPointLight(pointLightPositions[3], pointLightColors[3], ambientColor, 10))
+	shootingStarLight := lights.AddPointLight(NewPointLight(pointLightPositions[4], pointLightColors[4], ambientColor, 25))
+
+	// Shadows, the moon and the star cast them
+	shadows, err := NewShadowRenderer()
+	if err != nil {
+		return err
+	}
+	defer shadows.Delete()
+	moonLight.Shadow, err = NewDirectionalShadow(DefaultShadowSettings())
+	if err != nil {
+		return err
+	}
+	defer moonLight.Shadow.Delete()
+	starShadowSettings := DefaultShadowSettings()
+	starShadowSettings.Resolution = 1024
+	starShadowSettings.Bias = 0.005
+	starLight.Shadow, err = NewPointShadow(starShadowSettings, 30)
+	if err != nil {
+		return err
+	}
+	defer starLight.Shadow.Delete()
+	// F3 shows the nearest cascade of the moon shadow map in a corner
+	showShadowMap := keyToggle{key: glfw.KeyF3}
+
 	// Uncomment to turn on polygon mode
 	//gl.PolygonMode(gl.FRONT_AND_BACK, gl.LINE)
 
@@ -328,17 +401,175 @@ func programLoop(window *win.Window) error {
 	verticesSpere, normalsSpere, tCoordsSpere, indicesSpere := Sphere(15, 15)
 	verticesCone, normalsCone, tCoordsCone, indicesCone := Cone(15, 15, 10)
 	verticesCylinder, normalsCylinder, tCoordsCylinder, indicesCylinder := Cylinder(15, 15, 10)
+	verticesPond, normalsPond, tCoordsPond, indicesPond := Circle(32, 4)
+	verticesMirror, normalsMirror, tCoordsMirror, indicesMirror := Square(1, 1, 1)
 
 	// models
 	logModel := mgl32.Ident4()
 
 	// Buffers
-	particleVAO, particleVBO := createParticleVAO(particles.points)
+	particleVAO, particleStream := createParticleVAO(numParticles)
+	defer particleVAO.Delete()
+	defer particleStream.Delete()
 	cylinderVAO := createVAO(verticesCylinder, normalsCylinder, tCoordsCylinder, indicesCylinder)
 	planeVAO := createVAO(verticesPlane, normalsPlane, tCoordsPlane, indicesPlane)
 	coneVAO := createVAO(verticesCone, normalsCone, tCoordsCone, indicesCone)
 	lightVAO := createVAO(verticesSpere, normalsSpere, tCoordsSpere, indicesSpere)
-	skyVAO := createVAO(Cube(80, 80, 80))
+	pondVAO := createVAO(verticesPond, normalsPond, tCoordsPond, indicesPond)
+	mirrorVAO := createVAO(verticesMirror, normalsMirror, tCoordsMirror, indicesMirror)
+	for _, vao := range []*VertexArray{cylinderVAO, planeVAO, coneVAO, lightVAO, pondVAO, mirrorVAO} {
+		defer vao.Delete()
+	}
+
+	// the stars around the scene, darkened to the background color
+	skyCubemap, err := LoadCubemap([6]string{
+		"textures/skybox/right.jpg", "textures/skybox/left.jpg",
+		"textures/skybox/top.jpg", "textures/skybox/bottom.jpg",
+		"textures/skybox/front.jpg", "textures/skybox/back.jpg",
+	})
+	if err != nil {
+		return err
+	}
+	defer skyCubemap.Delete()
+	skybox, err := NewSkybox(skyCubemap)
+	if err != nil {
+		return err
+	}
+	defer skybox.Delete()
+	skybox.Tint = backgroundColor
+
+	// bounds of the meshes, the models are skipped when they leave the frustum
+	planeBounds := BoundingSphereOf(verticesPlane)
+	sphereBounds := BoundingSphereOf(verticesSpere)
+	coneBounds := BoundingSphereOf(verticesCone)
+	cylinderBounds := BoundingSphereOf(verticesCylinder)
+	pondBounds := BoundingSphereOf(verticesPond)
+	mirrorBounds := BoundingSphereOf(verticesMirror)
+
+	// F8 draws the frame in HDR and runs the post processing over it, F9
+	// turns the bloom on and off. postprocess.json sets the order of the
+	// effects and their parameters
+	hdrTarget := NewFramebuffer(width, height)
+	hdrTarget.AddColor(gl.RGBA16F, gl.RGBA, gl.FLOAT, gl.NEAREST)
+	// same format as the default framebuffer, the passes blit the depth to it
+	hdrTarget.AddDepthBuffer(gl.DEPTH24_STENCIL8)
+	if err := hdrTarget.Complete(); err != nil {
+		hdrTarget.Delete()
+		return err
+	}
+	defer hdrTarget.Delete()
+	postChain, err := NewDefaultPostChain("textures/lut_winter.png")
+	if err != nil {
+		return err
+	}
+	defer postChain.Delete()
+	postConfig, err := LoadPostChainConfig("postprocess.json")
+	if err != nil {
+		return err
+	}
+	if err := postChain.Configure(postConfig); err != nil {
+		return err
+	}
+	usePost := keyToggle{key: glfw.KeyF8, value: true}
+	useBloom := keyToggle{key: glfw.KeyF9, value: postChain.Effect(EffectBloom).Enabled()}
+
+	// Reflections, the disco ball and the ice pond see the scene from their
+	// center, the ball spins and the lights blink so it is updated more often
+	environment, err := NewEnvironmentRenderer()
+	if err != nil {
+		return err
+	}
+	defer environment.Delete()
+	discoProbe, err := NewEnvironmentProbe(discoBallPosition, 256, 2)
+	if err != nil {
+		return err
+	}
+	defer discoProbe.Delete()
+	pondProbe, err := NewEnvironmentProbe(pondPosition.Add(mgl32.Vec3{0, 0.1, 0}), 256, 8)
+	if err != nil {
+		return err
+	}
+	defer pondProbe.Delete()
+	discoMaterial := EnvironmentMaterial{
+		Environment:  &discoProbe.Cubemap,
+		Reflectivity: 0.6,
+		Texture:      discoBall,
+	}
+	iceMaterial := EnvironmentMaterial{
+		Environment:     &pondProbe.Cubemap,
+		Color:           mgl32.Vec3{0.85, 0.95, 1},
+		Reflectivity:    0.02,
+		RefractionRatio: 1 / 1.31,
+		Transparency:    0.35,
+	}
+	// glass baubles hanging along the spiral of fairy lights, they reflect what
+	// the disco ball sees
+	var ornaments []mgl32.Vec3
+	var ornamentMaterials []EnvironmentMaterial
+	for i := 0; i < numOrnaments; i++ {
+		t := (float32(i) + 0.5) / numOrnaments
+		angle := t*6*2*math32.Pi + math32.Pi
+		radius := 3.2*(1-t) + 0.6
+		ornaments = append(ornaments, mgl32.Vec3{radius * math32.Cos(angle), 3.3 + t*6, radius * math32.Sin(angle)})
+		ornamentMaterials = append(ornamentMaterials, EnvironmentMaterial{
+			Environment:  &discoProbe.Cubemap,
+			Color:        fairyColors[i%len(fairyColors)],
+			Reflectivity: 0.08,
+			Transparency: 0.6,
+		})
+	}
+
+	// a mosaic mirror wall facing the tree, its reflection at half resolution
+	reflections, err := NewReflectionRenderer()
+	if err != nil {
+		return err
+	}
+	defer reflections.Delete()
+	mirrorReflection, err := NewPlanarReflection(PlaneFromPoint(mirrorPosition, mirrorNormal), 0.5)
+	if err != nil {
+		return err
+	}
+	defer mirrorReflection.Delete()
+	mirrorSurface := ReflectiveSurface{
+		Reflection:   mirrorReflection,
+		Color:        mgl32.Vec3{0.9, 0.9, 0.95},
+		Reflectivity: 0.8,
+		Distortion:   0.002,
+		Texture:      mirrorTexture,
+	}
+	// the square lies on the ground, standing it up turns its normal to +X
+	mirrorModel := mgl32.Translate3D(mirrorPosition.Elem()).
+		Mul4(mgl32.HomogRotate3DZ(mgl32.DegToRad(-90))).
+		Mul4(mgl32.Scale3D(mirrorHeight, 1, mirrorWidth))
+
+	// F2 switches between the forward and the deferred renderer
+	deferred, err := NewDeferredRenderer(width, height, lightVAO.ID, int32(len(indicesSpere)))
+	if err != nil {
+		return err
+	}
+	defer deferred.Delete()
+	useDeferred := keyToggle{key: glfw.KeyF2}
+
+	// F4 turns off the ambient occlusion
+	ssao, err := NewSSAO(DefaultSSAOSettings())
+	if err != nil {
+		return err
+	}
+	defer ssao.Delete()
+	useSSAO := keyToggle{key: glfw.KeyF4, value: true}
+
+	// F6 switches the glass and ice from sorted to order independent transparency
+	transparency, err := NewTransparencyPass()
+	if err != nil {
+		return err
+	}
+	defer transparency.Delete()
+	useOIT := keyToggle{key: glfw.KeyF6}
+
+	// F7 shows in the title how many models the camera frustum let through and
+	// the draw calls and state changes of the lit models in every pass
+	showCullStats := keyToggle{key: glfw.KeyF7}
+	nextStatsTime := 0.0
 	// Scene and animation always needs to be after the model and buffers initialization
 	animationCtl := gfx.NewAnimationManager()
 	lightColor, numColor, changeColor := turnStar(window.InputManager(), 0, true)
@@ -348,27 +579,27 @@ func programLoop(window *win.Window) error {
 	change := false
 	animationCtl.AddContunuousAnimation(func() {
 
-		pointLightPositions[0] = mgl32.Vec3{r * math32.Cos(float32(animationCtl.GetAngle())), 8, r * math32.Sin(float32(animationCtl.GetAngle()))}
-		pointLightPositions[1] = mgl32.Vec3{-r * math32.Cos(float32(animationCtl.GetAngle())), 8, -r * math32.Sin(float32(animationCtl.GetAngle()))}
+		treeLights[0].Position = mgl32.Vec3{r * math32.Cos(float32(animationCtl.GetAngle())), 8, r * math32.Sin(float32(animationCtl.GetAngle()))}
+		treeLights[1].Position = mgl32.Vec3{-r * math32.Cos(float32(animationCtl.GetAngle())), 8, -r * math32.Sin(float32(animationCtl.GetAngle()))}
 
 		if count%freq == 0 {
 			change = !change
 		}
-		for index := 0; index < len(pointLightColors)-3; index++ {
-			if change {
-				pointLightColors[index] = pointLightColorsRef[index]
-
-			} else {
-				pointLightColors[index] = mgl32.Vec3{0, 0, 0}
-			}
-
+		// tree lights blink
+		for index, light := range treeLights {
+			light.Enabled = change
+			light.Color = pointLightColorsRef[index]
+		}
+		// fairy lights blink in turns
+		for index, light := range fairyLights {
+			light.Enabled = change == (index%2 == 0)
 		}
 		count++
 
 	})
 
 	animationCtl.AddAnimation(func(t float32) {
-		pointLightPositions[4] = mgl32.BezierCurve3D(t, bezierPoints)
+		shootingStarLight.Position = mgl32.BezierCurve3D(t, bezierPoints)
 	}, 2)
 
 	animationCtl.AddAnimation(func(t float32) {
@@ -377,11 +608,128 @@ func programLoop(window *win.Window) error {
 		}
 	}, 2)
 
+	// the lit models go through a queue that binds each texture and vertex
+	// array once for all the models sharing it
+	planeMesh := Mesh{VAO: planeVAO.ID, Count: int32(len(indicesPlane))}
+	cylinderMesh := Mesh{VAO: cylinderVAO.ID, Count: int32(len(indicesCylinder))}
+	coneMesh := Mesh{VAO: coneVAO.ID, Count: int32(len(indicesCone))}
+	snowMaterial := Material{Textures: []*gfx.Texture{snowTexture}}
+	logMaterial := Material{Textures: []*gfx.Texture{logTexture}}
+	leavesMaterial := Material{Textures: []*gfx.Texture{leavesTexture, decoratorLeavesTexture}}
+	sceneQueue := NewRenderQueue()
+
+	// drawModels draws the models lit by the phong program that culler lets
+	// through, the shadow passes call it with their own model location, no
+	// texture locations and a nil culler
+	drawModels := func(modelLoc, texLoc, tex2Loc int32, culler *Culler) {
+		sceneQueue.Reset()
+		submit := func(mesh *Mesh, bounds BoundingSphere, material *Material, transform mgl32.Mat4) {
+			if culler.Visible(bounds.Transform(transform)) {
+				sceneQueue.Submit(mesh, material, transform)
+			}
+		}
+
+		submit(&planeMesh, planeBounds, &snowMaterial, model)
+
+		// log
+		logModelTransform := logModel.Mul4(mgl32.Scale3D(1, 3, 1))
+		submit(&cylinderMesh, cylinderBounds, &logMaterial, logModelTransform)
+		// leave 1
+		leaveModelTranslate := logModelTransform.Mul4(mgl32.Scale3D(4, 1, 4).Mul4(mgl32.Translate3D(0, 1, 0)))
+		leaveOneModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(10), mgl32.Vec3{0, 0, 1})).Mul4(mgl32.Scale3D(1, 1.5, 1))
+		submit(&coneMesh, coneBounds, &leavesMaterial, leaveOneModel)
+		// leave 2
+		leaveModelTranslate = leaveModelTranslate.Mul4(mgl32.Translate3D(0, 0.7, 0)).Mul4(mgl32.Scale3D(0.8, 1, 0.8))
+		leaveTwoModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(-6), mgl32.Vec3{0, 0, 1})).Mul4(mgl32.Scale3D(1, 1.3, 1))
+		submit(&coneMesh, coneBounds, &leavesMaterial, leaveTwoModel)
+		// leave 3
+		leaveModelTranslate = leaveModelTranslate.Mul4(mgl32.Translate3D(0, 0.6, 0)).Mul4(mgl32.Scale3D(0.8, 1, 0.8))
+		leaveThreeModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(5), mgl32.Vec3{0, 0, 1}))
+		submit(&coneMesh, coneBounds, &leavesMaterial, leaveThreeModel)
+
+		sceneQueue.Execute(DrawLocations{Model: modelLoc, Textures: []int32{texLoc, tex2Loc}})
+	}
+
+	// drawLit draws the models lit by the lights with the forward renderer,
+	// occluded only for the camera the ssao was computed for. The models are
+	// counted by culler in the first light pass only
+	drawLit := func(view, projection mgl32.Mat4, viewPos mgl32.Vec3, occluded bool, culler *Culler) {
+		program.Use()
+		ssao.Apply(program, occluded)
+		fog.Apply(program, viewPos, true)
+		gl.UniformMatrix4fv(viewUniformLocation, 1, false, &view[0])
+		gl.UniformMatrix4fv(projectUniformLocation, 1, false, &projection[0])
+
+		gl.Uniform3fv(viewPosUniformLocation, 1, &viewPos[0])
+		gl.Uniform3f(objectColorUniformLocation, objectColor.X(), objectColor.Y(), objectColor.Z())
+		// gl.Uniform3f(lightColorUniformLocation, lightColor.X(), lightColor.Y(), lightColor.Z())
+
+		//luces and models, once per group of visible lights
+		passCuller := culler
+		lights.Render(program, projection.Mul4(view), func() {
+			drawModels(modelUniformLocation, textureUniformLocation, texture2UniformLocation, passCuller)
+			passCuller = culler.Uncounted()
+		})
+	}
+
+	// drawSources draws the unlit moon and shooting star that culler lets
+	// through, and the sky
+	drawSources := func(view, projection mgl32.Mat4, viewPos mgl32.Vec3, culler *Culler) {
+		// obj is colored, light have the same color
+		sourceProgram.Use()
+		fog.Apply(sourceProgram, viewPos, true)
+		gl.UniformMatrix4fv(projectSourceUniformLocation, 1, false, &projection[0])
+		gl.UniformMatrix4fv(viewSourceUniformLocation, 1, false, &view[0])
+		cubeM := mgl32.Ident4()
+		cubeM = cubeM.Mul4(mgl32.Translate3D(pointLightPositions[2].Elem())).Mul4(mgl32.Scale3D(3, 3, 3))
+		if culler.Visible(sphereBounds.Transform(cubeM)) {
+			moonTexture.Bind(gl.TEXTURE0)
+			moonTexture.SetUniform(texSampler3SourceUniformLocation)
+			gl.BindVertexArray(lightVAO.ID)
+			gl.Uniform3f(objectColorSourceUniformLocation, pointLightColors[2].X(), pointLightColors[2].Y(), pointLightColors[2].Z())
+			gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &cubeM[0])
+			gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+			moonTexture.UnBind()
+			gl.BindVertexArray(0)
+		}
+
+		shootingModel := model.Mul4(mgl32.Translate3D(shootingStarLight.Position.Elem())).Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())))
+		if culler.Visible(sphereBounds.Transform(shootingModel)) {
+			gl.BindVertexArray(lightVAO.ID)
+			gl.Uniform3f(objectColorSourceUniformLocation, pointLightColorsRef[4].X(), pointLightColorsRef[4].Y(), pointLightColorsRef[4].Z())
+			gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &shootingModel[0])
+			gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+			gl.BindVertexArray(0)
+		}
+
+		//Sky box, without fog, the tint already has its color
+		skybox.Draw(view, projection)
+	}
+
+	// drawReflected is what the environment probes and the mirror see, the
+	// reflective models themselves are left out. They cull with their own
+	// frustum and are not counted
+	drawReflected := func(view, projection mgl32.Mat4, viewPos mgl32.Vec3) {
+		culler := NewCuller(projection.Mul4(view))
+		drawLit(view, projection, viewPos, false, culler)
+		drawSources(view, projection, viewPos, culler)
+	}
+
 	animationCtl.Init() // always needs to be before the main loop in order to get correct times
 	// main loop
 	for !window.ShouldClose() {
 		window.StartFrame()
 
+		// the frame is drawn to the HDR target of the post processing when
+		// it is on, nil is the default framebuffer
+		var target *Framebuffer
+		posted := usePost.Update()
+		if posted {
+			target = hdrTarget
+		}
+		postChain.SetEnabled(EffectBloom, useBloom.Update())
+		target.Bind()
+
 		// background color
 		gl.ClearColor(backgroundColor.X(), backgroundColor.Y(), backgroundColor.Z(), 1.)
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
@@ -391,6 +739,8 @@ func programLoop(window *win.Window) error {
 		particles.Update(float32(animationCtl.GetElapsed()), *particlesPos, particlesVel)
 		camera.Update(window.SinceLastFrame())
 		camTransform := camera.GetTransform()
+		culler := &Culler{Frustum: camera.Frustum(projectTransform)}
+		sceneQueue.ResetStats()
 
 		if window.InputManager().IsActive(win.PLAYER_UP) {
 			if freq <= 3000 {
@@ -402,165 +752,137 @@ func programLoop(window *win.Window) error {
 			}
 		}
 		lightColor, numColor, changeColor = turnStar(window.InputManager(), numColor, changeColor)
+		starLight.Color = lightColor
 
 		// You shall draw here
-		program.Use()
-		gl.UniformMatrix4fv(viewUniformLocation, 1, false, &camTransform[0])
-		gl.UniformMatrix4fv(projectUniformLocation, 1, false, &projectTransform[0])
+		shadows.Render(target, lights, camTransform, fov, float32(width)/height, 0.1, func(modelLoc int32) {
+			drawModels(modelLoc, -1, -1, nil)
+		})
+
+		discoProbe.Update(target, drawReflected)
+		pondProbe.Update(target, drawReflected)
+		camPosition := camera.GetPosition()
+		// the mirror pass is skipped with the mirror, counted when it is drawn
+		if culler.Uncounted().Visible(mirrorBounds.Transform(mirrorModel)) {
+			mirrorReflection.Render(target, camTransform, projectTransform, camPosition, drawReflected)
+		}
 
-		gl.Uniform3fv(viewPosUniformLocation, 1, &eye[0])
-		gl.Uniform3f(objectColorUniformLocation, objectColor.X(), objectColor.Y(), objectColor.Z())
-		// gl.Uniform3f(lightColorUniformLocation, lightColor.X(), lightColor.Y(), lightColor.Z())
-		gl.Uniform1i(numLightsUniformLocation, int32(len(pointLightPositions)))
-
-		//luces
-		for index, pointLightPosition := range pointLightPositions {
-
-			if index == 2 || index == 4 {
-				gl.Uniform3fv(pointLightsUniformLocations[index][0], 1, &pointLightPosition[0])
-				gl.Uniform3f(pointLightsUniformLocations[index][1], (backgroundColor.X()+0.2)*0.5, (backgroundColor.Y()+0.2)*0.5, (backgroundColor.Z()+0.2)*0.5)
-				gl.Uniform3f(pointLightsUniformLocations[index][2], 25, 25, 25)
-				gl.Uniform3f(pointLightsUniformLocations[index][3], 1., 1., 1.)
-				gl.Uniform1f(pointLightsUniformLocations[index][4], 1.)
-				gl.Uniform1f(pointLightsUniformLocations[index][5], 0.09)
-				gl.Uniform1f(pointLightsUniformLocations[index][6], 0.032)
-				gl.Uniform3f(pointLightsUniformLocations[index][7], pointLightColors[index].X(), pointLightColors[index].Y(), pointLightColors[index].Z())
-			} else if index == 3 {
-				gl.Uniform3fv(pointLightsUniformLocations[index][0], 1, &pointLightPosition[0])
-				gl.Uniform3f(pointLightsUniformLocations[index][1], (backgroundColor.X()+0.2)*0.5, (backgroundColor.Y()+0.2)*0.5, (backgroundColor.Z()+0.2)*0.5)
-				gl.Uniform3f(pointLightsUniformLocations[index][2], 10, 10, 10)
-				gl.Uniform3f(pointLightsUniformLocations[index][3], 1., 1., 1.)
-				gl.Uniform1f(pointLightsUniformLocations[index][4], 1.)
-				gl.Uniform1f(pointLightsUniformLocations[index][5], 0.09)
-				gl.Uniform1f(pointLightsUniformLocations[index][6], 0.032)
-				gl.Uniform3f(pointLightsUniformLocations[index][7], pointLightColors[index].X(), pointLightColors[index].Y(), pointLightColors[index].Z())
-			} else {
-				gl.Uniform3fv(pointLightsUniformLocations[index][0], 1, &pointLightPosition[0])
-				gl.Uniform3f(pointLightsUniformLocations[index][1], (backgroundColor.X()+0.2)*0.5, (backgroundColor.Y()+0.2)*0.5, (backgroundColor.Z()+0.2)*0.5)
-				gl.Uniform3f(pointLightsUniformLocations[index][2], 5, 5, 5)
-				gl.Uniform3f(pointLightsUniformLocations[index][3], 1., 1., 1.)
-				gl.Uniform1f(pointLightsUniformLocations[index][4], 1.)
-				gl.Uniform1f(pointLightsUniformLocations[index][5], 0.09)
-				gl.Uniform1f(pointLightsUniformLocations[index][6], 0.032)
-				gl.Uniform3f(pointLightsUniformLocations[index][7], pointLightColors[index].X(), pointLightColors[index].Y(), pointLightColors[index].Z())
+		occluded := useSSAO.Update()
+		if useDeferred.Update() {
+			deferred.Geometry(target, camTransform, projectTransform, objectColor, func(modelLoc, texLoc, tex2Loc int32) {
+				drawModels(modelLoc, texLoc, tex2Loc, culler)
+			})
+			var occlusion *SSAO
+			if occluded {
+				position, normal := deferred.GeometryTextures()
+				ssao.Compute(target, position, normal, camTransform, projectTransform)
+				occlusion = ssao
 			}
-
+			deferred.Light(target, lights, camTransform, projectTransform, camPosition, occlusion, fog)
+		} else {
+			if occluded {
+				ssao.Render(target, camTransform, projectTransform, func(modelLoc int32) {
+					drawModels(modelLoc, -1, -1, culler.Uncounted())
+				})
+			}
+			drawLit(camTransform, projectTransform, camPosition, occluded, culler)
+		}
+		drawSources(camTransform, projectTransform, camPosition, culler)
+
+		// reflective models, the disco ball takes the color of the star
+		environment.Begin(camTransform, projectTransform, camPosition)
+		discoMaterial.Color = lightColor
+		starModel := model.Mul4(mgl32.Translate3D(discoBallPosition.Elem())).Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())))
+		if culler.Visible(sphereBounds.Transform(starModel)) {
+			environment.Draw(&discoMaterial, starModel, func() {
+				gl.BindVertexArray(lightVAO.ID)
+				gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+				gl.BindVertexArray(0)
+			})
 		}
 
-		// render models
-		gl.BindVertexArray(planeVAO)
-		snowTexture.Bind(gl.TEXTURE0)
-		snowTexture.SetUniform(textureUniformLocation)
-
-		boxModel := model
-
-		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &boxModel[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesPlane))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		snowTexture.UnBind()
-		gl.BindVertexArray(0)
-
-		// log
-		logModelTransform := logModel.Mul4(mgl32.Scale3D(1, 3, 1))
-		gl.BindVertexArray(cylinderVAO)
-		logTexture.Bind(gl.TEXTURE0)
-		logTexture.SetUniform(textureUniformLocation)
-		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &logModelTransform[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesCylinder))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		logTexture.UnBind()
-		gl.BindVertexArray(0)
-		// leave 1
-		leaveModelTranslate := logModelTransform.Mul4(mgl32.Scale3D(4, 1, 4).Mul4(mgl32.Translate3D(0, 1, 0)))
-		leaveOneModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(10), mgl32.Vec3{0, 0, 1})).Mul4(mgl32.Scale3D(1, 1.5, 1))
-		gl.BindVertexArray(coneVAO)
-		leavesTexture.Bind(gl.TEXTURE0)
-		leavesTexture.SetUniform(textureUniformLocation)
-		decoratorLeavesTexture.Bind(gl.TEXTURE1)
-		decoratorLeavesTexture.SetUniform(texture2UniformLocation)
-		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &leaveOneModel[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesCone))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-
-		// leave 2
-		leaveModelTranslate = leaveModelTranslate.Mul4(mgl32.Translate3D(0, 0.7, 0)).Mul4(mgl32.Scale3D(0.8, 1, 0.8))
-		leaveTwoModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(-6), mgl32.Vec3{0, 0, 1})).Mul4(mgl32.Scale3D(1, 1.3, 1))
+		if culler.Visible(mirrorBounds.Transform(mirrorModel)) {
+			reflections.Begin(camTransform, projectTransform, camPosition, float32(glfw.GetTime()))
+			reflections.Draw(&mirrorSurface, mirrorModel, func() {
+				gl.BindVertexArray(mirrorVAO.ID)
+				gl.DrawElements(gl.TRIANGLES, int32(len(indicesMirror)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+				gl.BindVertexArray(0)
+			})
+		}
 
-		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &leaveTwoModel[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesCone))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		// leave 3
-		leaveModelTranslate = leaveModelTranslate.Mul4(mgl32.Translate3D(0, 0.6, 0)).Mul4(mgl32.Scale3D(0.8, 1, 0.8))
-		leaveThreeModel := leaveModelTranslate.Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(5), mgl32.Vec3{0, 0, 1}))
+		// transparent models, the ice, the ornaments and the snow
+		pondModel := mgl32.Translate3D(pondPosition.Elem()).Mul4(mgl32.Scale3D(pondRadius, 1, pondRadius))
+		if culler.Visible(pondBounds.Transform(pondModel)) {
+			transparency.Add(TransparentDraw{Center: pondPosition, Blend: BlendAlpha, Draw: func(oit bool) {
+				environment.Begin(camTransform, projectTransform, camPosition)
+				environment.SetOIT(oit)
+				environment.Draw(&iceMaterial, pondModel, func() {
+					gl.BindVertexArray(pondVAO.ID)
+					gl.DrawElements(gl.TRIANGLES, int32(len(indicesPond)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+					gl.BindVertexArray(0)
+				})
+			}})
+		}
+		for i := range ornaments {
+			material := &ornamentMaterials[i]
+			ornamentModel := mgl32.Translate3D(ornaments[i].Elem()).Mul4(mgl32.Scale3D(0.3, 0.3, 0.3))
+			if !culler.Visible(sphereBounds.Transform(ornamentModel)) {
+				continue
+			}
+			transparency.Add(TransparentDraw{Center: ornaments[i], Blend: BlendAlpha, Draw: func(oit bool) {
+				environment.Begin(camTransform, projectTransform, camPosition)
+				environment.SetOIT(oit)
+				environment.Draw(material, ornamentModel, func() {
+					gl.BindVertexArray(lightVAO.ID)
+					gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+					gl.BindVertexArray(0)
+				})
+			}})
+		}
 
-		gl.UniformMatrix4fv(modelUniformLocation, 1, false, &leaveThreeModel[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesCone))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		decoratorLeavesTexture.UnBind()
-		leavesTexture.UnBind()
-		gl.BindVertexArray(0)
+		//Particles
+		if culler.Visible(particles.Bounds()) {
+			transparency.Add(TransparentDraw{Center: *particlesPos, Blend: BlendAdditive, Draw: func(oit bool) {
+				particlesProgram.Use()
+				gl.UniformMatrix4fv(particlesProjectUL, 1, false, &projectTransform[0])
+				gl.UniformMatrix4fv(particlesViewUL, 1, false, &camTransform[0])
+				gl.UniformMatrix4fv(particlesModelUL, 1, false, &model[0])
+				fog.Apply(particlesProgram, camPosition, true)
+
+				first := particleStream.Write(particles.points)
+				gl.BindVertexArray(particleVAO.ID)
+
+				gl.Uniform1f(particlesSizeUL, float32(particle_size))
+				particlTexture.Bind(gl.TEXTURE0)
+				particlTexture.SetUniform(particlesTextureUL)
+				gl.DrawArrays(gl.POINTS, first, int32(numParticles))
+				particlTexture.UnBind()
+				gl.BindVertexArray(0)
+			}})
+		}
 
-		// obj is colored, light have the same color
-		sourceProgram.Use()
-		gl.UniformMatrix4fv(projectSourceUniformLocation, 1, false, &projectTransform[0])
-		gl.UniformMatrix4fv(viewSourceUniformLocation, 1, false, &camTransform[0])
-		moonTexture.Bind(gl.TEXTURE0)
-		moonTexture.SetUniform(texSampler3SourceUniformLocation)
-		gl.BindVertexArray(lightVAO)
+		transparency.UseOIT = useOIT.Update()
+		transparency.Render(target, camTransform)
 
-		cubeM := mgl32.Ident4()
-		cubeM = cubeM.Mul4(mgl32.Translate3D(pointLightPositions[2].Elem())).Mul4(mgl32.Scale3D(3, 3, 3))
-		gl.Uniform3f(objectColorSourceUniformLocation, pointLightColors[2].X(), pointLightColors[2].Y(), pointLightColors[2].Z())
-		gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &cubeM[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-
-		moonTexture.UnBind()
-		gl.BindVertexArray(0)
-		discoBall.Bind(gl.TEXTURE0)
-		discoBall.SetUniform(texSampler3SourceUniformLocation)
-		gl.BindVertexArray(lightVAO)
-
-		gl.Uniform3f(objectColorSourceUniformLocation, lightColor.X(), lightColor.Y(), lightColor.Z())
-		starModel := model.Mul4(mgl32.Translate3D(-0.2, 9.9, 0)).Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())))
-		gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &starModel[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		discoBall.UnBind()
-		gl.BindVertexArray(0)
-
-		gl.BindVertexArray(lightVAO)
-		gl.Uniform3f(objectColorSourceUniformLocation, pointLightColorsRef[4].X(), pointLightColorsRef[4].Y(), pointLightColorsRef[4].Z())
-		shootingModel := model.Mul4(mgl32.Translate3D(pointLightPositions[4].Elem())).Mul4(mgl32.Scale3D(0.5, 0.5, 0.5)).Mul4(mgl32.HomogRotate3DY(float32(animationCtl.GetAngle())))
-		gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &shootingModel[0])
-		gl.DrawElements(gl.TRIANGLES, int32(len(indicesSpere))*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		gl.BindVertexArray(0)
-
-		//Sky box
-		gl.BindVertexArray(skyVAO)
-		starsTexture.Bind(gl.TEXTURE0)
-		starsTexture.SetUniform(texSampler3SourceUniformLocation)
-		gl.Uniform3f(objectColorSourceUniformLocation, backgroundColor.X(), backgroundColor.Y(), backgroundColor.Z())
-		skyRotate := model
-		gl.UniformMatrix4fv(modelSourceUniformLocation, 1, false, &skyRotate[0])
-		gl.DrawElements(gl.TRIANGLES, 6*6, gl.UNSIGNED_INT, unsafe.Pointer(nil))
-		starsTexture.UnBind()
-		gl.BindVertexArray(0)
+		if posted {
+			postChain.Apply(hdrTarget, nil)
+		}
 
-		//Particles
-		particlesProgram.Use()
-		gl.UniformMatrix4fv(particlesProjectUL, 1, false, &projectTransform[0])
-		gl.UniformMatrix4fv(particlesViewUL, 1, false, &camTransform[0])
-		gl.UniformMatrix4fv(particlesModelUL, 1, false, &model[0])
-
-		gl.BindVertexArray(particleVAO)
-		gl.BindBuffer(gl.ARRAY_BUFFER, particleVBO)
-		gl.BufferData(gl.ARRAY_BUFFER, len(particles.points)*4, gl.Ptr(particles.points), gl.STATIC_DRAW)
-
-		gl.Uniform1f(particlesSizeUL, float32(particle_size))
-		particlTexture.Bind(gl.TEXTURE0)
-		particlTexture.SetUniform(particlesTextureUL)
-		gl.DepthMask(false)
-		gl.Enable(gl.BLEND)
-		gl.DrawArrays(gl.POINTS, 0, int32(numParticles))
-		gl.DepthMask(true)
-		gl.Disable(gl.BLEND)
-		particlTexture.UnBind()
-		gl.BindVertexArray(0)
+		if showShadowMap.Update() {
+			shadows.DrawDebug(moonLight.Shadow, 0)
+		}
 
+		if showCullStats.Update() {
+			if glfw.GetTime() >= nextStatsTime {
+				nextStatsTime = glfw.GetTime() + 0.5
+				stats := sceneQueue.Stats()
+				glfw.GetCurrentContext().SetTitle(fmt.Sprintf("%s - drawn %d, culled %d, %d draw calls, %d state changes",
+					title, culler.Stats.Drawn, culler.Stats.Culled, stats.DrawCalls, stats.StateChanges))
+			}
+		} else if nextStatsTime != 0 {
+			nextStatsTime = 0
+			glfw.GetCurrentContext().SetTitle(title)
+		}
 	}
 
 	return nil
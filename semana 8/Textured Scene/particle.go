@@ -77,3 +77,18 @@ func (p *Particles) Update(dT float32, position, velocity mgl32.Vec3) {
 		}
 	}
 }
+
+// Bounds returns a sphere around every place a particle can reach in its life
+func (p *Particles) Bounds() BoundingSphere {
+	drift := p.velocity.Mul(p.maxLife)
+	min := p.position.Sub(mgl32.Vec3{p.amplitude, p.amplitude / 2.5, p.amplitude})
+	max := p.position.Add(mgl32.Vec3{p.amplitude, 0, p.amplitude})
+	for i := 0; i < 3; i++ {
+		if drift[i] < 0 {
+			min[i] += drift[i]
+		} else {
+			max[i] += drift[i]
+		}
+	}
+	return BoundingSphere{Center: min.Add(max).Mul(0.5), Radius: max.Sub(min).Len() / 2}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// names of the post effects, used by PostChainConfig
+const (
+	EffectBloom        = "bloom"
+	EffectToneMapping  = "tonemapping"
+	EffectColorGrading = "colorgrading"
+	EffectVignette     = "vignette"
+	EffectGamma        = "gamma"
+	EffectFXAA         = "fxaa"
+)
+
+// NewToneMappingEffect maps HDR colors to [0, 1], params: exposure, aces
+func NewToneMappingEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectToneMapping, "shaders/post_tonemapping.frag", map[string][]float32{
+		"exposure": {1},
+		"aces":     {0},
+	})
+}
+
+// NewGammaEffect encodes linear colors, params: gamma
+func NewGammaEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectGamma, "shaders/post_gamma.frag", map[string][]float32{
+		"gamma": {2.2},
+	})
+}
+
+// NewColorGradingEffect grades colors through the LUT of lutFile, a strip of
+// lutSize slices of lutSize x lutSize texels with blue growing by slice.
+// params: strength
+func NewColorGradingEffect(lutFile string, lutSize int) (*ShaderEffect, error) {
+	lut, err := loadLUT(lutFile, lutSize)
+	if err != nil {
+		return nil, err
+	}
+	effect, err := NewShaderEffect(EffectColorGrading, "shaders/post_colorgrading.frag", map[string][]float32{
+		"lutSize":  {float32(lutSize)},
+		"strength": {1},
+	})
+	if err != nil {
+		gl.DeleteTextures(1, &lut)
+		return nil, err
+	}
+	effect.Textures["lut"] = lut
+	effect.owned = append(effect.owned, lut)
+	return effect, nil
+}
+
+// loadLUT uploads a color grading strip as it is, without mipmaps that would
+// blend the slices
+func loadLUT(file string, size int) (uint32, error) {
+	img, err := loadRGBA(file)
+	if err != nil {
+		return 0, err
+	}
+	if img.Rect.Dx() != size*size || img.Rect.Dy() != size {
+		return 0, fmt.Errorf("lut %s is %dx%d, a %d slice strip is %dx%d",
+			file, img.Rect.Dx(), img.Rect.Dy(), size, size*size, size)
+	}
+
+	var lut uint32
+	gl.GenTextures(1, &lut)
+	gl.BindTexture(gl.TEXTURE_2D, lut)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, int32(size*size), int32(size), 0,
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	return lut, nil
+}
+
+// NewVignetteEffect darkens the screen borders, params: radius, softness, strength
+func NewVignetteEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectVignette, "shaders/post_vignette.frag", map[string][]float32{
+		"radius":   {0.75},
+		"softness": {0.45},
+		"strength": {0.5},
+	})
+}
+
+// NewFXAAEffect smooths aliased edges, it expects gamma encoded colors so it
+// runs last, params: spanMax, reduceMul, reduceMin
+func NewFXAAEffect() (*ShaderEffect, error) {
+	return NewShaderEffect(EffectFXAA, "shaders/post_fxaa.frag", map[string][]float32{
+		"spanMax":   {8},
+		"reduceMul": {1.0 / 8},
+		"reduceMin": {1.0 / 128},
+	})
+}
+
+// BloomEffect makes the bright pixels glow: it extracts them into a half size
+// buffer, blurs it a few times and adds it back over the source
+type BloomEffect struct {
+	enabled    bool
+	Iterations int
+
+	extract *ShaderEffect
+	blur    *ShaderEffect
+	combine *ShaderEffect
+	buffers [2]*Framebuffer
+}
+
+// NewBloomEffect params: threshold, intensity, iterations
+func NewBloomEffect() (*BloomEffect, error) {
+	b := BloomEffect{enabled: true, Iterations: 5}
+	var err error
+	b.extract, err = NewShaderEffect(EffectBloom+"-extract", "shaders/post_bloom_extract.frag", map[string][]float32{
+		"threshold": {1},
+	})
+	if err != nil {
+		b.Delete()
+		return nil, err
+	}
+	b.blur, err = NewShaderEffect(EffectBloom+"-blur", "shaders/post_bloom_blur.frag", nil)
+	if err != nil {
+		b.Delete()
+		return nil, err
+	}
+	b.combine, err = NewShaderEffect(EffectBloom+"-combine", "shaders/post_bloom_combine.frag", map[string][]float32{
+		"intensity": {1},
+	})
+	if err != nil {
+		b.Delete()
+		return nil, err
+	}
+
+	for i := range b.buffers {
+		b.buffers[i] = NewFramebuffer((width+1)/2, (height+1)/2)
+		b.buffers[i].AddColor(gl.RGBA16F, gl.RGBA, gl.FLOAT, gl.LINEAR)
+		if err := b.buffers[i].Complete(); err != nil {
+			b.Delete()
+			return nil, err
+		}
+	}
+	return &b, nil
+}
+
+func (b *BloomEffect) Name() string {
+	return EffectBloom
+}
+
+func (b *BloomEffect) Enabled() bool {
+	return b.enabled
+}
+
+func (b *BloomEffect) SetEnabled(enabled bool) {
+	b.enabled = enabled
+}
+
+func (b *BloomEffect) SetParam(name string, values ...float32) {
+	switch name {
+	case "threshold":
+		b.extract.SetParam(name, values...)
+	case "intensity":
+		b.combine.SetParam(name, values...)
+	case "iterations":
+		if len(values) > 0 {
+			b.Iterations = int(values[0])
+		}
+	}
+}
+
+func (b *BloomEffect) Apply(chain *PostChain, src, dst *Framebuffer) {
+	b.extract.Apply(chain, src, b.buffers[0])
+	for i := 0; i < b.Iterations; i++ {
+		b.blur.SetParam("direction", 1, 0)
+		b.blur.Apply(chain, b.buffers[0], b.buffers[1])
+		b.blur.SetParam("direction", 0, 1)
+		b.blur.Apply(chain, b.buffers[1], b.buffers[0])
+	}
+
+	b.combine.Textures["bloom"] = b.buffers[0].Texture(0)
+	b.combine.Apply(chain, src, dst)
+}
+
+func (b *BloomEffect) Delete() {
+	for _, effect := range []*ShaderEffect{b.extract, b.blur, b.combine} {
+		if effect != nil {
+			effect.Delete()
+		}
+	}
+	for _, buffer := range b.buffers {
+		buffer.Delete()
+	}
+}
+
+// NewDefaultPostChain builds a chain with bloom, tone mapping, color grading
+// through the 16 slice LUT of lutFile, vignette, gamma and FXAA, in the order
+// they usually run
+func NewDefaultPostChain(lutFile string) (*PostChain, error) {
+	chain, err := NewPostChain()
+	if err != nil {
+		return nil, err
+	}
+
+	bloom, err := NewBloomEffect()
+	if err != nil {
+		chain.Delete()
+		return nil, err
+	}
+	chain.Add(bloom)
+
+	for _, build := range []func() (*ShaderEffect, error){
+		NewToneMappingEffect,
+		func() (*ShaderEffect, error) { return NewColorGradingEffect(lutFile, 16) },
+		NewVignetteEffect,
+		NewGammaEffect,
+		NewFXAAEffect,
+	} {
+		effect, err := build()
+		if err != nil {
+			chain.Delete()
+			return nil, err
+		}
+		chain.Add(effect)
+	}
+	return chain, nil
+}
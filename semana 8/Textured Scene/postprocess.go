@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// PostEffect is a step of the post processing chain, it reads src and renders
+// into dst
+type PostEffect interface {
+	Name() string
+	Enabled() bool
+	SetEnabled(enabled bool)
+	SetParam(name string, values ...float32)
+	// Apply renders into dst, or into the default framebuffer when dst is nil
+	Apply(chain *PostChain, src, dst *Framebuffer)
+	Delete()
+}
+
+// ShaderEffect is a post effect made of a single fragment shader drawn over
+// shaders/post.vert, the first texture of src is bound to the "screen" sampler
+// and "texelSize" holds the size of one of its pixels
+type ShaderEffect struct {
+	name    string
+	enabled bool
+	program *gfx.Program
+
+	screenLoc    int32
+	texelSizeLoc int32
+	locations    map[string]int32
+
+	// Params are uploaded as float, vec2, vec3 or vec4 uniforms by their length
+	Params map[string][]float32
+	// Textures are extra samplers bound from TEXTURE1 up
+	Textures map[string]uint32
+	owned    []uint32 // textures created by the effect, deleted with it
+}
+
+func NewShaderEffect(name, fragFile string, params map[string][]float32) (*ShaderEffect, error) {
+	program, err := newProgramFromFiles("shaders/post.vert", fragFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if params == nil {
+		params = map[string][]float32{}
+	}
+	return &ShaderEffect{
+		name:         name,
+		enabled:      true,
+		program:      program,
+		screenLoc:    program.GetUniformLocation("screen"),
+		texelSizeLoc: program.GetUniformLocation("texelSize"),
+		locations:    map[string]int32{},
+		Params:       params,
+		Textures:     map[string]uint32{},
+	}, nil
+}
+
+func (e *ShaderEffect) Name() string {
+	return e.name
+}
+
+func (e *ShaderEffect) Enabled() bool {
+	return e.enabled
+}
+
+func (e *ShaderEffect) SetEnabled(enabled bool) {
+	e.enabled = enabled
+}
+
+func (e *ShaderEffect) SetParam(name string, values ...float32) {
+	e.Params[name] = values
+}
+
+func (e *ShaderEffect) location(name string) int32 {
+	loc, ok := e.locations[name]
+	if !ok {
+		loc = e.program.GetUniformLocation(name)
+		e.locations[name] = loc
+	}
+	return loc
+}
+
+func (e *ShaderEffect) Apply(chain *PostChain, src, dst *Framebuffer) {
+	chain.bindTarget(dst)
+	e.program.Use()
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, src.Texture(0))
+	gl.Uniform1i(e.screenLoc, 0)
+	srcWidth, srcHeight := src.Size()
+	gl.Uniform2f(e.texelSizeLoc, 1/float32(srcWidth), 1/float32(srcHeight))
+
+	unit := int32(1)
+	for name, texture := range e.Textures {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + unit))
+		gl.BindTexture(gl.TEXTURE_2D, texture)
+		gl.Uniform1i(e.location(name), unit)
+		unit++
+	}
+
+	for name, values := range e.Params {
+		loc := e.location(name)
+		switch len(values) {
+		case 1:
+			gl.Uniform1fv(loc, 1, &values[0])
+		case 2:
+			gl.Uniform2fv(loc, 1, &values[0])
+		case 3:
+			gl.Uniform3fv(loc, 1, &values[0])
+		case 4:
+			gl.Uniform4fv(loc, 1, &values[0])
+		}
+	}
+
+	chain.drawFullscreen()
+
+	// unbound so the next effect can render into them
+	for unit--; unit >= 0; unit-- {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + unit))
+		gl.BindTexture(gl.TEXTURE_2D, 0)
+	}
+}
+
+func (e *ShaderEffect) Delete() {
+	e.program.Delete()
+	if len(e.owned) > 0 {
+		gl.DeleteTextures(int32(len(e.owned)), &e.owned[0])
+		e.owned = nil
+	}
+}
+
+// PostChain runs its enabled effects in order, ping-ponging between two
+// offscreen framebuffers and writing the last one to the requested target
+type PostChain struct {
+	effects  []PostEffect
+	pingPong [2]*Framebuffer
+	copy     *ShaderEffect
+	emptyVAO uint32
+}
+
+func NewPostChain() (*PostChain, error) {
+	var chain PostChain
+	for i := range chain.pingPong {
+		chain.pingPong[i] = NewFramebuffer(width, height)
+		chain.pingPong[i].AddColor(gl.RGBA16F, gl.RGBA, gl.FLOAT, gl.LINEAR)
+		if err := chain.pingPong[i].Complete(); err != nil {
+			chain.Delete()
+			return nil, err
+		}
+	}
+
+	var err error
+	chain.copy, err = NewShaderEffect("copy", "shaders/post_copy.frag", nil)
+	if err != nil {
+		chain.Delete()
+		return nil, err
+	}
+	// core profile needs a bound VAO even if the vertex shader uses no attributes
+	gl.GenVertexArrays(1, &chain.emptyVAO)
+
+	return &chain, nil
+}
+
+// Add appends effects at the end of the chain
+func (c *PostChain) Add(effects ...PostEffect) {
+	c.effects = append(c.effects, effects...)
+}
+
+// Effect returns the named effect, nil if it is not in the chain
+func (c *PostChain) Effect(name string) PostEffect {
+	for _, effect := range c.effects {
+		if effect.Name() == name {
+			return effect
+		}
+	}
+	return nil
+}
+
+func (c *PostChain) SetEnabled(name string, enabled bool) {
+	if effect := c.Effect(name); effect != nil {
+		effect.SetEnabled(enabled)
+	}
+}
+
+// Apply runs the enabled effects over the first texture of src and renders
+// the result into dst, or into the default framebuffer when dst is nil
+func (c *PostChain) Apply(src, dst *Framebuffer) {
+	state := saveRenderState()
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.BLEND)
+
+	var enabled []PostEffect
+	for _, effect := range c.effects {
+		if effect.Enabled() {
+			enabled = append(enabled, effect)
+		}
+	}
+	if len(enabled) == 0 {
+		enabled = append(enabled, c.copy)
+	}
+
+	input := src
+	for i, effect := range enabled {
+		target := dst
+		if i < len(enabled)-1 {
+			target = c.pingPong[i%2]
+		}
+		effect.Apply(c, input, target)
+		input = target
+	}
+	c.bindTarget(dst)
+
+	state.restore()
+}
+
+func (c *PostChain) bindTarget(dst *Framebuffer) {
+	if dst != nil {
+		dst.Bind()
+		return
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.Viewport(0, 0, width, height)
+}
+
+func (c *PostChain) drawFullscreen() {
+	gl.BindVertexArray(c.emptyVAO)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.BindVertexArray(0)
+}
+
+// Delete frees the chain and every effect in it
+func (c *PostChain) Delete() {
+	for _, effect := range c.effects {
+		effect.Delete()
+	}
+	c.effects = nil
+	for _, target := range c.pingPong {
+		target.Delete()
+	}
+	if c.copy != nil {
+		c.copy.Delete()
+	}
+	if c.emptyVAO != 0 {
+		gl.DeleteVertexArrays(1, &c.emptyVAO)
+	}
+}
+
+// PostEffectConfig is the configuration of one effect
+type PostEffectConfig struct {
+	Name    string               `json:"name"`
+	Enabled bool                 `json:"enabled"`
+	Params  map[string][]float32 `json:"params,omitempty"`
+}
+
+// PostChainConfig lists the effects of a chain in the order they run
+type PostChainConfig struct {
+	Effects []PostEffectConfig `json:"effects"`
+}
+
+func LoadPostChainConfig(file string) (PostChainConfig, error) {
+	var cfg PostChainConfig
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// Configure reorders the chain as listed in cfg and applies its parameters,
+// the effects of the chain that are not listed are disabled and kept at the end
+func (c *PostChain) Configure(cfg PostChainConfig) error {
+	ordered := make([]PostEffect, 0, len(c.effects))
+	listed := map[string]bool{}
+	for _, effectCfg := range cfg.Effects {
+		effect := c.Effect(effectCfg.Name)
+		if effect == nil {
+			return fmt.Errorf("unknown post effect %q", effectCfg.Name)
+		}
+		if listed[effectCfg.Name] {
+			return fmt.Errorf("post effect %q listed twice", effectCfg.Name)
+		}
+		listed[effectCfg.Name] = true
+
+		effect.SetEnabled(effectCfg.Enabled)
+		for name, values := range effectCfg.Params {
+			effect.SetParam(name, values...)
+		}
+		ordered = append(ordered, effect)
+	}
+	for _, effect := range c.effects {
+		if !listed[effect.Name()] {
+			effect.SetEnabled(false)
+			ordered = append(ordered, effect)
+		}
+	}
+	c.effects = ordered
+	return nil
+}
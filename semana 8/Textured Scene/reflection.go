@@ -0,0 +1,194 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// PlaneFromPoint returns the plane through point with the given normal, as the
+// xyz normal and w distance of the points p where n·p + w = 0
+func PlaneFromPoint(point, normal mgl32.Vec3) mgl32.Vec4 {
+	normal = normal.Normalize()
+	return normal.Vec4(-normal.Dot(point))
+}
+
+// PlanarReflection renders the scene mirrored about Plane into a texture the
+// size of the window times Scale. Only what is on the side the normal points
+// to is reflected
+type PlanarReflection struct {
+	Plane mgl32.Vec4
+	Scale float32
+
+	target  *Framebuffer
+	texture uint32 // owned by target
+}
+
+func NewPlanarReflection(plane mgl32.Vec4, scale float32) (*PlanarReflection, error) {
+	r := PlanarReflection{
+		Plane: plane,
+		Scale: scale,
+	}
+
+	r.target = NewFramebuffer(int32(float32(width)*scale), int32(float32(height)*scale))
+	// clamped, the distortion may push the coordinates past the edges
+	r.texture = r.target.AddColor(gl.RGBA8, gl.RGBA, gl.UNSIGNED_BYTE, gl.LINEAR)
+	r.target.AddDepthBuffer(gl.DEPTH_COMPONENT24)
+	if err := r.target.Complete(); err != nil {
+		r.Delete()
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// reflectionMatrix mirrors points about plane
+func reflectionMatrix(plane mgl32.Vec4) mgl32.Mat4 {
+	n, d := plane.Vec3(), plane.W()
+	return mgl32.Mat4{
+		1 - 2*n[0]*n[0], -2 * n[1] * n[0], -2 * n[2] * n[0], 0,
+		-2 * n[0] * n[1], 1 - 2*n[1]*n[1], -2 * n[2] * n[1], 0,
+		-2 * n[0] * n[2], -2 * n[1] * n[2], 1 - 2*n[2]*n[2], 0,
+		-2 * d * n[0], -2 * d * n[1], -2 * d * n[2], 1,
+	}
+}
+
+// obliqueProjection moves the near plane of projection onto plane, given in
+// view space, so nothing behind the mirror ends in the reflection. The far
+// plane is skewed too but the depth stays usable (Lengyel, 2005)
+func obliqueProjection(projection mgl32.Mat4, plane mgl32.Vec4) mgl32.Mat4 {
+	corner := projection.Inv().Mul4x1(mgl32.Vec4{sign(plane.X()), sign(plane.Y()), 1, 1})
+	c := plane.Mul(2 / plane.Dot(corner))
+	projection.SetRow(2, c.Sub(projection.Row(3)))
+	return projection
+}
+
+func sign(x float32) float32 {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+// Render draws the mirrored scene seen from the camera of view, projection and
+// viewPos, drawScene gets the mirrored camera. target is bound again when it
+// returns, nil for the default framebuffer
+func (r *PlanarReflection) Render(target *Framebuffer, view, projection mgl32.Mat4, viewPos mgl32.Vec3,
+	drawScene func(view, projection mgl32.Mat4, viewPos mgl32.Vec3)) {
+
+	mirror := reflectionMatrix(r.Plane)
+	mirroredView := view.Mul4(mirror)
+	mirroredPos := mirror.Mul4x1(viewPos.Vec4(1)).Vec3()
+	// planes are transformed by the inverse transpose
+	viewPlane := mirroredView.Inv().Transpose().Mul4x1(r.Plane)
+
+	r.target.Bind()
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	// a mirror swaps the winding of the triangles
+	gl.FrontFace(gl.CW)
+	drawScene(mirroredView, obliqueProjection(projection, viewPlane), mirroredPos)
+	gl.FrontFace(gl.CCW)
+	target.Bind()
+}
+
+func (r *PlanarReflection) Delete() {
+	r.target.Delete()
+}
+
+// ReflectiveSurface is a flat model showing a PlanarReflection, it has to lie
+// on the plane of the reflection
+type ReflectiveSurface struct {
+	Reflection *PlanarReflection
+	Color      mgl32.Vec3
+	// reflectance at normal incidence, see EnvironmentMaterial
+	Reflectivity float32
+	// how far the ripples move the reflection in texture coordinates, 0 is a
+	// perfect mirror
+	Distortion float32
+	// surface under the reflection, it may be nil
+	Texture *gfx.Texture
+}
+
+// ReflectionRenderer draws models with a ReflectiveSurface
+type ReflectionRenderer struct {
+	program *gfx.Program
+	locs    reflectionLocations
+}
+
+type reflectionLocations struct {
+	model, view, projection, viewPos, time, screenSize int32
+	normal, reflection, color, reflectivity            int32
+	distortion, tex, hasTexture                        int32
+}
+
+func NewReflectionRenderer() (*ReflectionRenderer, error) {
+	program, err := newProgramFromFiles("shaders/environment.vert", "shaders/planar_reflection.frag")
+	if err != nil {
+		return nil, err
+	}
+	return &ReflectionRenderer{
+		program: program,
+		locs: reflectionLocations{
+			model:        program.GetUniformLocation("model"),
+			view:         program.GetUniformLocation("view"),
+			projection:   program.GetUniformLocation("projection"),
+			viewPos:      program.GetUniformLocation("viewPos"),
+			time:         program.GetUniformLocation("time"),
+			screenSize:   program.GetUniformLocation("screenSize"),
+			normal:       program.GetUniformLocation("planeNormal"),
+			reflection:   program.GetUniformLocation("reflection"),
+			color:        program.GetUniformLocation("surface.color"),
+			reflectivity: program.GetUniformLocation("surface.reflectivity"),
+			distortion:   program.GetUniformLocation("surface.distortion"),
+			tex:          program.GetUniformLocation("surface.texture"),
+			hasTexture:   program.GetUniformLocation("surface.hasTexture"),
+		},
+	}, nil
+}
+
+// Begin uses the program and sets the camera and the time of the ripples,
+// Draw may then be called for each surface
+func (r *ReflectionRenderer) Begin(view, projection mgl32.Mat4, viewPos mgl32.Vec3, time float32) {
+	r.program.Use()
+	gl.UniformMatrix4fv(r.locs.view, 1, false, &view[0])
+	gl.UniformMatrix4fv(r.locs.projection, 1, false, &projection[0])
+	gl.Uniform3fv(r.locs.viewPos, 1, &viewPos[0])
+	gl.Uniform1f(r.locs.time, time)
+	gl.Uniform2f(r.locs.screenSize, width, height)
+}
+
+// Draw sets the model matrix and surface, then draw binds the vertices and draws them
+func (r *ReflectionRenderer) Draw(surface *ReflectiveSurface, model mgl32.Mat4, draw func()) {
+	gl.UniformMatrix4fv(r.locs.model, 1, false, &model[0])
+	normal := surface.Reflection.Plane.Vec3()
+	gl.Uniform3fv(r.locs.normal, 1, &normal[0])
+	gl.Uniform3fv(r.locs.color, 1, &surface.Color[0])
+	gl.Uniform1f(r.locs.reflectivity, surface.Reflectivity)
+	gl.Uniform1f(r.locs.distortion, surface.Distortion)
+
+	gl.ActiveTexture(envUnit)
+	gl.BindTexture(gl.TEXTURE_2D, surface.Reflection.texture)
+	gl.Uniform1i(r.locs.reflection, envUnit-gl.TEXTURE0)
+	gl.Uniform1i(r.locs.hasTexture, 0)
+	if surface.Texture != nil {
+		surface.Texture.Bind(gl.TEXTURE0)
+		surface.Texture.SetUniform(r.locs.tex)
+		gl.Uniform1i(r.locs.hasTexture, 1)
+	}
+
+	draw()
+
+	if surface.Texture != nil {
+		surface.Texture.UnBind()
+	}
+	gl.ActiveTexture(envUnit)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+func (r *ReflectionRenderer) Delete() {
+	r.program.Delete()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+	"unsafe"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// Mesh is a vertex array and how many indices of it are drawn
+type Mesh struct {
+	VAO   uint32
+	Count int32
+}
+
+// Material is the state a DrawItem needs besides its mesh
+type Material struct {
+	// Program drawing the item, nil draws with the program in use when the
+	// queue is executed
+	Program *gfx.Program
+	// Textures bound in order to the units 0, 1, ...
+	Textures []*gfx.Texture
+}
+
+// DrawItem is a mesh drawn with a material at a transform
+type DrawItem struct {
+	Mesh      *Mesh
+	Material  *Material
+	Transform mgl32.Mat4
+}
+
+// DrawLocations are the uniforms the queue sets in a program, the model
+// matrix and the sampler of each texture unit. -1 leaves a uniform unset,
+// the depth passes draw with no textures at all
+type DrawLocations struct {
+	Model    int32
+	Textures []int32
+}
+
+// RenderStats counts the work of the executed queues since the last
+// ResetStats, a state change is a program, texture or vertex array bound
+type RenderStats struct {
+	DrawCalls      int
+	StateChanges   int
+	ProgramChanges int
+	TextureChanges int
+	VAOChanges     int
+}
+
+// RenderQueue collects the draws of a pass and executes them sorted by program,
+// textures and vertex array, so each one is bound once for all the items that
+// share it instead of once per item
+type RenderQueue struct {
+	items []DrawItem
+	stats RenderStats
+
+	locations map[*gfx.Program]DrawLocations
+	// order of first appearance, the sort keys of the pointers
+	programs map[*gfx.Program]int
+	textures map[*gfx.Texture]int
+}
+
+func NewRenderQueue() *RenderQueue {
+	return &RenderQueue{
+		locations: make(map[*gfx.Program]DrawLocations),
+		programs:  make(map[*gfx.Program]int),
+		textures:  make(map[*gfx.Texture]int),
+	}
+}
+
+// SetLocations gives the uniforms of program, needed by the materials that
+// have their own program
+func (q *RenderQueue) SetLocations(program *gfx.Program, locations DrawLocations) {
+	q.locations[program] = locations
+}
+
+// Submit queues mesh drawn with material at transform for the next Execute
+func (q *RenderQueue) Submit(mesh *Mesh, material *Material, transform mgl32.Mat4) {
+	if _, ok := q.programs[material.Program]; !ok && material.Program != nil {
+		q.programs[material.Program] = len(q.programs)
+	}
+	for _, texture := range material.Textures {
+		if _, ok := q.textures[texture]; !ok {
+			q.textures[texture] = len(q.textures)
+		}
+	}
+	q.items = append(q.items, DrawItem{Mesh: mesh, Material: material, Transform: transform})
+}
+
+// Len is the number of queued items
+func (q *RenderQueue) Len() int {
+	return len(q.items)
+}
+
+// Reset empties the queue, the stats are kept
+func (q *RenderQueue) Reset() {
+	q.items = q.items[:0]
+}
+
+// Stats returns the counts since the last ResetStats
+func (q *RenderQueue) Stats() RenderStats {
+	return q.stats
+}
+
+// ResetStats starts counting again, usually once per frame
+func (q *RenderQueue) ResetStats() {
+	q.stats = RenderStats{}
+}
+
+// Execute sorts and draws the queued items, which stay queued so the same
+// items may be drawn again by another pass. The items without a program use
+// the one in use and locations, the others the locations given to
+// SetLocations. The vertex array and the textures are unbound at the end
+func (q *RenderQueue) Execute(locations DrawLocations) {
+	sort.SliceStable(q.items, func(i, j int) bool {
+		return q.less(&q.items[i], &q.items[j])
+	})
+
+	var (
+		program  *gfx.Program
+		vao      uint32
+		bound    []*gfx.Texture
+		current  = locations
+		switched = true
+	)
+	for i := range q.items {
+		item := &q.items[i]
+		material := item.Material
+
+		if material.Program != program {
+			program = material.Program
+			program.Use()
+			current = q.locations[program]
+			q.stats.ProgramChanges++
+			switched = true
+		}
+		for unit, texture := range material.Textures {
+			if unit >= len(current.Textures) || current.Textures[unit] < 0 {
+				continue
+			}
+			for len(bound) <= unit {
+				bound = append(bound, nil)
+			}
+			if bound[unit] != texture {
+				texture.Bind(uint32(gl.TEXTURE0 + unit))
+				bound[unit] = texture
+				q.stats.TextureChanges++
+			}
+		}
+		// a material with fewer textures samples nothing from the rest
+		for unit := len(material.Textures); unit < len(bound); unit++ {
+			if bound[unit] != nil {
+				bound[unit].UnBind()
+				bound[unit] = nil
+				q.stats.TextureChanges++
+			}
+		}
+		if switched {
+			// the samplers keep their unit until the program changes
+			for unit, loc := range current.Textures {
+				if loc >= 0 {
+					gl.Uniform1i(loc, int32(unit))
+				}
+			}
+			switched = false
+		}
+		if item.Mesh.VAO != vao {
+			vao = item.Mesh.VAO
+			gl.BindVertexArray(vao)
+			q.stats.VAOChanges++
+		}
+
+		gl.UniformMatrix4fv(current.Model, 1, false, &item.Transform[0])
+		gl.DrawElements(gl.TRIANGLES, item.Mesh.Count, gl.UNSIGNED_INT, unsafe.Pointer(nil))
+		q.stats.DrawCalls++
+	}
+	q.stats.StateChanges = q.stats.ProgramChanges + q.stats.TextureChanges + q.stats.VAOChanges
+
+	gl.BindVertexArray(0)
+	for _, texture := range bound {
+		if texture != nil {
+			texture.UnBind()
+		}
+	}
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// less orders by program, then textures unit by unit, then vertex array. The
+// items without a program go first, while the program in use is still bound
+func (q *RenderQueue) less(a, b *DrawItem) bool {
+	if pa, pb := q.programKey(a.Material.Program), q.programKey(b.Material.Program); pa != pb {
+		return pa < pb
+	}
+	ta, tb := a.Material.Textures, b.Material.Textures
+	for i := 0; i < len(ta) && i < len(tb); i++ {
+		if ia, ib := q.textures[ta[i]], q.textures[tb[i]]; ia != ib {
+			return ia < ib
+		}
+	}
+	if len(ta) != len(tb) {
+		return len(ta) < len(tb)
+	}
+	return a.Mesh.VAO < b.Mesh.VAO
+}
+
+func (q *RenderQueue) programKey(program *gfx.Program) int {
+	if program == nil {
+		return -1
+	}
+	return q.programs[program]
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// newShaderFromFile compiles a shader file replacing its `#include "file"` lines
+// with the contents of file, relative to the directory of the shader
+func newShaderFromFile(file string, sType uint32) (*gfx.Shader, error) {
+	src, err := loadShaderSource(file, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	shader, err := gfx.NewShader(src, sType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return shader, nil
+}
+
+func loadShaderSource(file string, included map[string]bool) (string, error) {
+	if included[file] {
+		return "", fmt.Errorf("%s: included recursively", file)
+	}
+	included[file] = true
+	defer delete(included, file)
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#include") {
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "#include")), `"`)
+		src, err := loadShaderSource(filepath.Join(filepath.Dir(file), name), included)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		lines[i] = src
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func newProgramFromFiles(vertFile, fragFile string) (*gfx.Program, error) {
+	vertShader, err := newShaderFromFile(vertFile, gl.VERTEX_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	fragShader, err := newShaderFromFile(fragFile, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return nil, err
+	}
+	return gfx.NewProgram(vertShader, fragShader)
+}
@@ -0,0 +1,33 @@
+#version 410 core
+out vec4 FragColor;
+
+#include "lighting.glsl"
+#include "fog.glsl"
+
+uniform sampler2D gPosition;
+uniform sampler2D gNormal;
+uniform sampler2D gAlbedo;
+uniform sampler2D gUnlit;
+uniform vec2 screenSize;
+
+void main()
+{
+    vec2 uv = gl_FragCoord.xy / screenSize;
+    vec4 normal = texture(gNormal, uv);
+    // background, nothing to light
+    if (normal.a == 0.0)
+        discard;
+
+    vec4 position = texture(gPosition, uv);
+    vec3 viewDir = normalize(viewPos - position.xyz);
+    vec3 result = CalcLighting(normal.xyz, position.xyz, viewDir, position.w);
+
+    vec3 color = texture(gAlbedo, uv).rgb * result;
+    // the fog color is added once, like in phong_ml.frag
+    float fogAmount = FogAmount(position.xyz);
+    if (lightPass == 0)
+        color = mix(color + texture(gUnlit, uv).rgb, fog.color, fogAmount);
+    else
+        color *= 1.0 - fogAmount;
+    FragColor = vec4(color, 1.0);
+}
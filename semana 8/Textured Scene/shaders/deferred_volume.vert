@@ -0,0 +1,13 @@
+#version 410 core
+layout (location = 0) in vec3 aPos;
+
+// sphere around a point light scaled to its range, only the pixels it
+// covers are lit
+uniform mat4 model;
+uniform mat4 view;
+uniform mat4 projection;
+
+void main()
+{
+    gl_Position = projection * view * model * vec4(aPos, 1.0);
+}
@@ -0,0 +1,39 @@
+#version 410 core
+struct Material {
+    vec3 color;
+    float reflectivity;    // Fresnel reflectance at normal incidence
+    float refractionRatio; // outside / inside refractive index, 0 is opaque
+    float opacity;         // at normal incidence, the reflection is always opaque
+    bool hasTexture;
+    sampler2D texture;
+};
+
+in vec3 FragPos;
+in vec3 Normal;
+in vec2 TexCoord;
+
+#include "oit.glsl"
+
+uniform Material material;
+uniform samplerCube environment;
+uniform vec3 viewPos;
+
+void main()
+{
+    vec3 N = normalize(Normal);
+    vec3 I = normalize(FragPos - viewPos);
+    float cosTheta = max(dot(-I, N), 0.0);
+    // Schlick approximation, more mirror like at grazing angles
+    float fresnel = material.reflectivity + (1.0 - material.reflectivity) * pow(1.0 - cosTheta, 5.0);
+
+    vec3 reflection = texture(environment, reflect(I, N)).rgb;
+    vec3 surface = material.color;
+    if (material.refractionRatio > 0.0) {
+        vec3 R = refract(I, N, material.refractionRatio);
+        // total internal reflection gives a zero vector
+        surface *= dot(R, R) > 0.0 ? texture(environment, R).rgb : reflection;
+    } else if (material.hasTexture) {
+        surface *= texture(material.texture, TexCoord).rgb;
+    }
+    WriteTransparent(vec4(mix(surface, reflection * material.color, fresnel), mix(material.opacity, 1.0, fresnel)));
+}
@@ -0,0 +1,51 @@
+// distance and height fog shared by the phong, source and particle shaders,
+// included after the #version line, see newShaderFromFile
+
+// fog modes, must match fog.go
+#define FOG_NONE 0
+#define FOG_LINEAR 1
+#define FOG_EXP 2
+#define FOG_EXP2 3
+#define FOG_HEIGHT 4
+
+struct Fog {
+    int mode;
+    vec3 color;
+    vec3 eye;            // position of the camera
+    float start;         // linear, distance where the fog begins
+    float end;           // linear, distance where it hides everything
+    float density;       // exponential and height, per world unit
+    float baseHeight;    // height, where it is as thick as density
+    float heightFalloff; // height, how fast it thins going up
+};
+
+uniform Fog fog;
+
+// fraction of the color at worldPos hidden by the fog, 0 is clear
+float FogAmount(vec3 worldPos)
+{
+    vec3 ray = worldPos - fog.eye;
+    float dist = length(ray);
+    if (fog.mode == FOG_LINEAR)
+        return clamp((dist - fog.start) / max(fog.end - fog.start, 0.0001), 0.0, 1.0);
+    if (fog.mode == FOG_EXP)
+        return 1.0 - exp(-fog.density * dist);
+    if (fog.mode == FOG_EXP2)
+        return 1.0 - exp(-pow(fog.density * dist, 2.0));
+    if (fog.mode == FOG_HEIGHT) {
+        // the density falls exponentially with the height, integrated along
+        // the ray from the eye
+        float k = fog.heightFalloff;
+        float eyeDensity = fog.density * exp(-k * (fog.eye.y - fog.baseHeight));
+        float optical = eyeDensity * dist;
+        if (abs(k * ray.y) > 0.0001)
+            optical *= (1.0 - exp(-k * ray.y)) / (k * ray.y);
+        return clamp(1.0 - exp(-optical), 0.0, 1.0);
+    }
+    return 0.0;
+}
+
+vec3 ApplyFog(vec3 color, vec3 worldPos)
+{
+    return mix(color, fog.color, FogAmount(worldPos));
+}
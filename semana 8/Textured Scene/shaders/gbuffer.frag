@@ -0,0 +1,37 @@
+#version 410 core
+layout (location = 0) out vec4 gPosition;
+layout (location = 1) out vec4 gNormal;
+layout (location = 2) out vec4 gAlbedo;
+layout (location = 3) out vec4 gUnlit;
+
+in vec3 FragPos;
+in vec3 Normal;
+in vec2 TexCoord;
+in float ViewDepth;
+
+uniform vec3 objectColor;
+uniform sampler2D texSampler;
+uniform sampler2D texSampler2;
+
+// stores what phong_ml.frag needs to light the fragment, the final color is
+// unlit + albedo * light
+void main()
+{
+    gPosition = vec4(FragPos, ViewDepth);
+    // alpha marks the pixels that have geometry
+    gNormal = vec4(normalize(Normal), 1.0);
+
+    gUnlit = vec4(0.0);
+    if (textureSize(texSampler, 0).x > 1){
+        if (textureSize(texSampler2, 0).x > 1){
+            // same as the mix of phong_ml.frag, half lit and half unlit
+            gAlbedo = 0.5 * texture(texSampler2, TexCoord);
+            gUnlit = 0.5 * texture(texSampler, TexCoord);
+        } else {
+            gAlbedo = texture(texSampler, TexCoord);
+        }
+    }
+    else {
+        gAlbedo = vec4(objectColor, 1.0);
+    }
+}
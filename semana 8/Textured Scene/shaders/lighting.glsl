@@ -0,0 +1,261 @@
+// lights, shadows and the phong model shared by the forward and deferred shaders,
+// included after the #version line, see newShaderFromFile
+
+struct PointLight {
+    vec3 position;
+    
+    float constant;
+    float linear;
+    float quadratic;
+	vec3 lightColor;
+    vec3 ambient;
+    vec3 diffuse;
+    vec3 specular;
+};
+
+struct DirLight {
+    vec3 direction; // from the light to the scene
+	vec3 lightColor;
+    vec3 ambient;
+    vec3 diffuse;
+    vec3 specular;
+};
+
+struct SpotLight {
+    vec3 position;
+    vec3 direction;
+    float cutOff;      // cosine of the inner cone angle
+    float outerCutOff; // cosine of the outer cone angle
+
+    float constant;
+    float linear;
+    float quadratic;
+	vec3 lightColor;
+    vec3 ambient;
+    vec3 diffuse;
+    vec3 specular;
+};
+
+// maximun shadow cascades, must match shadows.go
+#define NR_CASCADES 4
+
+// cascaded shadow of one of the directional lights, light is -1 when none casts shadows
+struct DirShadow {
+    int light;
+    int numCascades;
+    mat4 lightSpace[NR_CASCADES];
+    float splits[NR_CASCADES]; // view distance where each cascade ends
+    float bias;
+    float slopeBias;
+    int pcfRadius;
+};
+
+// cube shadow of the point light at index light
+struct PointShadow {
+    int light;
+    float farPlane;
+    float bias;
+    int pcfRadius;
+};
+
+// maximun lights of each type, must match lights.go
+#define NR_POINT_LIGHTS 8
+#define NR_DIR_LIGHTS 4
+#define NR_SPOT_LIGHTS 8
+// maximun shadows, must match shadows.go
+#define NR_POINT_SHADOWS 2
+
+// total ligts of each type from the pc program that will be rendered in gpu
+uniform int numPointLights;
+uniform int numDirLights;
+uniform int numSpotLights;
+// 0 for the first group of lights, later groups are added on top of it
+uniform int lightPass;
+uniform vec3 viewPos;
+uniform PointLight pointLights[NR_POINT_LIGHTS];
+uniform DirLight dirLights[NR_DIR_LIGHTS];
+uniform SpotLight spotLights[NR_SPOT_LIGHTS];
+uniform DirShadow dirShadow;
+uniform sampler2DArray dirShadowMap;
+uniform int numPointShadows;
+uniform PointShadow pointShadows[NR_POINT_SHADOWS];
+uniform samplerCube pointShadowMaps[NR_POINT_SHADOWS];
+// screen space ambient occlusion of the camera, see ssao.go
+uniform bool useSSAO;
+uniform sampler2D ssaoMap;
+
+
+// function prototypes
+
+vec3 CalcPointLight(PointLight light, vec3 normal, vec3 fragPos, vec3 viewDir, float shadow, float occlusion);
+vec3 CalcDirLight(DirLight light, vec3 normal, vec3 viewDir, float shadow, float occlusion);
+vec3 CalcSpotLight(SpotLight light, vec3 normal, vec3 fragPos, vec3 viewDir, float occlusion);
+float CalcOcclusion();
+float CalcDirShadow(vec3 normal, vec3 lightDir, vec3 fragPos, float viewDepth);
+float CalcPointShadow(int index, vec3 fragPos, vec3 lightPos);
+
+
+// sums the light of every light of the pass at a fragment, viewDepth is its
+// distance along the camera axis
+vec3 CalcLighting(vec3 normal, vec3 fragPos, vec3 viewDir, float viewDepth)
+{
+    vec3 result = vec3(0.0,0.0,0.0);
+    float occlusion = CalcOcclusion();
+    for(int i = 0; i < numDirLights; i++) {
+        float shadow = 0.0;
+        if (i == dirShadow.light)
+            shadow = CalcDirShadow(normal, normalize(-dirLights[i].direction), fragPos, viewDepth);
+        result += CalcDirLight(dirLights[i], normal, viewDir, shadow, occlusion);
+    }
+    for(int i = 0; i < numPointLights; i++) {
+        float shadow = 0.0;
+        for(int j = 0; j < numPointShadows; j++)
+            if (pointShadows[j].light == i)
+                shadow = CalcPointShadow(j, fragPos, pointLights[i].position);
+        result += CalcPointLight(pointLights[i], normal, fragPos, viewDir, shadow, occlusion);
+    }
+    for(int i = 0; i < numSpotLights; i++)
+        result += CalcSpotLight(spotLights[i], normal, fragPos, viewDir, occlusion);
+    return result;
+}
+
+// ambient light that reaches the pixel, 1 without ssao
+float CalcOcclusion()
+{
+    if (!useSSAO)
+        return 1.0;
+    return texture(ssaoMap, gl_FragCoord.xy / vec2(textureSize(ssaoMap, 0))).r;
+}
+
+// calculates the color when using a point light, shadow is 1 when fully occluded.
+// occlusion scales the ambient term
+vec3 CalcPointLight(PointLight light, vec3 normal, vec3 fragPos, vec3 viewDir, float shadow, float occlusion)
+{
+    vec3 lightDir = normalize(light.position - fragPos);
+    // diffuse shading
+    float diff = max(dot(normal, lightDir), 0.0);
+    // specular shading
+    vec3 reflectDir = reflect(-lightDir, normal);
+    float spec = pow(max(dot(viewDir, reflectDir), 0.0), 32.0);
+    // attenuation
+    float pdistance = length(light.position - fragPos);
+    float attenuation = 1.0 / (light.constant + light.linear * pdistance + light.quadratic * (pdistance * pdistance));    
+    // combine results
+    vec3 ambient = light.ambient * occlusion;
+    vec3 diffuse = light.diffuse * diff * light.lightColor;
+    vec3 specular = light.specular * spec * light.lightColor;
+    ambient *= attenuation;
+    diffuse *= attenuation;
+    specular *= attenuation;
+    return (ambient + (1.0 - shadow) * (diffuse + specular));
+}
+
+// calculates the color when using a directional light, shadow is 1 when fully occluded.
+vec3 CalcDirLight(DirLight light, vec3 normal, vec3 viewDir, float shadow, float occlusion)
+{
+    vec3 lightDir = normalize(-light.direction);
+    // diffuse shading
+    float diff = max(dot(normal, lightDir), 0.0);
+    // specular shading
+    vec3 reflectDir = reflect(-lightDir, normal);
+    float spec = pow(max(dot(viewDir, reflectDir), 0.0), 32.0);
+    // combine results, no attenuation for lights far away
+    vec3 ambient = light.ambient * occlusion;
+    vec3 diffuse = light.diffuse * diff * light.lightColor;
+    vec3 specular = light.specular * spec * light.lightColor;
+    return (ambient + (1.0 - shadow) * (diffuse + specular));
+}
+
+// calculates the color when using a spot light.
+vec3 CalcSpotLight(SpotLight light, vec3 normal, vec3 fragPos, vec3 viewDir, float occlusion)
+{
+    vec3 lightDir = normalize(light.position - fragPos);
+    // diffuse shading
+    float diff = max(dot(normal, lightDir), 0.0);
+    // specular shading
+    vec3 reflectDir = reflect(-lightDir, normal);
+    float spec = pow(max(dot(viewDir, reflectDir), 0.0), 32.0);
+    // attenuation
+    float pdistance = length(light.position - fragPos);
+    float attenuation = 1.0 / (light.constant + light.linear * pdistance + light.quadratic * (pdistance * pdistance));    
+    // spotlight intensity, smooth between the inner and outer cones
+    float theta = dot(lightDir, normalize(-light.direction)); 
+    float epsilon = light.cutOff - light.outerCutOff;
+    float intensity = clamp((theta - light.outerCutOff) / epsilon, 0.0, 1.0);
+    // combine results
+    vec3 ambient = light.ambient * occlusion;
+    vec3 diffuse = light.diffuse * diff * light.lightColor;
+    vec3 specular = light.specular * spec * light.lightColor;
+    ambient *= attenuation;
+    diffuse *= attenuation * intensity;
+    specular *= attenuation * intensity;
+    return (ambient + diffuse + specular);
+}
+
+// fraction of the directional shadow light blocked at the fragment, averaged
+// over the PCF kernel of the cascade that covers it
+float CalcDirShadow(vec3 normal, vec3 lightDir, vec3 fragPos, float viewDepth)
+{
+    int cascade = dirShadow.numCascades - 1;
+    for(int i = 0; i < dirShadow.numCascades; i++) {
+        if (viewDepth < dirShadow.splits[i]) {
+            cascade = i;
+            break;
+        }
+    }
+
+    vec4 lightSpacePos = dirShadow.lightSpace[cascade] * vec4(fragPos, 1.0);
+    vec3 projCoords = lightSpacePos.xyz / lightSpacePos.w * 0.5 + 0.5;
+    // beyond the far plane of the light
+    if (projCoords.z > 1.0)
+        return 0.0;
+
+    // surfaces facing away from the light need more bias, farther cascades
+    // cover more world per texel so they need it too
+    float bias = max(dirShadow.slopeBias * (1.0 - dot(normal, lightDir)), dirShadow.bias);
+    bias *= 1.0 + float(cascade);
+
+    vec2 texelSize = 1.0 / vec2(textureSize(dirShadowMap, 0).xy);
+    float shadow = 0.0;
+    for(int x = -dirShadow.pcfRadius; x <= dirShadow.pcfRadius; x++) {
+        for(int y = -dirShadow.pcfRadius; y <= dirShadow.pcfRadius; y++) {
+            float depth = texture(dirShadowMap, vec3(projCoords.xy + vec2(x, y) * texelSize, cascade)).r;
+            shadow += projCoords.z - bias > depth ? 1.0 : 0.0;
+        }
+    }
+    float kernel = float(2 * dirShadow.pcfRadius + 1);
+    return shadow / (kernel * kernel);
+}
+
+// offsets spread over the cube around the sampled direction, better coverage
+// than a full 3D grid with far fewer samples
+const vec3 pointShadowOffsets[20] = vec3[](
+    vec3( 1,  1,  1), vec3( 1, -1,  1), vec3(-1, -1,  1), vec3(-1,  1,  1),
+    vec3( 1,  1, -1), vec3( 1, -1, -1), vec3(-1, -1, -1), vec3(-1,  1, -1),
+    vec3( 1,  1,  0), vec3( 1, -1,  0), vec3(-1, -1,  0), vec3(-1,  1,  0),
+    vec3( 1,  0,  1), vec3(-1,  0,  1), vec3( 1,  0, -1), vec3(-1,  0, -1),
+    vec3( 0,  1,  1), vec3( 0, -1,  1), vec3( 0, -1, -1), vec3( 0,  1, -1)
+);
+
+// fraction of the point light blocked at the fragment, the cubemap stores the
+// distance to the light divided by farPlane
+float CalcPointShadow(int index, vec3 fragPos, vec3 lightPos)
+{
+    vec3 fragToLight = fragPos - lightPos;
+    float current = length(fragToLight) / pointShadows[index].farPlane;
+    if (current > 1.0)
+        return 0.0;
+
+    float bias = pointShadows[index].bias;
+    if (pointShadows[index].pcfRadius == 0)
+        return current - bias > texture(pointShadowMaps[index], fragToLight).r ? 1.0 : 0.0;
+
+    // the disk grows with the distance to the viewer, near shadows stay sharp
+    float diskRadius = float(pointShadows[index].pcfRadius) * (1.0 + length(viewPos - fragPos) / pointShadows[index].farPlane) / 50.0;
+    float shadow = 0.0;
+    for(int i = 0; i < 20; i++) {
+        float depth = texture(pointShadowMaps[index], fragToLight + pointShadowOffsets[i] * diskRadius).r;
+        shadow += current - bias > depth ? 1.0 : 0.0;
+    }
+    return shadow / 20.0;
+}
@@ -0,0 +1,20 @@
+// output of the shaders of transparent models, a plain color or the weighted
+// sums of the order independent transparency of transparency.go
+
+layout (location = 0) out vec4 FragColor; // the accumulation in the oit pass
+layout (location = 1) out float Revealage;
+
+uniform bool oitPass;
+
+void WriteTransparent(vec4 color)
+{
+    if (!oitPass) {
+        FragColor = color;
+        return;
+    }
+    // closer and more opaque fragments weigh more (McGuire and Bavoil, 2013)
+    float weight = clamp(pow(min(1.0, color.a * 10.0) + 0.01, 3.0) * 1e8
+        * pow(1.0 - gl_FragCoord.z * 0.9, 3.0), 1e-2, 3e3);
+    FragColor = vec4(color.rgb * color.a, color.a) * weight;
+    Revealage = color.a;
+}
@@ -0,0 +1,17 @@
+#version 410 core
+out vec4 FragColor;
+
+uniform sampler2D accum;     // weighted sum of premultiplied colors and alphas
+uniform sampler2D revealage; // product of the transparencies
+
+// average color of the transparent fragments, blended with ONE_MINUS_SRC_ALPHA,
+// SRC_ALPHA so the revealage keeps that much of the opaque scene
+void main()
+{
+    ivec2 texel = ivec2(gl_FragCoord.xy);
+    float reveal = texelFetch(revealage, texel, 0).r;
+    if (reveal == 1.0)
+        discard;
+    vec4 sum = texelFetch(accum, texel, 0);
+    FragColor = vec4(sum.rgb / max(sum.a, 1e-5), reveal);
+}
@@ -3,10 +3,15 @@ uniform sampler2D tex0;
 out vec4 FragColor;
 in vec2 fUV;
 in vec4 fColor;
+in vec3 fWorldPos;
+
+#include "fog.glsl"
+
 void main (void)
 {
-  vec2 uv = fUV.xy;
-  uv.y *= -1.0;
-  vec4 texColor = texture(tex0, uv);
+  vec4 texColor = texture(tex0, fUV);
   FragColor = texColor * fColor;
+  // the particles are added to the scene, so the fog fades them out instead
+  // of adding its color
+  FragColor.a *= 1.0 - FogAmount(fWorldPos);
 }
\ No newline at end of file
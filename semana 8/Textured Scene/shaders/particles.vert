@@ -4,6 +4,7 @@ layout (location = 1) in vec4 aColor;
 
 out VS_OUT {
     vec4 color;
+    vec3 worldPos;
 } vs_out;
 
 out float seed;
@@ -14,6 +15,7 @@ uniform mat4 view;
 void main()
 {
     vs_out.color = aColor;
+    vs_out.worldPos = vec3(model * vec4(aPos, 1.0));
     seed = aPos.x;
     gl_Position = model * view * vec4(aPos, 1.0);
 }
\ No newline at end of file
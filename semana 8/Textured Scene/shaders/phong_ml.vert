@@ -6,6 +6,7 @@ layout (location = 2) in vec2 texCoord;
 out vec3 FragPos;
 out vec3 Normal;
 out vec2 TexCoord;
+out float ViewDepth;
 
 uniform mat4 model;
 uniform mat4 view;
@@ -16,5 +17,7 @@ void main()
     FragPos = vec3(model * vec4(aPos, 1.0));
     Normal = mat3(transpose(inverse(model))) * aNormal; 
     TexCoord = texCoord;
+    // distance along the camera axis, picks the shadow cascade
+    ViewDepth = -(view * vec4(FragPos, 1.0)).z;
     gl_Position = projection * view * vec4(FragPos, 1.0);
 }
\ No newline at end of file
@@ -0,0 +1,41 @@
+#version 410 core
+struct Surface {
+    vec3 color;
+    float reflectivity; // Fresnel reflectance at normal incidence
+    float distortion;   // offset of the ripples in texture coordinates
+    bool hasTexture;
+    sampler2D texture;
+};
+
+in vec3 FragPos;
+in vec3 Normal;
+in vec2 TexCoord;
+
+out vec4 FragColor;
+
+uniform Surface surface;
+uniform sampler2D reflection; // the mirrored scene, the size of the screen
+uniform vec3 planeNormal;
+uniform vec3 viewPos;
+uniform vec2 screenSize;
+uniform float time;
+
+void main()
+{
+    // the reflection was rendered with the same projection, so the pixel
+    // position is also its texture coordinate
+    vec2 uv = gl_FragCoord.xy / screenSize;
+    vec2 ripple = vec2(sin(TexCoord.y * 60.0 + time * 2.0), cos(TexCoord.x * 60.0 + time * 1.5));
+    uv = clamp(uv + ripple * surface.distortion, 0.0, 1.0);
+    vec3 mirrored = texture(reflection, uv).rgb;
+
+    vec3 I = normalize(FragPos - viewPos);
+    float cosTheta = abs(dot(-I, planeNormal));
+    float fresnel = surface.reflectivity + (1.0 - surface.reflectivity) * pow(1.0 - cosTheta, 5.0);
+
+    vec3 color = surface.color;
+    if (surface.hasTexture) {
+        color *= texture(surface.texture, TexCoord).rgb;
+    }
+    FragColor = vec4(mix(color, mirrored * surface.color, fresnel), 1.0);
+}
@@ -0,0 +1,10 @@
+#version 410 core
+out vec2 TexCoord;
+
+// one triangle covering the screen, no vertex buffers needed
+void main()
+{
+    vec2 pos = vec2((gl_VertexID << 1) & 2, gl_VertexID & 2);
+    TexCoord = pos;
+    gl_Position = vec4(pos * 2.0 - 1.0, 0.0, 1.0);
+}
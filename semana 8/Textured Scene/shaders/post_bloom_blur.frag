@@ -0,0 +1,19 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform vec2 texelSize;
+uniform vec2 direction; // (1, 0) horizontal pass, (0, 1) vertical pass
+
+const float weights[5] = float[](0.227027, 0.1945946, 0.1216216, 0.054054, 0.016216);
+
+void main()
+{
+    vec2 offset = direction * texelSize;
+    vec3 result = texture(screen, TexCoord).rgb * weights[0];
+    for (int i = 1; i < 5; i++) {
+        result += texture(screen, TexCoord + offset * float(i)).rgb * weights[i];
+        result += texture(screen, TexCoord - offset * float(i)).rgb * weights[i];
+    }
+    FragColor = vec4(result, 1.0);
+}
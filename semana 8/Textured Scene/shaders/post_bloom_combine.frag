@@ -0,0 +1,12 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform sampler2D bloom;
+uniform float intensity;
+
+void main()
+{
+    vec4 src = texture(screen, TexCoord);
+    FragColor = vec4(src.rgb + texture(bloom, TexCoord).rgb * intensity, src.a);
+}
@@ -0,0 +1,13 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform float threshold;
+
+void main()
+{
+    vec3 src = texture(screen, TexCoord).rgb;
+    float brightness = dot(src, vec3(0.2126, 0.7152, 0.0722));
+    // soft knee so the pixels right above the threshold do not pop
+    FragColor = vec4(src * max(brightness - threshold, 0.0) / max(brightness, 0.0001), 1.0);
+}
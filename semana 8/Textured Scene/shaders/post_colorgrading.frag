@@ -0,0 +1,26 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform sampler2D lut; // lutSize slices of lutSize x lutSize texels, blue grows by slice
+uniform float lutSize;
+uniform float strength;
+
+vec3 lookup(vec3 c)
+{
+    float n = lutSize;
+    float b = c.b * (n - 1.0);
+    float b0 = floor(b);
+    float b1 = min(b0 + 1.0, n - 1.0);
+    vec2 uv = (c.rg * (n - 1.0) + 0.5) / vec2(n * n, n);
+    vec3 c0 = texture(lut, uv + vec2(b0 / n, 0.0)).rgb;
+    vec3 c1 = texture(lut, uv + vec2(b1 / n, 0.0)).rgb;
+    return mix(c0, c1, b - b0);
+}
+
+void main()
+{
+    vec4 src = texture(screen, TexCoord);
+    vec3 graded = lookup(clamp(src.rgb, 0.0, 1.0));
+    FragColor = vec4(mix(src.rgb, graded, strength), src.a);
+}
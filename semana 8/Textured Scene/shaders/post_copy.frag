@@ -0,0 +1,9 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+
+void main()
+{
+    FragColor = texture(screen, TexCoord);
+}
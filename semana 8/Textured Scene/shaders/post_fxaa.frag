@@ -0,0 +1,43 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform vec2 texelSize;
+uniform float spanMax;
+uniform float reduceMul;
+uniform float reduceMin;
+
+void main()
+{
+    vec3 rgbNW = texture(screen, TexCoord + vec2(-1.0, -1.0) * texelSize).rgb;
+    vec3 rgbNE = texture(screen, TexCoord + vec2(1.0, -1.0) * texelSize).rgb;
+    vec3 rgbSW = texture(screen, TexCoord + vec2(-1.0, 1.0) * texelSize).rgb;
+    vec3 rgbSE = texture(screen, TexCoord + vec2(1.0, 1.0) * texelSize).rgb;
+    vec4 rgbaM = texture(screen, TexCoord);
+
+    vec3 luma = vec3(0.299, 0.587, 0.114);
+    float lumaNW = dot(rgbNW, luma);
+    float lumaNE = dot(rgbNE, luma);
+    float lumaSW = dot(rgbSW, luma);
+    float lumaSE = dot(rgbSE, luma);
+    float lumaM = dot(rgbaM.rgb, luma);
+    float lumaMin = min(lumaM, min(min(lumaNW, lumaNE), min(lumaSW, lumaSE)));
+    float lumaMax = max(lumaM, max(max(lumaNW, lumaNE), max(lumaSW, lumaSE)));
+
+    // blur along the edge, perpendicular to the luma gradient
+    vec2 dir = vec2(-((lumaNW + lumaNE) - (lumaSW + lumaSE)), (lumaNW + lumaSW) - (lumaNE + lumaSE));
+    float dirReduce = max((lumaNW + lumaNE + lumaSW + lumaSE) * 0.25 * reduceMul, reduceMin);
+    float rcpDirMin = 1.0 / (min(abs(dir.x), abs(dir.y)) + dirReduce);
+    dir = clamp(dir * rcpDirMin, vec2(-spanMax), vec2(spanMax)) * texelSize;
+
+    vec3 rgbA = 0.5 * (texture(screen, TexCoord + dir * (1.0 / 3.0 - 0.5)).rgb +
+                       texture(screen, TexCoord + dir * (2.0 / 3.0 - 0.5)).rgb);
+    vec3 rgbB = rgbA * 0.5 + 0.25 * (texture(screen, TexCoord + dir * -0.5).rgb +
+                                     texture(screen, TexCoord + dir * 0.5).rgb);
+    float lumaB = dot(rgbB, luma);
+    if (lumaB < lumaMin || lumaB > lumaMax) {
+        FragColor = vec4(rgbA, rgbaM.a);
+    } else {
+        FragColor = vec4(rgbB, rgbaM.a);
+    }
+}
@@ -0,0 +1,11 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform float gamma;
+
+void main()
+{
+    vec4 linear = texture(screen, TexCoord);
+    FragColor = vec4(pow(linear.rgb, vec3(1.0 / gamma)), linear.a);
+}
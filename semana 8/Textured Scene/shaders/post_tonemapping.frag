@@ -0,0 +1,23 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform float exposure;
+uniform float aces; // > 0.5 uses the ACES filmic curve instead of Reinhard
+
+vec3 acesFilm(vec3 x)
+{
+    return clamp((x * (2.51 * x + 0.03)) / (x * (2.43 * x + 0.59) + 0.14), 0.0, 1.0);
+}
+
+void main()
+{
+    vec4 hdr = texture(screen, TexCoord);
+    vec3 mapped = hdr.rgb * exposure;
+    if (aces > 0.5) {
+        mapped = acesFilm(mapped);
+    } else {
+        mapped = mapped / (mapped + vec3(1.0));
+    }
+    FragColor = vec4(mapped, hdr.a);
+}
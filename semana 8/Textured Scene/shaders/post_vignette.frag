@@ -0,0 +1,15 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+uniform sampler2D screen;
+uniform float radius;
+uniform float softness;
+uniform float strength;
+
+void main()
+{
+    vec4 src = texture(screen, TexCoord);
+    float dist = distance(TexCoord, vec2(0.5));
+    float vignette = smoothstep(radius, radius - softness, dist);
+    FragColor = vec4(src.rgb * mix(1.0, vignette, strength), src.a);
+}
@@ -0,0 +1,12 @@
+#version 410 core
+in vec2 TexCoord;
+out vec4 FragColor;
+
+uniform sampler2DArray shadowMap;
+uniform int cascade;
+
+void main()
+{
+    float depth = texture(shadowMap, vec3(TexCoord, cascade)).r;
+    FragColor = vec4(vec3(depth), 1.0);
+}
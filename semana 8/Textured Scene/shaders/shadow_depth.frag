@@ -0,0 +1,6 @@
+#version 410 core
+
+// only the depth buffer is written
+void main()
+{
+}
@@ -0,0 +1,12 @@
+#version 410 core
+in vec3 FragPos;
+
+uniform vec3 lightPos;
+uniform float farPlane;
+
+void main()
+{
+    // linear distance to the light so the lighting shader can compare
+    // it without knowing the projection of each face
+    gl_FragDepth = length(FragPos - lightPos) / farPlane;
+}
@@ -0,0 +1,13 @@
+#version 410 core
+layout (location = 0) in vec3 aPos;
+
+out vec3 FragPos;
+
+uniform mat4 model;
+uniform mat4 lightSpace;
+
+void main()
+{
+    FragPos = vec3(model * vec4(aPos, 1.0));
+    gl_Position = lightSpace * vec4(FragPos, 1.0);
+}
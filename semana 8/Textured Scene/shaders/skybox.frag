@@ -0,0 +1,11 @@
+#version 410 core
+in vec3 TexDir;
+out vec4 FragColor;
+
+uniform samplerCube skybox;
+uniform vec3 tint;
+
+void main()
+{
+    FragColor = texture(skybox, TexDir) * vec4(tint, 1.0);
+}
@@ -0,0 +1,16 @@
+#version 410 core
+layout (location = 0) in vec3 aPos;
+
+uniform mat4 view;
+uniform mat4 projection;
+
+out vec3 TexDir;
+
+void main()
+{
+    TexDir = aPos;
+    // the rotation of the camera only, the sky never gets closer
+    vec4 pos = projection * mat4(mat3(view)) * vec4(aPos, 1.0);
+    // z = w puts the sky at depth 1, behind everything else
+    gl_Position = pos.xyww;
+}
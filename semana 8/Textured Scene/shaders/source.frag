@@ -1,7 +1,11 @@
 #version 410 core
 in vec3 Normal;
 in vec2 TexCoord;
+in vec3 FragPos;
 out vec4 FragColor;
+
+#include "fog.glsl"
+
 uniform vec3 objectColor;
 uniform sampler2D texSampler3;
 void main()
@@ -15,4 +19,5 @@ void main()
         
         FragColor = vec4(objectColor, 1.0);
     }    
+    FragColor.rgb = ApplyFog(FragColor.rgb, FragPos);
 }
\ No newline at end of file
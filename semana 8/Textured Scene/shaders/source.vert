@@ -8,8 +8,10 @@ uniform mat4 projection;
 
 out vec3 Normal;
 out vec2 TexCoord;
+out vec3 FragPos;
 void main()
 {
     TexCoord = texCoord;
-    gl_Position = projection * view * model * vec4(aPos, 1.0);
+    FragPos = vec3(model * vec4(aPos, 1.0));
+    gl_Position = projection * view * vec4(FragPos, 1.0);
 }
\ No newline at end of file
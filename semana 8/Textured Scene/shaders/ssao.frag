@@ -0,0 +1,55 @@
+#version 410 core
+out float FragColor;
+
+// must match maxSSAOSamples in ssao.go
+#define MAX_SAMPLES 64
+
+uniform sampler2D gPosition; // world position and view depth
+uniform sampler2D gNormal;   // world normal, alpha is 0 in the background
+uniform sampler2D noise;     // random rotations around the normal
+uniform vec3 samples[MAX_SAMPLES];
+uniform int numSamples;
+uniform float radius;
+uniform float bias;
+uniform float strength;
+uniform mat4 view;
+uniform mat4 projection;
+
+// fraction of the samples of a hemisphere around the normal that are behind
+// the geometry seen by the camera, 1 is fully open
+void main()
+{
+    vec2 screenSize = vec2(textureSize(gNormal, 0));
+    vec2 uv = gl_FragCoord.xy / screenSize;
+    vec4 normal = texture(gNormal, uv);
+    if (normal.a == 0.0) {
+        FragColor = 1.0;
+        return;
+    }
+
+    // view space, where the depth comparison is simple
+    vec3 fragPos = (view * vec4(texture(gPosition, uv).xyz, 1.0)).xyz;
+    vec3 N = normalize(mat3(view) * normal.xyz);
+    vec2 noiseScale = screenSize / vec2(textureSize(noise, 0));
+    vec3 randomVec = texture(noise, uv * noiseScale).xyz;
+    // tangent space with a random rotation around the normal
+    vec3 T = normalize(randomVec - N * dot(randomVec, N));
+    vec3 B = cross(N, T);
+    mat3 TBN = mat3(T, B, N);
+
+    float occlusion = 0.0;
+    for (int i = 0; i < numSamples; i++) {
+        vec3 samplePos = fragPos + TBN * samples[i] * radius;
+        vec4 offset = projection * vec4(samplePos, 1.0);
+        vec2 sampleUV = offset.xy / offset.w * 0.5 + 0.5;
+        // the background and what is off screen occlude nothing
+        if (any(lessThan(sampleUV, vec2(0.0))) || any(greaterThan(sampleUV, vec2(1.0)))
+            || texture(gNormal, sampleUV).a == 0.0)
+            continue;
+        float sampleDepth = -texture(gPosition, sampleUV).w;
+        // geometry much closer to the camera than the radius is not around the pixel
+        float rangeCheck = smoothstep(0.0, 1.0, radius / abs(fragPos.z - sampleDepth));
+        occlusion += (sampleDepth >= samplePos.z + bias ? 1.0 : 0.0) * rangeCheck;
+    }
+    FragColor = pow(1.0 - occlusion / max(float(numSamples), 1.0), strength);
+}
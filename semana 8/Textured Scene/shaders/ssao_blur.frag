@@ -0,0 +1,18 @@
+#version 410 core
+out float FragColor;
+
+uniform sampler2D ssaoInput;
+
+// box blur the size of the noise texture of ssao.frag, it hides the pattern
+void main()
+{
+    vec2 texelSize = 1.0 / vec2(textureSize(ssaoInput, 0));
+    vec2 uv = gl_FragCoord.xy * texelSize;
+    float result = 0.0;
+    for (int x = -2; x < 2; x++) {
+        for (int y = -2; y < 2; y++) {
+            result += texture(ssaoInput, uv + vec2(float(x), float(y)) * texelSize).r;
+        }
+    }
+    FragColor = result / 16.0;
+}
@@ -0,0 +1,15 @@
+#version 410 core
+layout (location = 0) out vec4 gPosition;
+layout (location = 1) out vec4 gNormal;
+
+in vec3 FragPos;
+in vec3 Normal;
+in float ViewDepth;
+
+// the position and normal targets of gbuffer.frag, for the ssao of the
+// forward renderer
+void main()
+{
+    gPosition = vec4(FragPos, ViewDepth);
+    gNormal = vec4(normalize(Normal), 1.0);
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"git.maze.io/go/math32"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// max shadow maps of each kind, must match the defines in lighting.glsl
+const (
+	maxCascades     = 4
+	maxPointShadows = 2
+)
+
+// texture units used by the shadow maps, after the ones of the materials
+const (
+	dirShadowUnit    = gl.TEXTURE4
+	pointShadowUnit0 = gl.TEXTURE5
+)
+
+// how far behind the camera slices the directional shadow looks for casters
+const shadowCasterPadding = 50
+
+// ShadowSettings are the quality and bias values of a shadow map
+type ShadowSettings struct {
+	Resolution int32
+	// constant bias and the extra bias applied at grazing angles, in depth units
+	Bias      float32
+	SlopeBias float32
+	// PCF samples (2r+1)^2 texels around each fragment, 0 is a single hard sample
+	PCFRadius int32
+	// view distance where each cascade of a directional shadow ends, the last one
+	// is usually the far plane of the camera
+	CascadeSplits []float32
+}
+
+func DefaultShadowSettings() ShadowSettings {
+	return ShadowSettings{
+		Resolution:    2048,
+		Bias:          0.0005,
+		SlopeBias:     0.005,
+		PCFRadius:     1,
+		CascadeSplits: []float32{10, 30, 100},
+	}
+}
+
+// DirectionalShadow is a cascaded shadow map, each cascade is a layer of a
+// depth texture array fitted around a slice of the camera frustum
+type DirectionalShadow struct {
+	Settings ShadowSettings
+
+	target     *Framebuffer
+	depthArray uint32
+	lightSpace [maxCascades]mgl32.Mat4
+}
+
+func NewDirectionalShadow(settings ShadowSettings) (*DirectionalShadow, error) {
+	if len(settings.CascadeSplits) > maxCascades {
+		settings.CascadeSplits = settings.CascadeSplits[:maxCascades]
+	}
+	shadow := DirectionalShadow{Settings: settings}
+
+	gl.GenTextures(1, &shadow.depthArray)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, shadow.depthArray)
+	gl.TexImage3D(gl.TEXTURE_2D_ARRAY, 0, gl.DEPTH_COMPONENT32F, settings.Resolution, settings.Resolution,
+		int32(len(settings.CascadeSplits)), 0, gl.DEPTH_COMPONENT, gl.FLOAT, nil)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	// everything outside the map is lit
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_BORDER)
+	gl.TexParameteri(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_BORDER)
+	borderColor := []float32{1, 1, 1, 1}
+	gl.TexParameterfv(gl.TEXTURE_2D_ARRAY, gl.TEXTURE_BORDER_COLOR, &borderColor[0])
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, 0)
+
+	// the cascades are attached in turn when rendering
+	shadow.target = NewFramebuffer(settings.Resolution, settings.Resolution)
+	shadow.target.AttachDepthLayer(shadow.depthArray, 0)
+	if err := shadow.target.Complete(); err != nil {
+		shadow.Delete()
+		return nil, err
+	}
+
+	return &shadow, nil
+}
+
+// Cascades returns the number of layers of the shadow map
+func (s *DirectionalShadow) Cascades() int {
+	return len(s.Settings.CascadeSplits)
+}
+
+// Update fits every cascade around its slice of the camera frustum seen from direction
+func (s *DirectionalShadow) Update(direction mgl32.Vec3, view mgl32.Mat4, fov, aspect, near float32) {
+	direction = direction.Normalize()
+	up := mgl32.Vec3{0, 1, 0}
+	if math32.Abs(direction.Dot(up)) > 0.99 {
+		up = mgl32.Vec3{0, 0, 1}
+	}
+
+	sliceNear := near
+	for i, sliceFar := range s.Settings.CascadeSplits {
+		corners := frustumCorners(mgl32.Perspective(mgl32.DegToRad(fov), aspect, sliceNear, sliceFar), view)
+		sliceNear = sliceFar
+
+		center := mgl32.Vec3{}
+		for _, corner := range corners {
+			center = center.Add(corner)
+		}
+		center = center.Mul(1.0 / float32(len(corners)))
+
+		// a bounding sphere keeps the size of the cascade when the camera turns
+		radius := float32(0)
+		for _, corner := range corners {
+			radius = math32.Max(radius, corner.Sub(center).Len())
+		}
+		radius = math32.Ceil(radius)
+
+		eye := center.Sub(direction.Mul(radius + shadowCasterPadding))
+		lightView := mgl32.LookAtV(eye, center, up)
+		lightProjection := mgl32.Ortho(-radius, radius, -radius, radius, 0, 2*radius+shadowCasterPadding)
+
+		// move in whole texels so the edges do not shimmer when the camera moves
+		half := float32(s.Settings.Resolution) / 2
+		origin := lightProjection.Mul4(lightView).Mul4x1(mgl32.Vec4{0, 0, 0, 1}).Mul(half)
+		lightProjection[12] += (math32.Floor(origin.X()+0.5) - origin.X()) / half
+		lightProjection[13] += (math32.Floor(origin.Y()+0.5) - origin.Y()) / half
+
+		s.lightSpace[i] = lightProjection.Mul4(lightView)
+	}
+}
+
+// frustumCorners returns the world space corners of the volume seen by projection * view
+func frustumCorners(projection, view mgl32.Mat4) []mgl32.Vec3 {
+	inv := projection.Mul4(view).Inv()
+	corners := make([]mgl32.Vec3, 0, 8)
+	for _, x := range []float32{-1, 1} {
+		for _, y := range []float32{-1, 1} {
+			for _, z := range []float32{-1, 1} {
+				corner := inv.Mul4x1(mgl32.Vec4{x, y, z, 1})
+				corners = append(corners, corner.Vec3().Mul(1/corner.W()))
+			}
+		}
+	}
+	return corners
+}
+
+func (s *DirectionalShadow) Delete() {
+	s.target.Delete()
+	if s.depthArray != 0 {
+		gl.DeleteTextures(1, &s.depthArray)
+	}
+}
+
+// PointShadow is an omnidirectional shadow map, a depth cubemap storing the
+// distance to the light divided by Far
+type PointShadow struct {
+	Settings ShadowSettings
+	Far      float32
+
+	target  *Framebuffer
+	cubemap uint32
+}
+
+func NewPointShadow(settings ShadowSettings, far float32) (*PointShadow, error) {
+	shadow := PointShadow{Settings: settings, Far: far}
+
+	gl.GenTextures(1, &shadow.cubemap)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, shadow.cubemap)
+	for i := 0; i < 6; i++ {
+		gl.TexImage2D(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), 0, gl.DEPTH_COMPONENT32F, settings.Resolution, settings.Resolution,
+			0, gl.DEPTH_COMPONENT, gl.FLOAT, nil)
+	}
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_CUBE_MAP, gl.TEXTURE_WRAP_R, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+
+	// the faces are attached in turn when rendering
+	shadow.target = NewFramebuffer(settings.Resolution, settings.Resolution)
+	shadow.target.AttachDepth(gl.TEXTURE_CUBE_MAP_POSITIVE_X, shadow.cubemap)
+	if err := shadow.target.Complete(); err != nil {
+		shadow.Delete()
+		return nil, err
+	}
+
+	return &shadow, nil
+}
+
+// faceTransforms returns the view projection of each cubemap face in the GL order +X, -X, +Y, -Y, +Z, -Z
+func (s *PointShadow) faceTransforms(position mgl32.Vec3) [6]mgl32.Mat4 {
+	projection := mgl32.Perspective(mgl32.DegToRad(90), 1, 0.1, s.Far)
+	var transforms [6]mgl32.Mat4
+	for i, view := range cubeFaceViews(position) {
+		transforms[i] = projection.Mul4(view)
+	}
+	return transforms
+}
+
+// cubeFaceViews returns the view of each cubemap face seen from position, in
+// the GL order +X, -X, +Y, -Y, +Z, -Z
+func cubeFaceViews(position mgl32.Vec3) [6]mgl32.Mat4 {
+	targets := [6][2]mgl32.Vec3{
+		{{1, 0, 0}, {0, -1, 0}},
+		{{-1, 0, 0}, {0, -1, 0}},
+		{{0, 1, 0}, {0, 0, 1}},
+		{{0, -1, 0}, {0, 0, -1}},
+		{{0, 0, 1}, {0, -1, 0}},
+		{{0, 0, -1}, {0, -1, 0}},
+	}
+	var views [6]mgl32.Mat4
+	for i, target := range targets {
+		views[i] = mgl32.LookAtV(position, position.Add(target[0]), target[1])
+	}
+	return views
+}
+
+func (s *PointShadow) Delete() {
+	s.target.Delete()
+	if s.cubemap != 0 {
+		gl.DeleteTextures(1, &s.cubemap)
+	}
+}
+
+// ShadowRenderer draws the shadow maps of the lights that have one
+type ShadowRenderer struct {
+	depthProgram    *gfx.Program
+	depthModelLoc   int32
+	depthLightLoc   int32
+	pointProgram    *gfx.Program
+	pointModelLoc   int32
+	pointLightLoc   int32
+	pointPosLoc     int32
+	pointFarLoc     int32
+	debugProgram    *gfx.Program
+	debugMapLoc     int32
+	debugCascadeLoc int32
+	debugVAO        uint32
+}
+
+func NewShadowRenderer() (*ShadowRenderer, error) {
+	var r ShadowRenderer
+	var err error
+	r.depthProgram, err = newProgramFromFiles("shaders/shadow_depth.vert", "shaders/shadow_depth.frag")
+	if err != nil {
+		return nil, err
+	}
+	r.pointProgram, err = newProgramFromFiles("shaders/shadow_point.vert", "shaders/shadow_point.frag")
+	if err != nil {
+		r.Delete()
+		return nil, err
+	}
+	r.debugProgram, err = newProgramFromFiles("shaders/shadow_debug.vert", "shaders/shadow_debug.frag")
+	if err != nil {
+		r.Delete()
+		return nil, err
+	}
+
+	r.depthModelLoc = r.depthProgram.GetUniformLocation("model")
+	r.depthLightLoc = r.depthProgram.GetUniformLocation("lightSpace")
+	r.pointModelLoc = r.pointProgram.GetUniformLocation("model")
+	r.pointLightLoc = r.pointProgram.GetUniformLocation("lightSpace")
+	r.pointPosLoc = r.pointProgram.GetUniformLocation("lightPos")
+	r.pointFarLoc = r.pointProgram.GetUniformLocation("farPlane")
+	r.debugMapLoc = r.debugProgram.GetUniformLocation("shadowMap")
+	r.debugCascadeLoc = r.debugProgram.GetUniformLocation("cascade")
+	// core profile needs a bound VAO even if the vertex shader uses no attributes
+	gl.GenVertexArrays(1, &r.debugVAO)
+	return &r, nil
+}
+
+// Render updates the shadow maps of the enabled lights of lm, drawScene must
+// draw every shadow caster setting its model matrix at modelLoc. target is
+// bound again when it returns, nil for the default framebuffer
+func (r *ShadowRenderer) Render(target *Framebuffer, lm *LightManager, view mgl32.Mat4, fov, aspect, near float32,
+	drawScene func(modelLoc int32)) {
+
+	gl.Enable(gl.DEPTH_TEST)
+
+	r.depthProgram.Use()
+	for _, light := range lm.DirLights {
+		shadow := light.Shadow
+		if !light.Enabled || shadow == nil {
+			continue
+		}
+		shadow.Update(light.Direction, view, fov, aspect, near)
+		shadow.target.Bind()
+		for i := 0; i < shadow.Cascades(); i++ {
+			shadow.target.AttachDepthLayer(shadow.depthArray, int32(i))
+			gl.Clear(gl.DEPTH_BUFFER_BIT)
+			gl.UniformMatrix4fv(r.depthLightLoc, 1, false, &shadow.lightSpace[i][0])
+			drawScene(r.depthModelLoc)
+		}
+	}
+
+	r.pointProgram.Use()
+	for _, light := range lm.PointLights {
+		shadow := light.Shadow
+		if !light.Enabled || shadow == nil {
+			continue
+		}
+		shadow.target.Bind()
+		gl.Uniform3fv(r.pointPosLoc, 1, &light.Position[0])
+		gl.Uniform1f(r.pointFarLoc, shadow.Far)
+		for i, transform := range shadow.faceTransforms(light.Position) {
+			shadow.target.AttachDepth(uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X+i), shadow.cubemap)
+			gl.Clear(gl.DEPTH_BUFFER_BIT)
+			gl.UniformMatrix4fv(r.pointLightLoc, 1, false, &transform[0])
+			drawScene(r.pointModelLoc)
+		}
+	}
+
+	target.Bind()
+}
+
+// DrawDebug shows a cascade of shadow in the bottom left corner of the window
+func (r *ShadowRenderer) DrawDebug(shadow *DirectionalShadow, cascade int) {
+	if shadow == nil || cascade < 0 || cascade >= shadow.Cascades() {
+		return
+	}
+	size := int32(height / 3)
+	gl.Viewport(0, 0, size, size)
+	gl.Disable(gl.DEPTH_TEST)
+
+	r.debugProgram.Use()
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, shadow.depthArray)
+	gl.Uniform1i(r.debugMapLoc, 0)
+	gl.Uniform1i(r.debugCascadeLoc, int32(cascade))
+	gl.BindVertexArray(r.debugVAO)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.BindVertexArray(0)
+	gl.BindTexture(gl.TEXTURE_2D_ARRAY, 0)
+
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Viewport(0, 0, width, height)
+}
+
+// Delete frees the programs, it also cleans up a renderer NewShadowRenderer
+// gave up on halfway
+func (r *ShadowRenderer) Delete() {
+	for _, program := range []*gfx.Program{r.depthProgram, r.pointProgram, r.debugProgram} {
+		if program != nil {
+			program.Delete()
+		}
+	}
+	if r.debugVAO != 0 {
+		gl.DeleteVertexArrays(1, &r.debugVAO)
+	}
+}
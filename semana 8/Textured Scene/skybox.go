@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// skyboxLayout is the one of the sky cube, the position is also the direction
+// the cubemap is sampled in
+var skyboxLayout = VertexLayout{Attributes: []VertexAttribute{
+	{Name: "aPos", Location: 0, Components: 3},
+}}
+
+// Skybox draws a cubemap at infinite distance using only the camera rotation,
+// draw it after the opaque models so the hidden pixels are skipped
+type Skybox struct {
+	Cubemap *Cubemap
+	// multiplies the cubemap, ex: to darken it to the background of the scene
+	Tint mgl32.Vec3
+
+	program                           *gfx.Program
+	viewLoc, projectionLoc, skyboxLoc int32
+	tintLoc                           int32
+	cube                              *VertexArray
+	numIndices                        int32
+}
+
+// NewSkybox creates a skybox showing cubemap, the cubemap is left to its owner
+func NewSkybox(cubemap *Cubemap) (*Skybox, error) {
+	program, err := newProgramFromFiles("shaders/skybox.vert", "shaders/skybox.frag")
+	if err != nil {
+		return nil, err
+	}
+	s := Skybox{
+		Cubemap:       cubemap,
+		Tint:          mgl32.Vec3{1, 1, 1},
+		program:       program,
+		viewLoc:       program.GetUniformLocation("view"),
+		projectionLoc: program.GetUniformLocation("projection"),
+		skyboxLoc:     program.GetUniformLocation("skybox"),
+		tintLoc:       program.GetUniformLocation("tint"),
+	}
+
+	vertices, _, _, indices := Cube(2, 2, 2)
+	s.cube, err = NewVertexArray(skyboxLayout, indices, vertices)
+	if err != nil {
+		s.Delete()
+		return nil, err
+	}
+	s.numIndices = int32(len(indices))
+	return &s, nil
+}
+
+// Draw renders the sky at depth 1 with the depth test passing on equal, the
+// depth buffer is left as it is
+func (s *Skybox) Draw(view, projection mgl32.Mat4) {
+	state := saveRenderState()
+	gl.DepthFunc(gl.LEQUAL)
+	gl.DepthMask(false)
+	// the camera is inside the cube, its faces must not be culled
+	gl.Disable(gl.CULL_FACE)
+
+	s.program.Use()
+	gl.UniformMatrix4fv(s.viewLoc, 1, false, &view[0])
+	gl.UniformMatrix4fv(s.projectionLoc, 1, false, &projection[0])
+	gl.Uniform3fv(s.tintLoc, 1, &s.Tint[0])
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, s.Cubemap.ID)
+	gl.Uniform1i(s.skyboxLoc, 0)
+
+	gl.BindVertexArray(s.cube.ID)
+	gl.DrawElements(gl.TRIANGLES, s.numIndices, gl.UNSIGNED_INT, nil)
+	gl.BindVertexArray(0)
+
+	gl.BindTexture(gl.TEXTURE_CUBE_MAP, 0)
+	state.restore()
+}
+
+func (s *Skybox) Delete() {
+	s.cube.Delete()
+	s.program.Delete()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// max samples of the hemisphere kernel, must match ssao.frag
+const maxSSAOSamples = 64
+
+// the occlusion map is sampled by the lighting shaders after the shadow maps
+const ssaoUnit = gl.TEXTURE7
+
+// side of the tiled noise texture, the blur averages the same square so the
+// noise pattern disappears
+const ssaoNoiseSize = 4
+
+// SSAOSettings are the quality and look of the ambient occlusion
+type SSAOSettings struct {
+	// samples in the hemisphere around each pixel, at most maxSSAOSamples
+	Samples int
+	// world units around the pixel that may occlude it
+	Radius float32
+	// depth difference ignored to avoid self occlusion on flat surfaces
+	Bias float32
+	// exponent of the result, higher darkens the occluded corners more
+	Strength float32
+}
+
+func DefaultSSAOSettings() SSAOSettings {
+	return SSAOSettings{
+		Samples:  32,
+		Radius:   0.5,
+		Bias:     0.025,
+		Strength: 1.5,
+	}
+}
+
+// SSAO darkens the ambient light of the pixels enclosed by nearby geometry. It
+// works from a position and normal buffer, the G-buffer of the deferred
+// renderer or its own prepass, and the lighting shaders read the blurred result
+type SSAO struct {
+	Settings SSAOSettings
+
+	kernel [maxSSAOSamples]mgl32.Vec3
+	// kernelSamples is the sample count the kernel was built for, its
+	// samples spread over the whole radius
+	kernelSamples int
+	noise         uint32
+
+	// prepass targets for the forward renderer, same layout as the G-buffer
+	geometry *Framebuffer
+	// raw and blurred occlusion
+	targets [2]*Framebuffer
+
+	geometryProgram *gfx.Program
+	geometryLocs    ssaoGeometryLocations
+	ssaoProgram     *gfx.Program
+	ssaoLocs        ssaoLocations
+	blurProgram     *gfx.Program
+	blurInputLoc    int32
+	emptyVAO        uint32
+
+	locations map[*gfx.Program]occlusionLocations
+}
+
+type ssaoGeometryLocations struct {
+	model, view, projection int32
+}
+
+type ssaoLocations struct {
+	view, projection, position, normal, noise int32
+	numSamples, radius, bias, strength        int32
+	samples                                   [maxSSAOSamples]int32
+}
+
+type occlusionLocations struct {
+	useSSAO, ssaoMap int32
+}
+
+func NewSSAO(settings SSAOSettings) (*SSAO, error) {
+	s := SSAO{
+		Settings:  settings,
+		locations: map[*gfx.Program]occlusionLocations{},
+	}
+
+	random := rand.New(rand.NewSource(1))
+
+	// random rotations around the normal, tiled over the screen
+	noise := make([]float32, 0, ssaoNoiseSize*ssaoNoiseSize*3)
+	for i := 0; i < ssaoNoiseSize*ssaoNoiseSize; i++ {
+		noise = append(noise, random.Float32()*2-1, random.Float32()*2-1, 0)
+	}
+	gl.GenTextures(1, &s.noise)
+	gl.BindTexture(gl.TEXTURE_2D, s.noise)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGB16F, ssaoNoiseSize, ssaoNoiseSize, 0, gl.RGB, gl.FLOAT, gl.Ptr(noise))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.REPEAT)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.REPEAT)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	s.geometry = NewFramebuffer(width, height)
+	s.geometry.AddColor(gl.RGBA32F, gl.RGBA, gl.FLOAT, gl.NEAREST)
+	s.geometry.AddColor(gl.RGBA16F, gl.RGBA, gl.FLOAT, gl.NEAREST)
+	s.geometry.AddDepthBuffer(gl.DEPTH_COMPONENT24)
+	if err := s.geometry.Complete(); err != nil {
+		s.Delete()
+		return nil, err
+	}
+	for i := range s.targets {
+		s.targets[i] = NewFramebuffer(width, height)
+		s.targets[i].AddColor(gl.R8, gl.RED, gl.UNSIGNED_BYTE, gl.NEAREST)
+		if err := s.targets[i].Complete(); err != nil {
+			s.Delete()
+			return nil, err
+		}
+	}
+
+	var err error
+	s.geometryProgram, err = newProgramFromFiles("shaders/phong_ml.vert", "shaders/ssao_geometry.frag")
+	if err != nil {
+		s.Delete()
+		return nil, err
+	}
+	s.geometryLocs = ssaoGeometryLocations{
+		model:      s.geometryProgram.GetUniformLocation("model"),
+		view:       s.geometryProgram.GetUniformLocation("view"),
+		projection: s.geometryProgram.GetUniformLocation("projection"),
+	}
+
+	s.ssaoProgram, err = newProgramFromFiles("shaders/deferred_fullscreen.vert", "shaders/ssao.frag")
+	if err != nil {
+		s.Delete()
+		return nil, err
+	}
+	s.ssaoLocs = ssaoLocations{
+		view:       s.ssaoProgram.GetUniformLocation("view"),
+		projection: s.ssaoProgram.GetUniformLocation("projection"),
+		position:   s.ssaoProgram.GetUniformLocation("gPosition"),
+		normal:     s.ssaoProgram.GetUniformLocation("gNormal"),
+		noise:      s.ssaoProgram.GetUniformLocation("noise"),
+		numSamples: s.ssaoProgram.GetUniformLocation("numSamples"),
+		radius:     s.ssaoProgram.GetUniformLocation("radius"),
+		bias:       s.ssaoProgram.GetUniformLocation("bias"),
+		strength:   s.ssaoProgram.GetUniformLocation("strength"),
+	}
+	for i := range s.ssaoLocs.samples {
+		s.ssaoLocs.samples[i] = s.ssaoProgram.GetUniformLocation(fmt.Sprint("samples[", i, "]"))
+	}
+
+	s.blurProgram, err = newProgramFromFiles("shaders/deferred_fullscreen.vert", "shaders/ssao_blur.frag")
+	if err != nil {
+		s.Delete()
+		return nil, err
+	}
+	s.blurInputLoc = s.blurProgram.GetUniformLocation("ssaoInput")
+
+	gl.GenVertexArrays(1, &s.emptyVAO)
+
+	return &s, nil
+}
+
+// Render draws the positions and normals of the scene with its own prepass and
+// computes the occlusion from them, for the forward renderer. draw gets the
+// model location the same way the shadow passes do. target is bound again when
+// it returns, nil for the default framebuffer. The clear color is left as
+// transparent black
+func (s *SSAO) Render(target *Framebuffer, view, projection mgl32.Mat4, draw func(modelLoc int32)) {
+	s.geometry.Bind()
+	// zero normal alpha marks the background, as in the G-buffer
+	gl.ClearColor(0, 0, 0, 0)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	s.geometryProgram.Use()
+	gl.UniformMatrix4fv(s.geometryLocs.view, 1, false, &view[0])
+	gl.UniformMatrix4fv(s.geometryLocs.projection, 1, false, &projection[0])
+	draw(s.geometryLocs.model)
+
+	s.Compute(target, s.geometry.Texture(0), s.geometry.Texture(1), view, projection)
+}
+
+// buildKernel fills the first samples of the kernel with points in the +Z
+// hemisphere, more of them close to the center where the occlusion matters
+// most. The last one reaches the radius whatever the count. Seeded so the look
+// does not change between runs
+func (s *SSAO) buildKernel(samples int) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < samples; i++ {
+		sample := mgl32.Vec3{
+			random.Float32()*2 - 1,
+			random.Float32()*2 - 1,
+			random.Float32(),
+		}.Normalize().Mul(random.Float32())
+		t := float32(i+1) / float32(samples)
+		s.kernel[i] = sample.Mul(0.1 + 0.9*t*t)
+	}
+	s.kernelSamples = samples
+}
+
+// Compute fills the occlusion map from a width x height position texture, world
+// position and view depth, and a normal texture, world normal with zero alpha in
+// the background. target is bound again when it returns, nil for the default
+// framebuffer
+func (s *SSAO) Compute(target *Framebuffer, position, normal uint32, view, projection mgl32.Mat4) {
+	state := saveRenderState()
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.BLEND)
+	gl.BindVertexArray(s.emptyVAO)
+
+	samples := s.Settings.Samples
+	if samples > maxSSAOSamples {
+		samples = maxSSAOSamples
+	}
+	if samples != s.kernelSamples {
+		s.buildKernel(samples)
+	}
+	s.targets[0].Bind()
+	s.ssaoProgram.Use()
+	gl.UniformMatrix4fv(s.ssaoLocs.view, 1, false, &view[0])
+	gl.UniformMatrix4fv(s.ssaoLocs.projection, 1, false, &projection[0])
+	gl.Uniform1i(s.ssaoLocs.numSamples, int32(samples))
+	gl.Uniform1f(s.ssaoLocs.radius, s.Settings.Radius)
+	gl.Uniform1f(s.ssaoLocs.bias, s.Settings.Bias)
+	gl.Uniform1f(s.ssaoLocs.strength, s.Settings.Strength)
+	for i := 0; i < samples; i++ {
+		gl.Uniform3fv(s.ssaoLocs.samples[i], 1, &s.kernel[i][0])
+	}
+	for i, texture := range []uint32{position, normal, s.noise} {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + i))
+		gl.BindTexture(gl.TEXTURE_2D, texture)
+	}
+	gl.Uniform1i(s.ssaoLocs.position, 0)
+	gl.Uniform1i(s.ssaoLocs.normal, 1)
+	gl.Uniform1i(s.ssaoLocs.noise, 2)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+
+	s.targets[1].Bind()
+	s.blurProgram.Use()
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, s.targets[0].Texture(0))
+	gl.Uniform1i(s.blurInputLoc, 0)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+
+	for i := 2; i >= 0; i-- {
+		gl.ActiveTexture(uint32(gl.TEXTURE0 + i))
+		gl.BindTexture(gl.TEXTURE_2D, 0)
+	}
+	gl.BindVertexArray(0)
+	target.Bind()
+	state.restore()
+}
+
+// Apply binds the occlusion map to program, which must include lighting.glsl
+// and be in use. It has to be disabled when drawing from any other camera than
+// the one of the last Compute
+func (s *SSAO) Apply(program *gfx.Program, enabled bool) {
+	locs, ok := s.locations[program]
+	if !ok {
+		locs = occlusionLocations{
+			useSSAO: program.GetUniformLocation("useSSAO"),
+			ssaoMap: program.GetUniformLocation("ssaoMap"),
+		}
+		s.locations[program] = locs
+	}
+
+	// the sampler keeps its own unit even when disabled, see uploadLights
+	gl.Uniform1i(locs.ssaoMap, ssaoUnit-gl.TEXTURE0)
+	gl.ActiveTexture(ssaoUnit)
+	if enabled {
+		gl.BindTexture(gl.TEXTURE_2D, s.targets[1].Texture(0))
+		gl.Uniform1i(locs.useSSAO, 1)
+	} else {
+		gl.BindTexture(gl.TEXTURE_2D, 0)
+		gl.Uniform1i(locs.useSSAO, 0)
+	}
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+func (s *SSAO) Delete() {
+	if s.noise != 0 {
+		gl.DeleteTextures(1, &s.noise)
+	}
+	s.geometry.Delete()
+	for _, target := range s.targets {
+		target.Delete()
+	}
+	if s.emptyVAO != 0 {
+		gl.DeleteVertexArrays(1, &s.emptyVAO)
+	}
+	for _, program := range []*gfx.Program{s.geometryProgram, s.ssaoProgram, s.blurProgram} {
+		if program != nil {
+			program.Delete()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// streamRegions is the number of frames the GPU may be behind the writes
+const streamRegions = 3
+
+// streamWaitTimeout is how long a write waits for the GPU before giving up on
+// the fence, in nanoseconds
+const streamWaitTimeout = 1000000000
+
+var errStreamLayout = errors.New("stream buffers need an interleaved layout")
+
+// StreamBuffer is a vertex buffer rewritten every frame. It is split in
+// regions used in turn, each guarded by a fence, so a write neither waits for
+// nor overwrites the vertices the GPU is still drawing from
+type StreamBuffer struct {
+	handle  uint32
+	stride  int // bytes of one vertex
+	region  int // bytes of one region
+	fences  [streamRegions]uintptr
+	current int
+	written bool
+}
+
+// NewStreamBuffer creates a stream buffer for up to capacity vertices of
+// stride bytes per write, it grows when a write is bigger
+func NewStreamBuffer(capacity, stride int) *StreamBuffer {
+	b := StreamBuffer{stride: stride}
+	gl.GenBuffers(1, &b.handle)
+	b.allocate(capacity * stride)
+	return &b
+}
+
+// NewStreamVertexArray creates a vertex array reading layout from a stream
+// buffer of capacity vertices. The buffer is not one of the vertex array, it
+// is deleted on its own
+func NewStreamVertexArray(layout VertexLayout, capacity int) (*VertexArray, *StreamBuffer, error) {
+	if !layout.Interleaved {
+		return nil, nil, errStreamLayout
+	}
+	buffer := NewStreamBuffer(capacity, int(layout.Stride()))
+
+	var va VertexArray
+	gl.GenVertexArrays(1, &va.ID)
+	gl.BindVertexArray(va.ID)
+	buffer.Bind()
+	layout.pointInterleaved()
+	gl.BindVertexArray(0)
+	buffer.UnBind()
+	return &va, buffer, nil
+}
+
+// allocate gives the buffer new storage for region bytes per region, the old
+// storage is orphaned so the fences are no longer needed
+func (b *StreamBuffer) allocate(region int) {
+	b.deleteFences()
+	// whole vertices per region, the first vertex of a write is its offset
+	// over the stride
+	b.region = (region + b.stride - 1) / b.stride * b.stride
+	b.current = 0
+	b.written = false
+	b.Bind()
+	gl.BufferData(gl.ARRAY_BUFFER, b.region*streamRegions, nil, gl.STREAM_DRAW)
+	b.UnBind()
+}
+
+func (b *StreamBuffer) Handle() uint32 {
+	return b.handle
+}
+
+func (b *StreamBuffer) Bind() {
+	gl.BindBuffer(gl.ARRAY_BUFFER, b.handle)
+}
+
+func (b *StreamBuffer) UnBind() {
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+}
+
+// Write uploads the vertices of this frame and returns the first one, to pass
+// to DrawArrays. The draws reading the previous write must be issued already
+func (b *StreamBuffer) Write(data []float32) int32 {
+	size := len(data) * 4
+	if size > b.region {
+		b.allocate(size)
+	} else if b.written {
+		// the draws of the last write are all issued, fence its region and
+		// move on to the oldest one
+		b.fences[b.current] = gl.FenceSync(gl.SYNC_GPU_COMMANDS_COMPLETE, 0)
+		b.current = (b.current + 1) % streamRegions
+		if !b.wait(b.current) {
+			// the GPU may still read the region, new storage is safe to
+			// write without waiting any longer
+			b.allocate(b.region)
+		}
+	}
+	b.written = true
+	if size == 0 {
+		return int32(b.current * b.region / b.stride)
+	}
+
+	offset := b.current * b.region
+	b.Bind()
+	ptr := gl.MapBufferRange(gl.ARRAY_BUFFER, offset, size,
+		gl.MAP_WRITE_BIT|gl.MAP_INVALIDATE_RANGE_BIT|gl.MAP_UNSYNCHRONIZED_BIT)
+	if ptr != nil {
+		copy((*[1 << 28]float32)(ptr)[:len(data):len(data)], data)
+		gl.UnmapBuffer(gl.ARRAY_BUFFER)
+	} else {
+		gl.BufferSubData(gl.ARRAY_BUFFER, offset, size, gl.Ptr(data))
+	}
+	b.UnBind()
+	return int32(offset / b.stride)
+}
+
+// wait blocks until the GPU is done with region, false when the fence timed
+// out or the wait failed and the region may still be in use
+func (b *StreamBuffer) wait(region int) bool {
+	fence := b.fences[region]
+	if fence == 0 {
+		return true
+	}
+	status := gl.ClientWaitSync(fence, gl.SYNC_FLUSH_COMMANDS_BIT, streamWaitTimeout)
+	gl.DeleteSync(fence)
+	b.fences[region] = 0
+	return status == gl.ALREADY_SIGNALED || status == gl.CONDITION_SATISFIED
+}
+
+func (b *StreamBuffer) deleteFences() {
+	for i, fence := range b.fences {
+		if fence != 0 {
+			gl.DeleteSync(fence)
+			b.fences[i] = 0
+		}
+	}
+}
+
+// Delete frees the buffer and its fences
+func (b *StreamBuffer) Delete() {
+	b.deleteFences()
+	gl.DeleteBuffers(1, &b.handle)
+}
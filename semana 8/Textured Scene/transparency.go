@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kaitsubaka/glutils/gfx"
+)
+
+// blend modes of the transparent draws
+const (
+	BlendAlpha         = iota // color * alpha over what is behind
+	BlendPremultiplied = iota // color already multiplied by alpha
+	BlendAdditive      = iota // color * alpha added, for glows and particles
+	BlendMultiply      = iota // tints what is behind, like colored glass
+)
+
+// TransparentDraw is a model drawn after the opaque ones
+type TransparentDraw struct {
+	// world position the draws are sorted by, usually the center of the model
+	Center mgl32.Vec3
+	Blend  int
+	// Draw uses its program and draws the model, with oit true the program must
+	// write the weighted outputs of oit.glsl
+	Draw func(oit bool)
+}
+
+// TransparencyPass draws the transparent models of a frame from back to front
+// with depth writes off, so each one blends over what is behind it
+type TransparencyPass struct {
+	// UseOIT draws the BlendAlpha models with weighted blended order independent
+	// transparency instead, it needs no sorting and handles models that cross
+	// each other but the result is an approximation. The other blend modes are
+	// always sorted
+	UseOIT bool
+
+	draws []TransparentDraw
+
+	target    *Framebuffer
+	accum     uint32 // owned by target
+	revealage uint32
+
+	compositeProgram *gfx.Program
+	accumLoc         int32
+	revealageLoc     int32
+	emptyVAO         uint32
+}
+
+func NewTransparencyPass() (*TransparencyPass, error) {
+	var p TransparencyPass
+
+	p.target = NewFramebuffer(width, height)
+	p.accum = p.target.AddColor(gl.RGBA16F, gl.RGBA, gl.FLOAT, gl.NEAREST)
+	p.revealage = p.target.AddColor(gl.R8, gl.RED, gl.UNSIGNED_BYTE, gl.NEAREST)
+	// the depth of the opaque models is copied here, same format as the
+	// default framebuffer so it can be blitted
+	p.target.AddDepthBuffer(gl.DEPTH24_STENCIL8)
+	if err := p.target.Complete(); err != nil {
+		p.Delete()
+		return nil, err
+	}
+
+	var err error
+	p.compositeProgram, err = newProgramFromFiles("shaders/deferred_fullscreen.vert", "shaders/oit_composite.frag")
+	if err != nil {
+		p.Delete()
+		return nil, err
+	}
+	p.accumLoc = p.compositeProgram.GetUniformLocation("accum")
+	p.revealageLoc = p.compositeProgram.GetUniformLocation("revealage")
+	gl.GenVertexArrays(1, &p.emptyVAO)
+
+	return &p, nil
+}
+
+// Add queues a draw for the next Render
+func (p *TransparencyPass) Add(draw TransparentDraw) {
+	p.draws = append(p.draws, draw)
+}
+
+// Render draws the queued models seen through view over target, nil for the
+// default framebuffer, and empties the queue. The blend and depth state are
+// restored
+func (p *TransparencyPass) Render(target *Framebuffer, view mgl32.Mat4) {
+	// the camera looks down -Z, the farthest have the lowest depth
+	depths := make([]float32, len(p.draws))
+	for i := range p.draws {
+		depths[i] = view.Mul4x1(p.draws[i].Center.Vec4(1)).Z()
+	}
+	sort.Stable(byDepth{p.draws, depths})
+
+	state := saveRenderState()
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthFunc(gl.LESS)
+	gl.DepthMask(false)
+	gl.Enable(gl.BLEND)
+
+	sorted := p.draws
+	if p.UseOIT {
+		var alpha []TransparentDraw
+		sorted = nil
+		for _, draw := range p.draws {
+			if draw.Blend == BlendAlpha {
+				alpha = append(alpha, draw)
+			} else {
+				sorted = append(sorted, draw)
+			}
+		}
+		p.renderOIT(target, alpha)
+	}
+
+	for _, draw := range sorted {
+		setBlendMode(draw.Blend)
+		draw.Draw(false)
+	}
+
+	state.restore()
+	p.draws = p.draws[:0]
+}
+
+// renderOIT accumulates the weighted colors and the revealage of draws and
+// composites their average over target
+func (p *TransparencyPass) renderOIT(target *Framebuffer, draws []TransparentDraw) {
+	if len(draws) == 0 {
+		return
+	}
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, target.Handle())
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, p.target.Handle())
+	gl.BlitFramebuffer(0, 0, width, height, 0, 0, width, height, gl.DEPTH_BUFFER_BIT, gl.NEAREST)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, p.target.Handle())
+	zero := [4]float32{0, 0, 0, 0}
+	one := [4]float32{1, 1, 1, 1}
+	gl.ClearBufferfv(gl.COLOR, 0, &zero[0])
+	gl.ClearBufferfv(gl.COLOR, 1, &one[0])
+
+	// the sums do not depend on the order of the draws
+	gl.BlendFunci(0, gl.ONE, gl.ONE)
+	gl.BlendFunci(1, gl.ZERO, gl.ONE_MINUS_SRC_COLOR)
+	for _, draw := range draws {
+		draw.Draw(true)
+	}
+	target.Bind()
+
+	gl.Disable(gl.DEPTH_TEST)
+	gl.BlendFunc(gl.ONE_MINUS_SRC_ALPHA, gl.SRC_ALPHA)
+	p.compositeProgram.Use()
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, p.accum)
+	gl.Uniform1i(p.accumLoc, 0)
+	gl.ActiveTexture(gl.TEXTURE1)
+	gl.BindTexture(gl.TEXTURE_2D, p.revealage)
+	gl.Uniform1i(p.revealageLoc, 1)
+	gl.BindVertexArray(p.emptyVAO)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.BindVertexArray(0)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gl.Enable(gl.DEPTH_TEST)
+}
+
+func setBlendMode(mode int) {
+	switch mode {
+	case BlendPremultiplied:
+		gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	case BlendAdditive:
+		gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
+	case BlendMultiply:
+		gl.BlendFunc(gl.DST_COLOR, gl.ZERO)
+	default:
+		gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	}
+}
+
+// byDepth sorts draws and their view depths from back to front
+type byDepth struct {
+	draws  []TransparentDraw
+	depths []float32
+}
+
+func (s byDepth) Len() int           { return len(s.draws) }
+func (s byDepth) Less(i, j int) bool { return s.depths[i] < s.depths[j] }
+func (s byDepth) Swap(i, j int) {
+	s.draws[i], s.draws[j] = s.draws[j], s.draws[i]
+	s.depths[i], s.depths[j] = s.depths[j], s.depths[i]
+}
+
+func (p *TransparencyPass) Delete() {
+	p.target.Delete()
+	if p.emptyVAO != 0 {
+		gl.DeleteVertexArrays(1, &p.emptyVAO)
+	}
+	if p.compositeProgram != nil {
+		p.compositeProgram.Delete()
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// VertexAttribute is one input of the vertex shader
+type VertexAttribute struct {
+	Name       string // as in the shader, for the errors
+	Location   uint32
+	Components int32 // 1 to 4
+	// Type is the type of each component in the buffer, ex: gl.UNSIGNED_BYTE,
+	// 0 is gl.FLOAT
+	Type uint32
+	// Normalized maps integer types to [0, 1], or [-1, 1] when signed, instead
+	// of converting them as they are
+	Normalized bool
+}
+
+// glType returns Type, gl.FLOAT when it is not set
+func (a VertexAttribute) glType() uint32 {
+	if a.Type == 0 {
+		return gl.FLOAT
+	}
+	return a.Type
+}
+
+// Size is the number of bytes of the attribute in one vertex
+func (a VertexAttribute) Size() int32 {
+	switch a.glType() {
+	case gl.BYTE, gl.UNSIGNED_BYTE:
+		return a.Components
+	case gl.SHORT, gl.UNSIGNED_SHORT, gl.HALF_FLOAT:
+		return a.Components * 2
+	case gl.DOUBLE:
+		return a.Components * 8
+	default:
+		return a.Components * 4
+	}
+}
+
+// point points the attribute to the bound array buffer
+func (a VertexAttribute) point(stride int32, offset int) {
+	gl.VertexAttribPointer(a.Location, a.Components, a.glType(), a.Normalized, stride, gl.PtrOffset(offset))
+	gl.EnableVertexAttribArray(a.Location)
+}
+
+// VertexLayout describes the vertices of a vertex array
+type VertexLayout struct {
+	Attributes []VertexAttribute
+	// Interleaved keeps all the attributes of a vertex together in a single
+	// buffer, otherwise each attribute has a buffer of its own
+	Interleaved bool
+}
+
+var (
+	// meshLayout is the one of the models lit by the phong programs
+	meshLayout = VertexLayout{Attributes: []VertexAttribute{
+		{Name: "aPos", Location: 0, Components: 3},
+		{Name: "aNormal", Location: 1, Components: 3},
+		{Name: "texCoord", Location: 2, Components: 2},
+	}}
+	// particleLayout is the one of the snow, a position and a color per point
+	particleLayout = VertexLayout{Interleaved: true, Attributes: []VertexAttribute{
+		{Name: "aPos", Location: 0, Components: 3},
+		{Name: "aColor", Location: 1, Components: 4},
+	}}
+)
+
+// Stride is the number of bytes of one whole vertex
+func (l VertexLayout) Stride() int32 {
+	var stride int32
+	for _, attribute := range l.Attributes {
+		stride += attribute.Size()
+	}
+	return stride
+}
+
+// Without returns the layout without the attribute called name
+func (l VertexLayout) Without(name string) VertexLayout {
+	layout := VertexLayout{Interleaved: l.Interleaved}
+	for _, attribute := range l.Attributes {
+		if attribute.Name != name {
+			layout.Attributes = append(layout.Attributes, attribute)
+		}
+	}
+	return layout
+}
+
+// validate checks the attributes and that data, one slice when interleaved or
+// one per attribute otherwise, holds the same whole number of vertices for
+// all the attributes, at least one, and returns it. The data is given as
+// floats, the attributes of other types are packed in them
+func (l VertexLayout) validate(data [][]float32) (int, error) {
+	if len(l.Attributes) == 0 {
+		return 0, fmt.Errorf("vertex layout without attributes")
+	}
+	locations := make(map[uint32]string)
+	for _, attribute := range l.Attributes {
+		if attribute.Components < 1 || attribute.Components > 4 {
+			return 0, fmt.Errorf("attribute %s has %d components", attribute.Name, attribute.Components)
+		}
+		if other, ok := locations[attribute.Location]; ok {
+			return 0, fmt.Errorf("attributes %s and %s share location %d", other, attribute.Name, attribute.Location)
+		}
+		locations[attribute.Location] = attribute.Name
+	}
+
+	if l.Interleaved {
+		if len(data) != 1 {
+			return 0, fmt.Errorf("interleaved layout needs 1 slice, got %d", len(data))
+		}
+		if len(data[0]) == 0 {
+			return 0, fmt.Errorf("interleaved layout without vertices")
+		}
+		if len(data[0])*4%int(l.Stride()) != 0 {
+			return 0, fmt.Errorf("%d bytes are not a whole number of %d byte vertices", len(data[0])*4, l.Stride())
+		}
+		return len(data[0]) * 4 / int(l.Stride()), nil
+	}
+
+	if len(data) != len(l.Attributes) {
+		return 0, fmt.Errorf("layout with %d attributes got %d slices", len(l.Attributes), len(data))
+	}
+	count := -1
+	for i, attribute := range l.Attributes {
+		if len(data[i])*4%int(attribute.Size()) != 0 {
+			return 0, fmt.Errorf("attribute %s: %d bytes are not a whole number of %d byte values",
+				attribute.Name, len(data[i])*4, attribute.Size())
+		}
+		n := len(data[i]) * 4 / int(attribute.Size())
+		if count >= 0 && n != count {
+			return 0, fmt.Errorf("attribute %s has %d vertices, %s has %d",
+				attribute.Name, n, l.Attributes[0].Name, count)
+		}
+		count = n
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("vertex layout without vertices")
+	}
+	return count, nil
+}
+
+// VertexArray is a vertex array with the buffers it owns
+type VertexArray struct {
+	ID uint32
+	// Buffers are in the order of the data given to NewVertexArray, so they
+	// can be updated
+	Buffers []uint32
+	// EBO is the element buffer, 0 for arrays drawn with DrawArrays
+	EBO uint32
+}
+
+// NewVertexArray builds a vertex array from data laid out as layout, indices
+// may be nil for arrays drawn with DrawArrays. A layout without attributes or
+// data without vertices is an error
+func NewVertexArray(layout VertexLayout, indices []uint32, data ...[]float32) (*VertexArray, error) {
+	vertices, err := layout.validate(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indices {
+		if int(index) >= vertices {
+			return nil, fmt.Errorf("index %d past the %d vertices", index, vertices)
+		}
+	}
+
+	va := VertexArray{Buffers: make([]uint32, len(data))}
+	gl.GenVertexArrays(1, &va.ID)
+	gl.BindVertexArray(va.ID)
+	gl.GenBuffers(int32(len(va.Buffers)), &va.Buffers[0])
+
+	upload := func(buffer uint32, floats []float32) {
+		gl.BindBuffer(gl.ARRAY_BUFFER, buffer)
+		gl.BufferData(gl.ARRAY_BUFFER, len(floats)*4, gl.Ptr(floats), gl.STATIC_DRAW)
+	}
+	if layout.Interleaved {
+		upload(va.Buffers[0], data[0])
+		layout.pointInterleaved()
+	} else {
+		for i, attribute := range layout.Attributes {
+			upload(va.Buffers[i], data[i])
+			attribute.point(attribute.Size(), 0)
+		}
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	if len(indices) > 0 {
+		gl.GenBuffers(1, &va.EBO)
+		gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, va.EBO)
+		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+	}
+	gl.BindVertexArray(0)
+
+	return &va, nil
+}
+
+// Delete frees the vertex array and its buffers
+func (va *VertexArray) Delete() {
+	if va == nil {
+		return
+	}
+	if va.ID != 0 {
+		gl.DeleteVertexArrays(1, &va.ID)
+	}
+	if len(va.Buffers) > 0 {
+		gl.DeleteBuffers(int32(len(va.Buffers)), &va.Buffers[0])
+	}
+	if va.EBO != 0 {
+		gl.DeleteBuffers(1, &va.EBO)
+	}
+}
+
+// pointInterleaved points the attributes of the bound vertex array to the
+// bound array buffer, all the attributes of a vertex together
+func (l VertexLayout) pointInterleaved() {
+	offset := 0
+	for _, attribute := range l.Attributes {
+		attribute.point(l.Stride(), offset)
+		offset += int(attribute.Size())
+	}
+}